@@ -0,0 +1,127 @@
+package beacon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Capability is something an Adapter's radio can do.
+type Capability uint
+
+// Adapter capabilities.
+const (
+	CapScan Capability = 1 << iota
+	CapAdvertise
+	CapExtendedAdvertising
+	CapConnect
+)
+
+var capabilityNames = []string{"scan", "advertise", "extended-advertising", "connect"}
+
+// Has returns true if c includes all of the capabilities in o.
+func (c Capability) Has(o Capability) bool {
+	return c&o == o
+}
+
+func (c Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// An Adapter is a BLE radio, such as a BLE112 dongle or a BlueZ HCI
+// device, which every backend presents in the same way.
+type Adapter interface {
+	// DeviceType names the kind of radio, such as "ble112" or "hci".
+	DeviceType() string
+	// DeviceVersion returns the radio's firmware version, or "N/A".
+	DeviceVersion() string
+	// DeviceAddress returns the radio's mac address, or "" if it is not
+	// known.
+	DeviceAddress() string
+	// DevicePath returns the path or name the radio was found at.
+	DevicePath() string
+	// Capabilities returns what the radio can do.
+	Capabilities() Capability
+	// Open connects to the radio. It does nothing if already open.
+	Open() error
+	// Close disconnects from the radio.
+	Close() error
+	// Health returns an error if the radio is not open and responding.
+	Health() error
+}
+
+// An AdapterEnumerator lists the adapters of one type present on the
+// machine. The adapters it returns need not be open.
+type AdapterEnumerator func() ([]Adapter, error)
+
+var (
+	enumeratorsMu sync.Mutex
+	enumerators   = make(map[string]AdapterEnumerator)
+)
+
+// RegisterAdapters makes a backend's adapters available through Adapters
+// and FindAdapter. Backends call it from init, so importing a backend
+// is enough to make its adapters available. It panics if name is
+// registered twice.
+func RegisterAdapters(name string, enumerate AdapterEnumerator) {
+	enumeratorsMu.Lock()
+	defer enumeratorsMu.Unlock()
+	if _, dup := enumerators[name]; dup {
+		panic("beacon: RegisterAdapters called twice for " + name)
+	}
+	enumerators[name] = enumerate
+}
+
+// Adapters lists the adapters of every registered backend, in order of
+// backend name. If a backend fails, the adapters of the others are still
+// returned, along with the first error.
+func Adapters() ([]Adapter, error) {
+	enumeratorsMu.Lock()
+	funcs := make(map[string]AdapterEnumerator, len(enumerators))
+	names := make([]string, 0, len(enumerators))
+	for name, e := range enumerators {
+		funcs[name] = e
+		names = append(names, name)
+	}
+	enumeratorsMu.Unlock()
+	sort.Strings(names)
+
+	var adapters []Adapter
+	var err error
+	for _, name := range names {
+		found, e := funcs[name]()
+		if e != nil && err == nil {
+			err = fmt.Errorf("beacon: listing %v adapters: %v", name, e)
+		}
+		adapters = append(adapters, found...)
+	}
+	return adapters, err
+}
+
+// ErrAdapterNotFound is returned by FindAdapter when no adapter matches.
+var ErrAdapterNotFound = errors.New("beacon: adapter not found")
+
+// FindAdapter returns the adapter with the given mac address or path.
+func FindAdapter(addressOrPath string) (Adapter, error) {
+	adapters, err := Adapters()
+	for _, a := range adapters {
+		if strings.EqualFold(a.DeviceAddress(), addressOrPath) || a.DevicePath() == addressOrPath {
+			return a, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrAdapterNotFound
+}
@@ -0,0 +1,74 @@
+package beacon_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+type fakeAdapter struct {
+	address string
+	path    string
+	open    bool
+}
+
+func (a *fakeAdapter) DeviceType() string              { return "fake" }
+func (a *fakeAdapter) DeviceVersion() string           { return "N/A" }
+func (a *fakeAdapter) DeviceAddress() string           { return a.address }
+func (a *fakeAdapter) DevicePath() string              { return a.path }
+func (a *fakeAdapter) Capabilities() beacon.Capability { return beacon.CapScan | beacon.CapConnect }
+func (a *fakeAdapter) Open() error                     { a.open = true; return nil }
+func (a *fakeAdapter) Close() error                    { a.open = false; return nil }
+func (a *fakeAdapter) Health() error                   { return nil }
+
+var fakeAdapters = []beacon.Adapter{
+	&fakeAdapter{address: "00:07:80:aa:bb:cc", path: "/dev/fake0"},
+	&fakeAdapter{address: "00:07:80:dd:ee:ff", path: "/dev/fake1"},
+}
+
+func init() {
+	beacon.RegisterAdapters("fake", func() ([]beacon.Adapter, error) {
+		return fakeAdapters, nil
+	})
+}
+
+func TestAdapters(t *testing.T) {
+	adapters, _ := beacon.Adapters()
+	found := 0
+	for _, a := range adapters {
+		if a.DeviceType() == "fake" {
+			found++
+		}
+	}
+	if found != len(fakeAdapters) {
+		t.Errorf("got %v fake adapters; expected %v", found, len(fakeAdapters))
+	}
+}
+
+func TestFindAdapter(t *testing.T) {
+	a, err := beacon.FindAdapter("00:07:80:DD:EE:FF")
+	if err != nil || a != fakeAdapters[1] {
+		t.Errorf("got %v, %v; expected the adapter with that address", a, err)
+	}
+	a, err = beacon.FindAdapter("/dev/fake0")
+	if err != nil || a != fakeAdapters[0] {
+		t.Errorf("got %v, %v; expected the adapter at that path", a, err)
+	}
+	if _, err := beacon.FindAdapter("/dev/missing"); !errors.Is(err, beacon.ErrAdapterNotFound) {
+		t.Errorf("got %v; expected ErrAdapterNotFound", err)
+	}
+}
+
+func TestCapability(t *testing.T) {
+	c := beacon.CapScan | beacon.CapConnect
+	if !c.Has(beacon.CapScan) || c.Has(beacon.CapScan|beacon.CapAdvertise) {
+		t.Errorf("%v: wrong Has results", c)
+	}
+	if c.String() != "scan,connect" {
+		t.Errorf("got %v; expected scan,connect", c)
+	}
+	if beacon.Capability(0).String() != "none" {
+		t.Errorf("got %v; expected none", beacon.Capability(0))
+	}
+}
@@ -0,0 +1,127 @@
+package advertiser
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/currantlabs/ble"
+)
+
+func init() {
+	beacon.RegisterAdapters(adapterType, func() ([]beacon.Adapter, error) {
+		if !defaultDevicePresent() {
+			return nil, nil
+		}
+		return []beacon.Adapter{DefaultAdapter()}, nil
+	})
+}
+
+// An Adapter is the platform's default BLE hardware, as used by New.
+type Adapter struct {
+	mu     sync.Mutex
+	device ble.Device
+}
+
+var _ beacon.Adapter = (*Adapter)(nil)
+
+var defaultAdapter = &Adapter{}
+
+// DefaultAdapter returns the platform's default BLE hardware. It is
+// opened by Open or NewWithAdapter.
+func DefaultAdapter() *Adapter {
+	return defaultAdapter
+}
+
+// DeviceType returns the kind of hardware, such as "hci" on Linux.
+func (a *Adapter) DeviceType() string {
+	return adapterType
+}
+
+// DeviceVersion returns "N/A"; the platform APIs do not report it.
+func (a *Adapter) DeviceVersion() string {
+	return "N/A"
+}
+
+// DeviceAddress returns the hardware's mac address, if the platform
+// reports it once open.
+func (a *Adapter) DeviceAddress() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d, ok := a.device.(interface{ Address() ble.Addr }); ok {
+		return d.Address().String()
+	}
+	return ""
+}
+
+// DevicePath returns the platform's name for the hardware, such as
+// "hci0" on Linux.
+func (a *Adapter) DevicePath() string {
+	return adapterPath
+}
+
+// Capabilities returns beacon.CapAdvertise.
+func (a *Adapter) Capabilities() beacon.Capability {
+	return beacon.CapAdvertise
+}
+
+// Open opens the hardware.
+func (a *Adapter) Open() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.device != nil {
+		return nil
+	}
+	device, err := defaultDevice()
+	if err != nil {
+		return err
+	}
+	a.device = device
+	return nil
+}
+
+// Close releases the hardware.
+func (a *Adapter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.device == nil {
+		return nil
+	}
+	err := a.device.Stop()
+	a.device = nil
+	return err
+}
+
+// Health returns an error if the hardware is not open.
+func (a *Adapter) Health() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.device == nil {
+		return errors.New("advertiser: adapter not open")
+	}
+	return nil
+}
+
+// Device returns the underlying ble.Device, or nil if the adapter is not
+// open.
+func (a *Adapter) Device() ble.Device {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.device
+}
+
+// NewWithAdapter returns a new Advertiser using the given adapter, which
+// is opened if necessary, configured with the given options.
+func NewWithAdapter(a *Adapter, opts AdvertiseOptions) (Advertiser, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := a.Open(); err != nil {
+		return nil, err
+	}
+	device := a.Device()
+	if err := applyOptions(device, opts); err != nil {
+		return nil, err
+	}
+	return newAdvertiser(device, opts), nil
+}
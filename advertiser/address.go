@@ -0,0 +1,94 @@
+package advertiser
+
+import (
+	"errors"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// A RandomAddressSetter is an Advertiser which can change the random
+// Bluetooth address it advertises from, such as one made by
+// beacon.NewStaticRandomAddress, beacon.NewNonResolvableAddress or
+// beacon.NewResolvableAddress. Hardware refuses while advertising. Some
+// hardware, such as the BLE112, only advertises from a random address
+// non-connectably, and does so once one is set, whatever its options.
+type RandomAddressSetter interface {
+	Advertiser
+	SetRandomAddress(addr beacon.MacAddress) error
+}
+
+// ErrAdvertising is returned when setting the random address of an
+// Advertiser which is advertising.
+var ErrAdvertising = errors.New("advertiser: cannot change address while advertising")
+
+// SetRandomAddress advertises from addr, a random address, from the next
+// call to Advertise on.
+func (a *advertiser) SetRandomAddress(addr beacon.MacAddress) error {
+	a.advMu.Lock()
+	defer a.advMu.Unlock()
+	if a.IsAdvertising() {
+		return ErrAdvertising
+	}
+	return setRandomAddress(a.device, a.opts, addr)
+}
+
+// An AddressGenerator returns a new random address each time it is
+// called.
+type AddressGenerator func() (beacon.MacAddress, error)
+
+// NonResolvableAddresses generates non-resolvable private addresses.
+func NonResolvableAddresses() AddressGenerator {
+	return beacon.NewNonResolvableAddress
+}
+
+// ResolvableAddresses generates resolvable private addresses for the
+// given identity resolving key.
+func ResolvableAddresses(irk []byte) AddressGenerator {
+	irk = append([]byte(nil), irk...)
+	return func() (beacon.MacAddress, error) {
+		return beacon.NewResolvableAddress(irk)
+	}
+}
+
+// RotateAddresses gives a a new address from next now and every period
+// after until ctx is done, as the Bluetooth Core specification suggests
+// private addresses should change every 15 minutes. Whatever a is
+// advertising is stopped for the change, then advertised again until ctx
+// is done. It returns ErrAdvertising if something else, such as a
+// Scheduler, starts advertising with a during the change.
+func RotateAddresses(ctx context.Context, a RandomAddressSetter, next AddressGenerator, period time.Duration, clock Clock) error {
+	if period <= 0 {
+		return errors.New("advertiser: address rotation period must be positive")
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	for {
+		addr, err := next()
+		if err != nil {
+			return err
+		}
+		p, advertising := a.Current()
+		if err := a.StopAdvertising(); err != nil {
+			return err
+		}
+		if err := a.SetRandomAddress(addr); err != nil {
+			return err
+		}
+		if advertising {
+			if err := a.Advertise(ctx, p); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-clock.After(period):
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package advertiser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+func TestRotateAddresses(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	a := &addressingAdvertiser{}
+	a.Advertise(context.Background(), MfgDataPayload(0x0118, ad))
+
+	irk := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- RotateAddresses(ctx, a, ResolvableAddresses(irk), 15*time.Minute, clock) }()
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(15 * time.Minute)
+	}
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a.mu.Lock()
+	addresses := a.addresses
+	a.mu.Unlock()
+	if len(addresses) != 3 || addresses[1] == addresses[2] {
+		t.Errorf("got addresses %v; expected a new one every 15 minutes", addresses)
+	}
+	for _, addr := range addresses {
+		if addr.RandomKind() != beacon.ResolvablePrivate {
+			t.Errorf("got %v; expected a resolvable address", addr)
+		}
+	}
+	// the payload is advertised again under each address
+	if ids := a.advertised(); len(ids) != 4 {
+		t.Errorf("got %d advertisements; expected 4", len(ids))
+	}
+
+	if err := RotateAddresses(context.Background(), a, NonResolvableAddresses(), 0, clock); err == nil {
+		t.Error("expected a zero period to be refused")
+	}
+}
+
+func TestAdvertiserSetRandomAddress(t *testing.T) {
+	a := newAdvertiser(&fakeDevice{}, AdvertiseOptions{})
+	if err := a.Advertise(context.Background(), MfgDataPayload(0x0118, ad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer a.StopAdvertising()
+	if err := a.SetRandomAddress(beacon.MacAddress{1, 2, 3, 4, 5, 6}); err != ErrAdvertising {
+		t.Errorf("got %v; expected ErrAdvertising", err)
+	}
+}
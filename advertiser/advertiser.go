@@ -1,6 +1,10 @@
 package advertiser
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/currantlabs/ble"
 	"golang.org/x/net/context"
 )
@@ -8,53 +12,275 @@ import (
 // An Advertisement contains the bytes of a beacon advertisement.
 type Advertisement []byte
 
-// An Advertiser represents hardware that can advertise as a beacon.
+// MaxPayloadLength is the most AD structures a Payload can frame: what
+// fits in a 31-byte advertisement after the flags.
+const MaxPayloadLength = 28
+
+// A PayloadKind says how a Payload is framed in the advertisement.
+type PayloadKind int
+
+// Payload kinds.
+const (
+	MfgData PayloadKind = iota
+	ServiceData
+)
+
+func (k PayloadKind) String() string {
+	switch k {
+	case MfgData:
+		return "manufacturer data"
+	case ServiceData:
+		return "service data"
+	}
+	return fmt.Sprintf("PayloadKind(%d)", int(k))
+}
+
+// A Payload is what an Advertiser advertises: a beacon advertisement, as
+// generated by beacon.Parser.GenerateAd, sent as manufacturer data under
+// a company id or as service data under a 16-bit service uuid.
+type Payload struct {
+	Kind PayloadKind
+	ID   uint16
+	Data Advertisement
+}
+
+// MfgDataPayload returns a Payload advertising ad as manufacturer data
+// with the given company id.
+func MfgDataPayload(id uint16, ad Advertisement) Payload {
+	return Payload{Kind: MfgData, ID: id, Data: ad}
+}
+
+// ServiceDataPayload returns a Payload advertising ad as service data
+// with the given 16-bit service uuid.
+func ServiceDataPayload(id uint16, ad Advertisement) Payload {
+	return Payload{Kind: ServiceData, ID: id, Data: ad}
+}
+
+// Validate returns an error if p cannot be advertised.
+func (p Payload) Validate() error {
+	if p.Kind != MfgData && p.Kind != ServiceData {
+		return fmt.Errorf("advertiser: unknown payload kind %v", p.Kind)
+	}
+	// the first two bytes are the slot for the company id or 16-bit
+	// service uuid, which GenerateAd leaves empty and ADStructures
+	// replaces with ID
+	if len(p.Data) < 2 {
+		return errors.New("advertiser: advertisement too short")
+	}
+	if n := len(p.ADStructures()); n > MaxPayloadLength {
+		return fmt.Errorf("advertiser: %v payload is %d bytes; at most %d fit", p.Kind, n, MaxPayloadLength)
+	}
+	return nil
+}
+
+// ADStructures returns the AD structures which advertise p, to follow the
+// flags in an advertising packet.
+func (p Payload) ADStructures() []byte {
+	id0, id1 := uint8(p.ID), uint8(p.ID>>8)
+	var header []byte
+	if p.Kind == ServiceData {
+		header = []byte{0x03, 0x03, id0, id1, uint8(len(p.Data) + 1), 0x16, id0, id1}
+	} else {
+		header = []byte{uint8(len(p.Data) + 1), 0xff, id0, id1}
+	}
+	return append(header, p.Data[2:]...)
+}
+
+// An Advertiser represents hardware that can advertise as a beacon. Its
+// methods may be called from several goroutines.
 type Advertiser interface {
-	AdvertiseMfgData(id uint16, ad Advertisement)
-	AdvertiseServiceData(id uint16, ad Advertisement)
-	StopAdvertising()
+	// Advertise starts advertising p, replacing any payload already
+	// being advertised, and returns an error if the hardware fails to
+	// start. Advertising continues until ctx is done or StopAdvertising
+	// is called, after which Advertise may be called again.
+	Advertise(ctx context.Context, p Payload) error
+	// StopAdvertising stops advertising. Stopping an Advertiser which is
+	// not advertising does nothing.
+	StopAdvertising() error
+	// IsAdvertising returns true while a payload is being advertised.
+	IsAdvertising() bool
+	// Current returns the payload being advertised, and false if there
+	// is none.
+	Current() (Payload, bool)
+}
+
+// An ErrorReporter is an Advertiser whose advertisements can fail after
+// Advertise has returned, such as when the hardware is reset.
+type ErrorReporter interface {
+	Advertiser
+	// Errors returns a channel which receives the error ending each
+	// advertisement which fails once started. Errors are dropped while
+	// it is full.
+	Errors() <-chan error
+}
+
+// A controller is a ble.Device which can also start and stop advertising
+// without blocking, returning once the hardware confirms, as the linux
+// HCI can. Other devices only offer calls which block while advertising,
+// so their success is never confirmed, only their failure reported.
+type controller interface {
+	startMfgData(id uint16, b []byte) error
+	startServiceData16(id uint16, b []byte) error
+	stopAdvertising() error
 }
 
 type advertiser struct {
-	device ble.Device
-	ctx    context.Context
-	cancel context.CancelFunc
-	done   chan bool
+	device     ble.Device
+	controller controller // nil if the device has none
+	opts       AdvertiseOptions
+	errs       chan error
+
+	// advMu is held by Advertise and StopAdvertising throughout, so that
+	// one advertisement is stopped before the next is started
+	advMu sync.Mutex
+
+	mu      sync.Mutex // guards the rest
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the advertisement ends; nil if none
+	payload Payload
 }
 
-// New returns a new Advertiser using the default BLE hardware.
+// New returns a new Advertiser using the default BLE hardware. It fails
+// if the hardware cannot be opened or refuses the default advertising
+// parameters.
 func New() (Advertiser, error) {
+	return NewWithOptions(AdvertiseOptions{})
+}
+
+// NewWithOptions returns a new Advertiser using the default BLE hardware,
+// configured with the given options.
+func NewWithOptions(opts AdvertiseOptions) (Advertiser, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 	device, err := defaultDevice()
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	return &advertiser{
-		device: device,
-		ctx:    ctx,
-		cancel: cancel,
-		done:   make(chan bool),
-	}, nil
+	if err := applyOptions(device, opts); err != nil {
+		return nil, err
+	}
+	return newAdvertiser(device, opts), nil
 }
 
-// AdvertiseMfgData advertises manufacturer data with the given mfg id
-func (a *advertiser) AdvertiseMfgData(id uint16, ad Advertisement) {
-	go func() {
-		a.device.AdvertiseMfgData(a.ctx, id, ad[2:])
-		a.done <- true
-	}()
+func newAdvertiser(device ble.Device, opts AdvertiseOptions) *advertiser {
+	c, ok := device.(controller)
+	if !ok {
+		c = platformController(device)
+	}
+	return &advertiser{device: device, controller: c, opts: opts, errs: make(chan error, 1)}
 }
 
-// AdvertiseServiceData advertises service data given a 16bit UUID
-func (a *advertiser) AdvertiseServiceData(id uint16, ad Advertisement) {
+// Advertise advertises p until ctx is done or StopAdvertising is called.
+// It returns once the hardware confirms that advertising has started, or
+// at once if it cannot, when a failure to start is reported to Errors.
+func (a *advertiser) Advertise(ctx context.Context, p Payload) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	a.advMu.Lock()
+	defer a.advMu.Unlock()
+	if err := a.stop(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.controller != nil {
+		var err error
+		if p.Kind == ServiceData {
+			err = a.controller.startServiceData16(p.ID, p.Data[2:])
+		} else {
+			err = a.controller.startMfgData(p.ID, p.Data[2:])
+		}
+		if err != nil {
+			return fmt.Errorf("advertiser: %v", err)
+		}
+	}
+
+	actx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	a.mu.Lock()
+	a.cancel, a.done, a.payload = cancel, done, p
+	a.mu.Unlock()
 	go func() {
-		a.device.AdvertiseServiceData16(a.ctx, id, ad[2:])
-		a.done <- true
+		var err error
+		if a.controller != nil {
+			<-actx.Done()
+			err = a.controller.stopAdvertising()
+		} else if p.Kind == ServiceData {
+			err = a.device.AdvertiseServiceData16(actx, p.ID, p.Data[2:])
+		} else {
+			err = a.device.AdvertiseMfgData(actx, p.ID, p.Data[2:])
+		}
+		if actx.Err() == nil {
+			// ended by the hardware, not ctx or StopAdvertising
+			if err == nil {
+				err = errors.New("advertising stopped")
+			}
+		} else if err == context.Canceled || err == context.DeadlineExceeded {
+			err = nil
+		}
+		a.mu.Lock()
+		if a.done == done {
+			a.cancel, a.done = nil, nil
+		}
+		a.mu.Unlock()
+		cancel()
+		if err != nil {
+			a.report(fmt.Errorf("advertiser: %v", err))
+		}
+		close(done)
 	}()
+	return nil
 }
 
-// StopAdvertising stops the hardware from advertising as a beacon.
-func (a *advertiser) StopAdvertising() {
-	a.cancel()
-	<-a.done
+// Errors returns a channel which receives the error ending each
+// advertisement which fails once started.
+func (a *advertiser) Errors() <-chan error {
+	return a.errs
+}
+
+func (a *advertiser) report(err error) {
+	select {
+	case a.errs <- err:
+	default:
+	}
+}
+
+// StopAdvertising stops advertising and waits for the hardware to stop.
+func (a *advertiser) StopAdvertising() error {
+	a.advMu.Lock()
+	defer a.advMu.Unlock()
+	return a.stop()
+}
+
+func (a *advertiser) stop() error {
+	a.mu.Lock()
+	cancel, done := a.cancel, a.done
+	a.cancel, a.done = nil, nil
+	a.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	return nil
+}
+
+// IsAdvertising returns true while a payload is being advertised.
+func (a *advertiser) IsAdvertising() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.done != nil
+}
+
+// Current returns the payload being advertised.
+func (a *advertiser) Current() (Payload, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.done == nil {
+		return Payload{}, false
+	}
+	return a.payload, true
 }
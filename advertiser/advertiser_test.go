@@ -0,0 +1,275 @@
+package advertiser
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/currantlabs/ble"
+	"golang.org/x/net/context"
+)
+
+// fakeDevice is a ble.Device which starts and stops advertising at once,
+// as the linux HCI does, or fails to start if err is set.
+type fakeDevice struct {
+	ble.Device
+	err error
+
+	mu       sync.Mutex
+	advert   []byte // what is being advertised, or nil
+	adverts  int
+	services int
+}
+
+func (d *fakeDevice) startMfgData(id uint16, b []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advert = b
+	d.adverts++
+	return nil
+}
+
+func (d *fakeDevice) startServiceData16(id uint16, b []byte) error {
+	if err := d.startMfgData(id, b); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.services++
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *fakeDevice) stopAdvertising() error {
+	d.mu.Lock()
+	d.advert = nil
+	d.mu.Unlock()
+	return nil
+}
+
+// blockingDevice is a ble.Device which, like CoreBluetooth, advertises
+// through a call that blocks until its context is done, or until fail
+// sends the error the advertisement fails with.
+type blockingDevice struct {
+	ble.Device
+	fail chan error
+}
+
+func (d *blockingDevice) AdvertiseMfgData(ctx context.Context, id uint16, b []byte) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-d.fail:
+		return err
+	}
+}
+
+func (d *fakeDevice) counts() (adverts int, services int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.adverts, d.services
+}
+
+func (d *fakeDevice) current() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.advert
+}
+
+var ad = Advertisement{0x1b, 0xff, 0xbe, 0xac, 1, 2, 3}
+
+func TestAdvertiser(t *testing.T) {
+	d := &fakeDevice{}
+	a := newAdvertiser(d, AdvertiseOptions{})
+
+	// stopping before advertising does nothing, however often
+	for i := 0; i < 2; i++ {
+		if err := a.StopAdvertising(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, ok := a.Current(); ok || a.IsAdvertising() {
+		t.Error("advertising before Advertise")
+	}
+
+	p := MfgDataPayload(0x0118, ad)
+	if err := a.Advertise(context.Background(), p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(d.current(), ad[2:]) {
+		t.Errorf("got %x; expected the advertisement without its header", d.current())
+	}
+	if current, ok := a.Current(); !ok || current.ID != 0x0118 || !a.IsAdvertising() {
+		t.Errorf("got %+v, %v; expected the payload", current, ok)
+	}
+
+	// replace it, then restart after stopping
+	if err := a.Advertise(context.Background(), ServiceDataPayload(0xfeaa, ad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := a.Current(); current.Kind != ServiceData {
+		t.Errorf("got %+v; expected the service data payload", current)
+	}
+	for i := 0; i < 2; i++ {
+		if err := a.StopAdvertising(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if d.current() != nil || a.IsAdvertising() {
+		t.Error("still advertising after StopAdvertising")
+	}
+	if err := a.Advertise(context.Background(), p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if adverts, services := d.counts(); adverts != 3 || services != 1 || !a.IsAdvertising() {
+		t.Errorf("got %v advertisements; expected to restart", adverts)
+	}
+	a.StopAdvertising()
+}
+
+func TestAdvertiserConcurrent(t *testing.T) {
+	d := &fakeDevice{}
+	a := newAdvertiser(d, AdvertiseOptions{})
+
+	var wg sync.WaitGroup
+	var cancels []context.CancelFunc
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.Advertise(ctx, MfgDataPayload(0x0118, ad)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := a.StopAdvertising(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.current() != nil || a.IsAdvertising() {
+		t.Fatal("an advertisement outlived StopAdvertising")
+	}
+
+	// no advertisement left over from the race stops the next one
+	if err := a.Advertise(context.Background(), ServiceDataPayload(0xfeaa, ad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if d.current() == nil || !a.IsAdvertising() {
+		t.Error("advertisement stopped by an earlier one's context")
+	}
+	a.StopAdvertising()
+}
+
+func TestAdvertiserContext(t *testing.T) {
+	d := &fakeDevice{}
+	a := newAdvertiser(d, AdvertiseOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.Advertise(ctx, MfgDataPayload(0x0118, ad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.IsAdvertising() || d.current() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("still advertising after the context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.Advertise(ctx, MfgDataPayload(0x0118, ad)); err != context.Canceled {
+		t.Errorf("got %v; expected a cancelled context to be refused", err)
+	}
+}
+
+func TestAdvertiserErrors(t *testing.T) {
+	d := &fakeDevice{err: errors.New("hci: command disallowed")}
+	a := newAdvertiser(d, AdvertiseOptions{})
+
+	if err := a.Advertise(context.Background(), MfgDataPayload(0x0118, ad)); err == nil {
+		t.Error("expected the hardware error")
+	}
+	if a.IsAdvertising() {
+		t.Error("advertising after failing to start")
+	}
+	if err := a.Advertise(context.Background(), MfgDataPayload(0x0118, ad[:1])); err == nil {
+		t.Error("expected a short advertisement to be refused")
+	}
+	if err := a.Advertise(context.Background(), Payload{Kind: 7, Data: ad}); err == nil {
+		t.Error("expected an unknown kind to be refused")
+	}
+}
+
+func TestAdvertiserBlockingDevice(t *testing.T) {
+	d := &blockingDevice{fail: make(chan error)}
+	a := newAdvertiser(d, AdvertiseOptions{})
+
+	// with nothing to confirm it, Advertise returns at once
+	start := time.Now()
+	if err := a.Advertise(context.Background(), MfgDataPayload(0x0118, ad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Advertise took %v; expected it not to wait", elapsed)
+	}
+	if !a.IsAdvertising() {
+		t.Error("not advertising after Advertise")
+	}
+
+	// and a failure after the fact is reported
+	d.fail <- errors.New("controller reset")
+	select {
+	case err := <-a.Errors():
+		if err == nil {
+			t.Error("expected the hardware error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure not reported")
+	}
+	if a.IsAdvertising() {
+		t.Error("advertising after failing")
+	}
+
+	// stopping is not a failure
+	a.Advertise(context.Background(), MfgDataPayload(0x0118, ad))
+	a.StopAdvertising()
+	select {
+	case err := <-a.Errors():
+		t.Errorf("got %v; expected stopping not reported", err)
+	default:
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	d := &fakeDevice{}
+	l := Legacy(newAdvertiser(d, AdvertiseOptions{}))
+
+	l.AdvertiseServiceData(0xfeaa, ad)
+	if _, services := d.counts(); !bytes.Equal(d.current(), ad[2:]) || services != 1 {
+		t.Errorf("got %x; expected the service data", d.current())
+	}
+	l.StopAdvertising()
+	l.StopAdvertising()
+	if d.current() != nil {
+		t.Error("still advertising after StopAdvertising")
+	}
+}
+
+func TestPayloadADStructures(t *testing.T) {
+	if got := MfgDataPayload(0x0118, ad).ADStructures(); !bytes.Equal(got, []byte{8, 0xff, 0x18, 0x01, 0xbe, 0xac, 1, 2, 3}) {
+		t.Errorf("got %x", got)
+	}
+	expected := []byte{3, 3, 0xaa, 0xfe, 8, 0x16, 0xaa, 0xfe, 0xbe, 0xac, 1, 2, 3}
+	if got := ServiceDataPayload(0xfeaa, ad).ADStructures(); !bytes.Equal(got, expected) {
+		t.Errorf("got %x", got)
+	}
+}
@@ -0,0 +1,77 @@
+package advertiser
+
+import (
+	"fmt"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// DefaultCompanyID is the company id under which BeaconPayload advertises
+// beacons whose layouts are manufacturer data: Radius Networks' id. The
+// layouts do not say which company a beacon belongs to; callers with
+// their own id use BeaconPayloadWithCompanyID.
+const DefaultCompanyID = 0x0118
+
+// BeaconPayload returns the Payload advertising b, framed as its layout in
+// beacon.DefaultLayouts says: as service data under the layout's service
+// uuid, or as manufacturer data under DefaultCompanyID. It fails if b's
+// type has no layout, if b's fields do not fit the layout, or if the
+// advertisement is too long.
+func BeaconPayload(b *beacon.Beacon) (Payload, error) {
+	return BeaconPayloadWithCompanyID(b, DefaultCompanyID)
+}
+
+// BeaconPayloadWithCompanyID returns the Payload advertising b, as
+// BeaconPayload does, but under the given company id if its layout is
+// manufacturer data.
+func BeaconPayloadWithCompanyID(b *beacon.Beacon, companyID uint16) (Payload, error) {
+	layout, ok := beacon.DefaultLayouts[b.Type]
+	if !ok {
+		return Payload{}, fmt.Errorf("advertiser: no layout for beacon type %q", b.Type)
+	}
+	return BeaconPayloadWithLayout(b, layout, companyID)
+}
+
+// BeaconPayloadWithLayout returns the Payload advertising b, as
+// BeaconPayloadWithCompanyID does, but framed as the given layout says,
+// such as that of an iBeacon, which beacon.DefaultLayouts lacks.
+func BeaconPayloadWithLayout(b *beacon.Beacon, layout string, companyID uint16) (Payload, error) {
+	if b.Type == beacon.BeaconTypeEddystoneUID && len(b.Data) == 0 {
+		// The frame ends in two reserved bytes that carry no beacon data.
+		uid := *b
+		uid.Data = beacon.Fields{{0x00, 0x00}}
+		b = &uid
+	}
+	parser := beacon.NewParser(b.Type, layout)
+	if err := parser.Check(b); err != nil {
+		return Payload{}, fmt.Errorf("advertiser: %v", err)
+	}
+	ad := Advertisement(parser.GenerateAd(b))
+	var p Payload
+	if id, ok := parser.ServiceUUID(); ok {
+		p = ServiceDataPayload(id, ad)
+	} else {
+		p = MfgDataPayload(companyID, ad)
+	}
+	if err := p.Validate(); err != nil {
+		return Payload{}, err
+	}
+	return p, nil
+}
+
+// AdvertiseBeacon advertises b with a, framed by BeaconPayload, until ctx
+// is done or advertising is stopped.
+func AdvertiseBeacon(ctx context.Context, a Advertiser, b *beacon.Beacon) error {
+	return AdvertiseBeaconWithCompanyID(ctx, a, b, DefaultCompanyID)
+}
+
+// AdvertiseBeaconWithCompanyID advertises b with a, framed by
+// BeaconPayloadWithCompanyID, until ctx is done or advertising is stopped.
+func AdvertiseBeaconWithCompanyID(ctx context.Context, a Advertiser, b *beacon.Beacon, companyID uint16) error {
+	p, err := BeaconPayloadWithCompanyID(b, companyID)
+	if err != nil {
+		return err
+	}
+	return a.Advertise(ctx, p)
+}
@@ -0,0 +1,98 @@
+package advertiser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+func TestBeaconPayload(t *testing.T) {
+	uid, _ := beacon.NewEddystoneUIDBeacon("2f234454cf6d4a0fadf2", "000000000001", -41)
+	url, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -41)
+	tlm := beacon.NewBeacon("eddystone_tlm", beacon.Fields{},
+		beacon.Fields{{0}, {0x0b, 0xb8}, {0x18, 0x00}, {0, 0, 0, 1}, {0, 0, 0, 2}}, nil)
+	eid := beacon.NewBeacon("eddystone_eid", beacon.Fields{{1, 2, 3, 4, 5, 6, 7, 8}}, beacon.Fields{}, beacon.FieldFromInt8(-41))
+	beacons := map[string]*beacon.Beacon{
+		"altbeacon":     beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59),
+		"eddystone_uid": uid,
+		"eddystone_url": url,
+		"eddystone_tlm": &tlm,
+		"eddystone_eid": &eid,
+	}
+	for name, layout := range beacon.DefaultLayouts {
+		b, ok := beacons[name]
+		if !ok {
+			t.Errorf("no beacon to test %v", name)
+			continue
+		}
+		p, err := BeaconPayload(b)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", name, err)
+			continue
+		}
+		if name == "altbeacon" {
+			if p.Kind != MfgData || p.ID != DefaultCompanyID {
+				t.Errorf("%v: got %v %#04x; expected manufacturer data", name, p.Kind, p.ID)
+			}
+		} else if p.Kind != ServiceData || p.ID != 0xfeaa {
+			t.Errorf("%v: got %v %#04x; expected eddystone service data", name, p.Kind, p.ID)
+		}
+		data := b.Data
+		if name == "eddystone_uid" {
+			if len(b.Data) != 0 {
+				t.Errorf("%v: beacon data changed to %v", name, b.Data)
+			}
+			data = beacon.Fields{{0x00, 0x00}} // the reserved bytes
+		}
+		parsed := beacon.NewParser(name, layout).Parse(p.Data)
+		if parsed == nil || !parsed.Equal(b) || !reflect.DeepEqual(parsed.Data, data) {
+			t.Errorf("%v: got %v; expected the advertisement to parse back", name, parsed)
+		}
+	}
+}
+
+func TestBeaconPayloadWithCompanyID(t *testing.T) {
+	alt := beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59)
+	p, err := BeaconPayloadWithCompanyID(alt, 0xbeef)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Kind != MfgData || p.ID != 0xbeef {
+		t.Errorf("got %v %#04x; expected manufacturer data under 0xbeef", p.Kind, p.ID)
+	}
+	url, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -41)
+	if p, _ := BeaconPayloadWithCompanyID(url, 0xbeef); p.Kind != ServiceData || p.ID != 0xfeaa {
+		t.Errorf("got %v %#04x; expected the layout's service uuid", p.Kind, p.ID)
+	}
+}
+
+func TestBeaconPayloadErrors(t *testing.T) {
+	short := beacon.NewBeacon("altbeacon", beacon.Fields{{1, 2}, {0, 1}, {0, 2}}, beacon.Fields{{0}}, beacon.FieldFromInt8(-59))
+	missing := beacon.NewBeacon("eddystone_uid", beacon.Fields{make(beacon.Field, 10)}, beacon.Fields{{0, 0}}, beacon.FieldFromInt8(-41))
+	unknown := beacon.NewBeacon("ibeacon", nil, nil, nil)
+	long := beacon.NewBeacon("eddystone_url", beacon.Fields{make(beacon.Field, 19)}, beacon.Fields{}, beacon.FieldFromInt8(-41))
+	for _, b := range []beacon.Beacon{short, missing, unknown, long} {
+		if _, err := BeaconPayload(&b); err == nil {
+			t.Errorf("%v: expected an error", b.Type)
+		}
+	}
+}
+
+func TestAdvertiseBeacon(t *testing.T) {
+	d := &fakeDevice{}
+	a := newAdvertiser(d, AdvertiseOptions{})
+	defer a.StopAdvertising()
+
+	url, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -41)
+	if err := AdvertiseBeacon(context.Background(), a, url); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := a.Current(); current.Kind != ServiceData || current.ID != 0xfeaa {
+		t.Errorf("got %+v; expected eddystone service data", current)
+	}
+	if _, services := d.counts(); services != 1 {
+		t.Errorf("got %v service data advertisements; expected 1", services)
+	}
+}
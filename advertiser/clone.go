@@ -0,0 +1,65 @@
+package advertiser
+
+import (
+	"errors"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// ErrNotScanned is returned when cloning a beacon which was not parsed
+// from a scanned advertisement.
+var ErrNotScanned = errors.New("advertiser: beacon was not scanned")
+
+// ClonePayload returns the Payload re-advertising b, a beacon which a
+// beacon.Scanner found. The beacon's AD structure is reproduced byte for
+// byte as it was received: the same company id or service uuid, measured
+// power, data fields and any bytes its layout does not cover. The rest of
+// the packet is not: the flags and service uuid list are generated afresh
+// when p is advertised, and any other AD structures the packet carried,
+// such as a name, are dropped. If ids is not nil, it replaces b's ids,
+// which it must fit as beacon.Parser.Check requires.
+func ClonePayload(b *beacon.Beacon, ids beacon.Fields) (Payload, error) {
+	ad, parser := b.Ad()
+	if parser == nil || len(ad) < 2 {
+		return Payload{}, ErrNotScanned
+	}
+	if ids != nil {
+		var err error
+		if ad, err = parser.ReplaceIds(ad, ids); err != nil {
+			return Payload{}, err
+		}
+	}
+	// the company id or service uuid leads the data, little endian
+	id := uint16(ad[0]) | uint16(ad[1])<<8
+	var p Payload
+	if _, ok := parser.ServiceUUID(); ok {
+		p = ServiceDataPayload(id, Advertisement(ad))
+	} else {
+		p = MfgDataPayload(id, Advertisement(ad))
+	}
+	if err := p.Validate(); err != nil {
+		return Payload{}, err
+	}
+	return p, nil
+}
+
+// CloneScan returns the Payload re-advertising the beacon in scan, parsed
+// with the first of parsers which matches it, as ClonePayload does.
+func CloneScan(scan beacon.ScanData, parsers []*beacon.Parser, ids beacon.Fields) (Payload, error) {
+	b := beacon.Parse(scan.Bytes, parsers)
+	if b == nil {
+		return Payload{}, errors.New("advertiser: scan is not a beacon advertisement")
+	}
+	return ClonePayload(b, ids)
+}
+
+// AdvertiseClone advertises b with a, cloned by ClonePayload, until ctx is
+// done or advertising is stopped.
+func AdvertiseClone(ctx context.Context, a Advertiser, b *beacon.Beacon, ids beacon.Fields) error {
+	p, err := ClonePayload(b, ids)
+	if err != nil {
+		return err
+	}
+	return a.Advertise(ctx, p)
+}
@@ -0,0 +1,99 @@
+package advertiser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// scannedAd returns the advertisement data b would be scanned as, under
+// the given company id or service uuid.
+func scannedAd(b *beacon.Beacon, id uint16) []byte {
+	ad := beacon.NewParser(b.Type, beacon.DefaultLayouts[b.Type]).GenerateAd(b)
+	ad[0], ad[1] = byte(id), byte(id>>8)
+	return ad
+}
+
+func TestCloneScan(t *testing.T) {
+	alt := beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59)
+	alt.Data[0] = beacon.Field{0x5a}
+	url, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -20)
+	tests := []struct {
+		b    *beacon.Beacon
+		id   uint16
+		kind PayloadKind
+	}{
+		{alt, 0x004c, MfgData},
+		{url, 0xfeaa, ServiceData},
+	}
+	for _, test := range tests {
+		ad := scannedAd(test.b, test.id)
+		p, err := CloneScan(beacon.ScanData{Bytes: ad}, beacon.DefaultParsers(), nil)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.b.Type, err)
+			continue
+		}
+		if p.Kind != test.kind || p.ID != test.id {
+			t.Errorf("%v: got %v %#04x; expected %v %#04x", test.b.Type, p.Kind, p.ID, test.kind, test.id)
+		}
+		if !bytes.Equal(p.Data[2:], ad[2:]) {
+			t.Errorf("%v: got %x; expected %x", test.b.Type, p.Data, ad)
+		}
+	}
+
+	if _, err := CloneScan(beacon.ScanData{Bytes: []byte{1, 2, 3}}, beacon.DefaultParsers(), nil); err == nil {
+		t.Error("expected an error cloning a scan which is not a beacon")
+	}
+}
+
+func TestClonePayloadIds(t *testing.T) {
+	parsers := beacon.DefaultParsers()
+	alt := beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59)
+	scanned := beacon.Parse(scannedAd(alt, 0x004c), parsers)
+	ids := beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 3, 4, -59).Ids
+	p, err := ClonePayload(scanned, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clone := beacon.Parse(p.Data, parsers)
+	if clone == nil || !clone.Equal(&beacon.Beacon{Ids: ids}) || !clone.Power.Equal(alt.Power) || p.ID != 0x004c {
+		t.Errorf("got %v from %+v; expected the ids replaced and the rest kept", clone, p)
+	}
+
+	url, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -20)
+	scanned = beacon.Parse(scannedAd(url, 0xfeaa), parsers)
+	short, _ := beacon.NewEddystoneURLBeacon("https://goo.gl/x", -20)
+	if p, err = ClonePayload(scanned, short.Ids); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clone := beacon.Parse(p.Data, parsers); clone == nil || !clone.Ids[0].Equal(short.Ids[0]) {
+		t.Errorf("got %v; expected the url replaced", clone)
+	}
+
+	if _, err := ClonePayload(scanned, beacon.Fields{{1}, {2}}); err == nil {
+		t.Error("expected an error replacing ids which do not fit")
+	}
+	if _, err := ClonePayload(url, nil); err != ErrNotScanned {
+		t.Errorf("got %v; expected %v", err, ErrNotScanned)
+	}
+}
+
+func TestAdvertiseClone(t *testing.T) {
+	d := &fakeDevice{}
+	a := newAdvertiser(d, AdvertiseOptions{})
+	defer a.StopAdvertising()
+
+	alt := beacon.NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59)
+	scanned := beacon.Parse(scannedAd(alt, 0x004c), beacon.DefaultParsers())
+	if err := AdvertiseClone(context.Background(), a, scanned, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := a.Current(); current.Kind != MfgData || current.ID != 0x004c {
+		t.Errorf("got %+v; expected manufacturer data under the scanned company id", current)
+	}
+	if mfgs, _ := d.counts(); mfgs != 1 {
+		t.Errorf("got %v manufacturer data advertisements; expected 1", mfgs)
+	}
+}
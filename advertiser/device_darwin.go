@@ -1,10 +1,22 @@
 package advertiser
 
 import (
+	"errors"
+
+	"github.com/RadiusNetworks/go-beacon"
 	"github.com/currantlabs/ble"
 	"github.com/currantlabs/ble/darwin"
 )
 
+const (
+	adapterType = "corebluetooth"
+	adapterPath = "corebluetooth"
+)
+
+func defaultDevicePresent() bool {
+	return true
+}
+
 func defaultDevice() (ble.Device, error) {
 	device, err := darwin.NewDevice()
 	if err != nil {
@@ -12,3 +24,23 @@ func defaultDevice() (ble.Device, error) {
 	}
 	return device, nil
 }
+
+// platformController returns nil: CoreBluetooth only reports whether
+// advertising failed to start, through calls which block while it lasts.
+func platformController(device ble.Device) controller {
+	return nil
+}
+
+// applyOptions fails for anything but the defaults, since CoreBluetooth
+// chooses its own advertising parameters.
+func applyOptions(device ble.Device, opts AdvertiseOptions) error {
+	if opts != (AdvertiseOptions{}) {
+		return errors.New("advertising options not supported on macOS")
+	}
+	return nil
+}
+
+// setRandomAddress fails, since CoreBluetooth chooses its own addresses.
+func setRandomAddress(device ble.Device, opts AdvertiseOptions, addr beacon.MacAddress) error {
+	return errors.New("setting the random address not supported on macOS")
+}
@@ -1,10 +1,28 @@
 package advertiser
 
 import (
+	"errors"
+	"os"
+
+	"github.com/RadiusNetworks/go-beacon"
 	"github.com/currantlabs/ble"
 	"github.com/currantlabs/ble/linux"
+	"github.com/currantlabs/ble/linux/adv"
+	"github.com/currantlabs/ble/linux/hci/cmd"
+)
+
+const (
+	adapterType = "hci"
+	adapterPath = "hci0"
 )
 
+// defaultDevicePresent returns true if the kernel has an hci0 device,
+// which is the one linux.NewDevice opens.
+func defaultDevicePresent() bool {
+	_, err := os.Stat("/sys/class/bluetooth/hci0")
+	return err == nil
+}
+
 func defaultDevice() (ble.Device, error) {
 	device, err := linux.NewDevice()
 	if err != nil {
@@ -12,3 +30,86 @@ func defaultDevice() (ble.Device, error) {
 	}
 	return device, nil
 }
+
+// platformController returns the HCI of a linux.Device, whose commands
+// return once the controller has carried them out.
+func platformController(device ble.Device) controller {
+	if d, ok := device.(*linux.Device); ok {
+		return hciController{d.HCI}
+	}
+	return nil
+}
+
+// hciAdvertiser is the part of *hci.HCI which hciController uses.
+type hciAdvertiser interface {
+	SetAdvertisement(ad []byte, sr []byte) error
+	Advertise() error
+	StopAdvertising() error
+}
+
+// hciController frames advertisements itself rather than calling the
+// HCI's AdvertiseMfgData and AdvertiseServiceData16, which return nil
+// when the controller rejects the advertising data.
+type hciController struct {
+	hci hciAdvertiser
+}
+
+func (c hciController) startMfgData(id uint16, b []byte) error {
+	return c.start(adv.ManufacturerData(id, b))
+}
+
+func (c hciController) startServiceData16(id uint16, b []byte) error {
+	return c.start(adv.ServiceData16(id, b))
+}
+
+func (c hciController) start(f adv.Field) error {
+	ad, err := adv.NewPacket(f)
+	if err != nil {
+		return err
+	}
+	if err := c.hci.SetAdvertisement(ad.Bytes(), nil); err != nil {
+		return err
+	}
+	return c.hci.Advertise()
+}
+
+func (c hciController) stopAdvertising() error {
+	return c.hci.StopAdvertising()
+}
+
+// applyOptions sends the advertising parameters to the HCI controller.
+// The controller keeps them until it is reset, so they apply to every
+// later advertisement.
+func applyOptions(device ble.Device, opts AdvertiseOptions) error {
+	if opts.TxPower != nil {
+		return errors.New("setting advertising tx power not supported by HCI")
+	}
+	return device.(*linux.Device).HCI.Send(advertisingParameters(opts, false), nil)
+}
+
+// setRandomAddress sets the HCI controller's random address and switches
+// advertising to it. The controller refuses while advertising.
+func setRandomAddress(device ble.Device, opts AdvertiseOptions, addr beacon.MacAddress) error {
+	hci := device.(*linux.Device).HCI
+	if err := hci.Send(&cmd.LESetRandomAddress{RandomAddress: addr}, nil); err != nil {
+		return err
+	}
+	return hci.Send(advertisingParameters(opts, true), nil)
+}
+
+func advertisingParameters(opts AdvertiseOptions, random bool) *cmd.LESetAdvertisingParameters {
+	min, max := opts.Intervals()
+	params := &cmd.LESetAdvertisingParameters{
+		AdvertisingIntervalMin: IntervalUnits(min),
+		AdvertisingIntervalMax: IntervalUnits(max),
+		AdvertisingType:        0x00, // ADV_IND
+		AdvertisingChannelMap:  uint8(opts.ChannelMap()),
+	}
+	if opts.NonConnectable {
+		params.AdvertisingType = 0x03 // ADV_NONCONN_IND
+	}
+	if random {
+		params.OwnAddressType = 0x01
+	}
+	return params
+}
@@ -0,0 +1,70 @@
+package advertiser
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeHCI records what an hciController sends, failing SetAdvertisement
+// if err is set.
+type fakeHCI struct {
+	err         error
+	ad          []byte
+	advertising bool
+}
+
+func (h *fakeHCI) SetAdvertisement(ad []byte, sr []byte) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.ad = ad
+	return nil
+}
+
+func (h *fakeHCI) Advertise() error {
+	h.advertising = true
+	return nil
+}
+
+func (h *fakeHCI) StopAdvertising() error {
+	h.advertising = false
+	return nil
+}
+
+func TestHCIController(t *testing.T) {
+	h := &fakeHCI{}
+	c := hciController{h}
+	if err := c.startMfgData(0x0118, []byte{0xbe, 0xac}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []byte{0x05, 0xff, 0x18, 0x01, 0xbe, 0xac}; !bytes.Equal(h.ad, expected) {
+		t.Errorf("got % x; expected % x", h.ad, expected)
+	}
+	if !h.advertising {
+		t.Error("expected advertising")
+	}
+	if err := c.startServiceData16(0xfeaa, []byte{0x10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []byte{0x03, 0x03, 0xaa, 0xfe, 0x04, 0x16, 0xaa, 0xfe, 0x10}; !bytes.Equal(h.ad, expected) {
+		t.Errorf("got % x; expected % x", h.ad, expected)
+	}
+	if err := c.stopAdvertising(); err != nil || h.advertising {
+		t.Errorf("got %v, advertising %v; expected stopped", err, h.advertising)
+	}
+}
+
+func TestHCIControllerSetAdvertisementFails(t *testing.T) {
+	h := &fakeHCI{err: errors.New("command disallowed")}
+	a := &advertiser{controller: hciController{h}, errs: make(chan error, 1)}
+	err := a.Advertise(context.Background(), MfgDataPayload(0x0118, Advertisement{0, 0, 0xbe, 0xac}))
+	if err == nil || err.Error() != "advertiser: command disallowed" {
+		t.Errorf("got %v; expected the SetAdvertisement error", err)
+	}
+	if h.advertising || a.IsAdvertising() {
+		t.Error("expected not advertising")
+	}
+}
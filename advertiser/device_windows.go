@@ -2,9 +2,32 @@ package advertiser
 
 import (
 	"errors"
+
+	"github.com/RadiusNetworks/go-beacon"
 	"github.com/currantlabs/ble"
 )
 
+const (
+	adapterType = "none"
+	adapterPath = ""
+)
+
+func defaultDevicePresent() bool {
+	return false
+}
+
 func defaultDevice() (ble.Device, error) {
 	return nil, errors.New("Advertising not supported on Windows")
 }
+
+func platformController(device ble.Device) controller {
+	return nil
+}
+
+func applyOptions(device ble.Device, opts AdvertiseOptions) error {
+	return errors.New("Advertising not supported on Windows")
+}
+
+func setRandomAddress(device ble.Device, opts AdvertiseOptions, addr beacon.MacAddress) error {
+	return errors.New("Advertising not supported on Windows")
+}
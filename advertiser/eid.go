@@ -0,0 +1,133 @@
+package advertiser
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// MaxEIDExponent is the largest Eddystone-EID rotation exponent: an EID
+// rotates every 2^exponent seconds.
+const MaxEIDExponent = 15
+
+// An EIDSource is a FrameSource of Eddystone-EID frames, computed from an
+// identity key registered with a resolver. Each frame carries the EID of
+// the moment it is generated. Its methods may be called from several
+// goroutines.
+type EIDSource struct {
+	// Power is the measured power at 0m in dBm.
+	Power int8
+
+	key      []byte
+	exponent uint8
+	epoch    time.Time
+	clock    Clock
+
+	mu sync.Mutex
+	// the temporary key, which changes every 2^16 seconds
+	tk     []byte
+	tkTime uint32
+}
+
+// NewEIDSource returns an EIDSource for the given 16-byte identity key and
+// rotation exponent. epoch is when the beacon's time counter was zero, as
+// registered with the resolver. It tells the time with clock, or
+// SystemClock if clock is nil.
+func NewEIDSource(identityKey []byte, exponent uint8, epoch time.Time, clock Clock) (*EIDSource, error) {
+	if len(identityKey) != 16 {
+		return nil, fmt.Errorf("advertiser: identity key is %d bytes; expected 16", len(identityKey))
+	}
+	if exponent > MaxEIDExponent {
+		return nil, fmt.Errorf("advertiser: eid exponent %d exceeds %d", exponent, MaxEIDExponent)
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &EIDSource{
+		Power:    -41,
+		key:      append([]byte(nil), identityKey...),
+		exponent: exponent,
+		epoch:    epoch,
+		clock:    clock,
+	}, nil
+}
+
+// Period returns how often the EID rotates.
+func (s *EIDSource) Period() time.Duration {
+	return time.Duration(1<<s.exponent) * time.Second
+}
+
+// beaconTime returns the beacon's time counter at t, in seconds.
+func (s *EIDSource) beaconTime(t time.Time) uint32 {
+	if t.Before(s.epoch) {
+		return 0
+	}
+	return uint32(t.Sub(s.epoch) / time.Second)
+}
+
+// NextRotation returns when the EID after the one at t takes effect.
+func (s *EIDSource) NextRotation(t time.Time) time.Time {
+	ts := s.beaconTime(t)>>s.exponent + 1
+	return s.epoch.Add(time.Duration(ts) << s.exponent * time.Second)
+}
+
+// EID returns the 8-byte ephemeral identifier at t, as the Eddystone-EID
+// specification computes it.
+func (s *EIDSource) EID(t time.Time) []byte {
+	ts := s.beaconTime(t)
+	s.mu.Lock()
+	tk := s.temporaryKey(ts)
+	s.mu.Unlock()
+
+	var data [16]byte
+	data[11] = s.exponent
+	binary.BigEndian.PutUint32(data[12:], ts>>s.exponent<<s.exponent)
+	block, _ := aes.NewCipher(tk)
+	block.Encrypt(data[:], data[:])
+	return data[:8]
+}
+
+// temporaryKey returns the temporary key for beacon time ts, computing it
+// only when the top 16 bits of ts change.
+func (s *EIDSource) temporaryKey(ts uint32) []byte {
+	if s.tk != nil && s.tkTime == ts>>16 {
+		return s.tk
+	}
+	var data [16]byte
+	data[11] = 0xff
+	binary.BigEndian.PutUint16(data[14:], uint16(ts>>16))
+	block, _ := aes.NewCipher(s.key)
+	block.Encrypt(data[:], data[:])
+	s.tk, s.tkTime = data[:], ts>>16
+	return s.tk
+}
+
+// Beacon returns the Eddystone-EID beacon at t.
+func (s *EIDSource) Beacon(t time.Time) *beacon.Beacon {
+	b, _ := beacon.NewEddystoneEIDBeacon(s.EID(t), s.Power)
+	return b
+}
+
+// Payload returns an Eddystone-EID frame of the current EID.
+func (s *EIDSource) Payload() (Payload, error) {
+	return s.payloadAt(s.clock.Now())
+}
+
+func (s *EIDSource) payloadAt(t time.Time) (Payload, error) {
+	return BeaconPayload(s.Beacon(t))
+}
+
+// Rotate advertises the EID with a until ctx is done, replacing it at
+// each rotation boundary. If rotateAddress is set and a is a
+// RandomAddressSetter, a new non-resolvable private address is set with
+// each EID, so that consecutive EIDs cannot be linked by address; on
+// some hardware that makes the advertising non-connectable, as
+// RandomAddressSetter describes.
+func (s *EIDSource) Rotate(ctx context.Context, a Advertiser, rotateAddress bool) error {
+	return rotate(ctx, a, s.clock, s, rotateAddress)
+}
@@ -0,0 +1,118 @@
+package advertiser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+var identityKey = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func TestEIDSource(t *testing.T) {
+	epoch := time.Unix(1500000000, 0)
+	s, err := NewEIDSource(identityKey, 4, epoch, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// computed independently with openssl
+	for _, c := range []struct {
+		ts  time.Duration
+		eid string
+	}{
+		{1000007, "9bb38e89361d63d4"},
+		{1000000, "9bb38e89361d63d4"},
+		{1000023, "ce55731ec3b94f5c"},
+	} {
+		if got := hex.EncodeToString(s.EID(epoch.Add(c.ts * time.Second))); got != c.eid {
+			t.Errorf("at %v: got %v; expected %v", c.ts, got, c.eid)
+		}
+	}
+	if next := s.NextRotation(epoch.Add(1000007 * time.Second)); !next.Equal(epoch.Add(1000016 * time.Second)) {
+		t.Errorf("got next rotation %v; expected 9s later", next.Sub(epoch))
+	}
+	if s.Period() != 16*time.Second {
+		t.Errorf("got period %v; expected 16s", s.Period())
+	}
+
+	if _, err := NewEIDSource(identityKey[:8], 4, epoch, nil); err == nil {
+		t.Error("expected a short identity key to be refused")
+	}
+	if _, err := NewEIDSource(identityKey, 16, epoch, nil); err == nil {
+		t.Error("expected an exponent over 15 to be refused")
+	}
+}
+
+// addressingAdvertiser is a recordingAdvertiser which can set its random
+// address, but only while not advertising.
+type addressingAdvertiser struct {
+	recordingAdvertiser
+	mu        sync.Mutex
+	addresses []beacon.MacAddress
+}
+
+func (a *addressingAdvertiser) SetRandomAddress(addr beacon.MacAddress) error {
+	if a.IsAdvertising() {
+		return errors.New("hci: command disallowed")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addresses = append(a.addresses, addr)
+	return nil
+}
+
+func TestEIDSourceRotate(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000005, 0))
+	s, _ := NewEIDSource(identityKey, 4, time.Unix(0, 0), clock)
+	a := &addressingAdvertiser{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Rotate(ctx, a, true) }()
+
+	var eids [][]byte
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		current, _ := a.Current()
+		b := beacon.NewParser(beacon.BeaconTypeEddystoneEID, beacon.DefaultLayouts[beacon.BeaconTypeEddystoneEID]).Parse(current.Data)
+		eids = append(eids, b.Ids[0])
+		// the first wait is to the boundary, the rest a whole period
+		if i == 0 {
+			clock.Advance(11 * time.Second)
+		} else {
+			clock.Advance(16 * time.Second)
+		}
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"9bb38e89361d63d4", "ce55731ec3b94f5c"}
+	for i, eid := range expected {
+		if got := hex.EncodeToString(eids[i]); got != eid {
+			t.Errorf("rotation %d: got %v; expected %v", i, got, eid)
+		}
+	}
+	if bytes.Equal(eids[1], eids[2]) {
+		t.Error("eid did not rotate")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.addresses) < 3 || a.addresses[0] == a.addresses[1] {
+		t.Errorf("got addresses %v; expected a new one each rotation", a.addresses)
+	}
+	for _, addr := range a.addresses {
+		if addr[5]&0xc0 != 0 {
+			t.Errorf("got %v; expected a non-resolvable address", addr)
+		}
+	}
+	if a.IsAdvertising() {
+		t.Error("still advertising after Rotate returned")
+	}
+}
@@ -0,0 +1,46 @@
+package advertiser
+
+import (
+	"log"
+
+	"golang.org/x/net/context"
+)
+
+// A LegacyAdvertiser has the methods of the original Advertiser
+// interface, which report no errors, for callers not yet moved to
+// Advertise.
+type LegacyAdvertiser interface {
+	AdvertiseMfgData(id uint16, ad Advertisement)
+	AdvertiseServiceData(id uint16, ad Advertisement)
+	StopAdvertising()
+}
+
+// Legacy adapts a to the LegacyAdvertiser interface. Errors are logged
+// rather than returned, and stopping is safe to repeat.
+func Legacy(a Advertiser) LegacyAdvertiser {
+	return legacyAdvertiser{a}
+}
+
+type legacyAdvertiser struct {
+	a Advertiser
+}
+
+func (l legacyAdvertiser) AdvertiseMfgData(id uint16, ad Advertisement) {
+	l.advertise(MfgDataPayload(id, ad))
+}
+
+func (l legacyAdvertiser) AdvertiseServiceData(id uint16, ad Advertisement) {
+	l.advertise(ServiceDataPayload(id, ad))
+}
+
+func (l legacyAdvertiser) advertise(p Payload) {
+	if err := l.a.Advertise(context.Background(), p); err != nil {
+		log.Printf("advertiser: %v", err)
+	}
+}
+
+func (l legacyAdvertiser) StopAdvertising() {
+	if err := l.a.StopAdvertising(); err != nil {
+		log.Printf("advertiser: %v", err)
+	}
+}
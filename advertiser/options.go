@@ -0,0 +1,102 @@
+package advertiser
+
+import (
+	"fmt"
+	"time"
+)
+
+// A ChannelMap selects the advertising channels to use.
+type ChannelMap uint8
+
+// Advertising channels.
+const (
+	Channel37 ChannelMap = 1 << iota
+	Channel38
+	Channel39
+	AllChannels = Channel37 | Channel38 | Channel39
+)
+
+// Advertising interval limits from the Bluetooth 4.x Core specification.
+// Non-connectable advertising may not be faster than
+// MinNonConnectableInterval.
+const (
+	MinInterval               = 20 * time.Millisecond
+	MinNonConnectableInterval = 100 * time.Millisecond
+	MaxInterval               = 10240 * time.Millisecond
+)
+
+// DefaultInterval is the advertising interval used when none is given.
+const DefaultInterval = 100 * time.Millisecond
+
+// AdvertiseOptions configures how hardware advertises. The zero value
+// advertises connectably every 100ms on all three channels at the
+// radio's default transmit power.
+type AdvertiseOptions struct {
+	// MinInterval and MaxInterval bound the advertising interval. Zero
+	// means DefaultInterval; if only one is set, it is used for both.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// Channels selects the advertising channels. Zero means AllChannels.
+	Channels ChannelMap
+	// NonConnectable advertises without accepting connections, which
+	// beacons that only broadcast should prefer.
+	NonConnectable bool
+	// TxPower is the transmit power in dBm. Nil leaves the radio's
+	// default. Hardware rounds down to the nearest power it supports.
+	TxPower *int8
+}
+
+// TxPower returns a pointer to the given transmit power in dBm, for use
+// in AdvertiseOptions.
+func TxPower(dBm int8) *int8 {
+	return &dBm
+}
+
+// Intervals returns the advertising interval bounds, with defaults
+// applied.
+func (o AdvertiseOptions) Intervals() (min time.Duration, max time.Duration) {
+	min, max = o.MinInterval, o.MaxInterval
+	switch {
+	case min == 0 && max == 0:
+		min, max = DefaultInterval, DefaultInterval
+	case min == 0:
+		min = max
+	case max == 0:
+		max = min
+	}
+	return
+}
+
+// ChannelMap returns the advertising channels, with the default applied.
+func (o AdvertiseOptions) ChannelMap() ChannelMap {
+	if o.Channels == 0 {
+		return AllChannels
+	}
+	return o.Channels
+}
+
+// Validate returns an error if the options are outside the limits of the
+// Bluetooth specification.
+func (o AdvertiseOptions) Validate() error {
+	min, max := o.Intervals()
+	lowest := MinInterval
+	if o.NonConnectable {
+		lowest = MinNonConnectableInterval
+	}
+	if min < lowest || max > MaxInterval {
+		return fmt.Errorf("advertising interval %v-%v outside %v-%v", min, max, lowest, MaxInterval)
+	}
+	if min > max {
+		return fmt.Errorf("minimum advertising interval %v exceeds maximum %v", min, max)
+	}
+	if o.Channels&^AllChannels != 0 {
+		return fmt.Errorf("invalid advertising channel map %#x", uint8(o.Channels))
+	}
+	return nil
+}
+
+// IntervalUnits converts an advertising interval into the 0.625ms units
+// used by the Bluetooth specification.
+func IntervalUnits(d time.Duration) uint16 {
+	return uint16(d / (625 * time.Microsecond))
+}
@@ -0,0 +1,51 @@
+package advertiser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdvertiseOptionsValidate(t *testing.T) {
+	valid := []AdvertiseOptions{
+		{},
+		{MinInterval: 20 * time.Millisecond},
+		{MaxInterval: 10240 * time.Millisecond},
+		{MinInterval: 100 * time.Millisecond, MaxInterval: time.Second, NonConnectable: true},
+		{Channels: Channel38},
+	}
+	for _, opts := range valid {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", opts, err)
+		}
+	}
+
+	invalid := []AdvertiseOptions{
+		{MinInterval: 10 * time.Millisecond},
+		{MaxInterval: 11 * time.Second},
+		{MinInterval: 50 * time.Millisecond, NonConnectable: true},
+		{MinInterval: time.Second, MaxInterval: 500 * time.Millisecond},
+		{Channels: 0x08},
+	}
+	for _, opts := range invalid {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func TestAdvertiseOptionsDefaults(t *testing.T) {
+	min, max := AdvertiseOptions{}.Intervals()
+	if min != DefaultInterval || max != DefaultInterval {
+		t.Errorf("got %v-%v; expected %v", min, max, DefaultInterval)
+	}
+	min, max = AdvertiseOptions{MaxInterval: time.Second}.Intervals()
+	if min != time.Second || max != time.Second {
+		t.Errorf("got %v-%v; expected 1s", min, max)
+	}
+	if got := (AdvertiseOptions{}).ChannelMap(); got != AllChannels {
+		t.Errorf("got channel map %#x; expected %#x", got, AllChannels)
+	}
+	if got := IntervalUnits(DefaultInterval); got != 0xa0 {
+		t.Errorf("got %#x units; expected 0xa0", got)
+	}
+}
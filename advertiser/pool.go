@@ -0,0 +1,315 @@
+package advertiser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// ErrAdapterExists is returned by Pool.AddAdapter when an adapter of the
+// same name is already in the pool.
+var ErrAdapterExists = errors.New("advertiser: adapter already in pool")
+
+// A Pool advertises a fleet of beacons, such as the virtual beacons of a
+// simulated store, from several Advertisers, such as a rack of BLE112s.
+// Each beacon is assigned to one adapter, and an adapter with several
+// beacons time-multiplexes them with a Scheduler. The adapters' loads are
+// kept within one beacon of each other, moving as few beacons as
+// possible: when an adapter is removed, or fails to advertise, its
+// beacons move to the others. Its methods may be called from several
+// goroutines, including while Run is running.
+type Pool struct {
+	// OnSkip, if set, is called as Scheduler.OnSkip is, when a beacon's
+	// source fails on the adapter assigned it.
+	OnSkip func(name string, err error)
+	// OnAdapterError, if set, is called with the name of an adapter which
+	// failed to advertise, and the error, once it has been removed from
+	// the pool.
+	OnAdapterError func(name string, err error)
+
+	clock Clock
+
+	mu       sync.Mutex // guards the rest
+	beacons  []*pooled  // in the order added
+	adapters []*poolAdapter
+	ctx      context.Context // Run's, while it runs; nil otherwise
+}
+
+type pooled struct {
+	name    string
+	frame   Frame
+	adapter *poolAdapter // nil while there are no adapters
+}
+
+type poolAdapter struct {
+	name      string
+	scheduler *Scheduler
+	count     int // the number of beacons assigned
+	stop      context.CancelFunc
+	done      chan struct{} // closed when the scheduler stops; nil if never run
+}
+
+// NewPool returns an empty Pool, which times frames with clock, or
+// SystemClock if clock is nil. Its callbacks must be set before adapters
+// are added.
+func NewPool(clock Clock) *Pool {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Pool{clock: clock}
+}
+
+// AddAdapter adds a to the pool under name, such as its mac address, and
+// moves beacons to it from the busiest adapters. If Run is running, a
+// starts advertising at once.
+func (p *Pool) AddAdapter(name string, a Advertiser) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.findAdapter(name) >= 0 {
+		return fmt.Errorf("%w: %q", ErrAdapterExists, name)
+	}
+	adapter := &poolAdapter{name: name, scheduler: NewScheduler(a, p.clock)}
+	adapter.scheduler.OnSkip = p.OnSkip
+	p.adapters = append(p.adapters, adapter)
+	sort.Slice(p.adapters, func(i, j int) bool { return p.adapters[i].name < p.adapters[j].name })
+	p.rebalance()
+	if p.ctx != nil {
+		p.start(adapter)
+	}
+	return nil
+}
+
+// RemoveAdapter stops the adapter of the given name advertising and moves
+// its beacons to the others. It returns false if there is no such
+// adapter.
+func (p *Pool) RemoveAdapter(name string) bool {
+	p.mu.Lock()
+	i := p.findAdapter(name)
+	if i < 0 {
+		p.mu.Unlock()
+		return false
+	}
+	adapter := p.adapters[i]
+	p.remove(adapter)
+	p.mu.Unlock()
+	if adapter.done != nil {
+		<-adapter.done
+	}
+	return true
+}
+
+// Add adds the beacon advertised by f to the pool under name, assigning
+// it to the least busy adapter.
+func (p *Pool) Add(name string, f Frame) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(name) >= 0 {
+		return fmt.Errorf("%w: %q", ErrFrameExists, name)
+	}
+	p.beacons = append(p.beacons, &pooled{name: name, frame: f})
+	p.rebalance()
+	return nil
+}
+
+// AddBeacon adds b, framed by BeaconPayload, to the pool under name.
+func (p *Pool) AddBeacon(name string, b *beacon.Beacon) error {
+	payload, err := BeaconPayload(b)
+	if err != nil {
+		return err
+	}
+	return p.Add(name, Frame{Source: Static(payload)})
+}
+
+// Remove removes the beacon of the given name from the pool, and returns
+// false if there is none.
+func (p *Pool) Remove(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.find(name)
+	if i < 0 {
+		return false
+	}
+	b := p.beacons[i]
+	if b.adapter != nil {
+		b.adapter.scheduler.Remove(name)
+		b.adapter.count--
+	}
+	p.beacons = append(p.beacons[:i], p.beacons[i+1:]...)
+	p.rebalance()
+	return true
+}
+
+// Assignments returns the name of the adapter assigned each beacon, by
+// beacon name. Beacons are unassigned, with an empty adapter name, while
+// the pool has no adapters.
+func (p *Pool) Assignments() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assignments := make(map[string]string, len(p.beacons))
+	for _, b := range p.beacons {
+		assignments[b.name] = ""
+		if b.adapter != nil {
+			assignments[b.name] = b.adapter.name
+		}
+	}
+	return assignments
+}
+
+// Emitting returns the name of the beacon each adapter is advertising,
+// by adapter name. Idle adapters are left out.
+func (p *Pool) Emitting() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	emitting := make(map[string]string, len(p.adapters))
+	for _, a := range p.adapters {
+		if name, ok := a.scheduler.Current(); ok {
+			emitting[a.name] = name
+		}
+	}
+	return emitting
+}
+
+// Run advertises the pool's beacons with its adapters until ctx is done,
+// then stops them all advertising. An adapter which fails is removed from
+// the pool and reported to OnAdapterError. Only one Run may run at a
+// time.
+func (p *Pool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.ctx != nil {
+		p.mu.Unlock()
+		return errors.New("advertiser: pool already running")
+	}
+	p.ctx = ctx
+	for _, a := range p.adapters {
+		p.start(a)
+	}
+	p.mu.Unlock()
+
+	<-ctx.Done()
+
+	p.mu.Lock()
+	p.ctx = nil
+	adapters := append([]*poolAdapter(nil), p.adapters...)
+	p.mu.Unlock()
+	for _, a := range adapters {
+		<-a.done
+	}
+	return nil
+}
+
+// start runs a's scheduler until Run ends or a is removed.
+func (p *Pool) start(a *poolAdapter) {
+	ctx, stop := context.WithCancel(p.ctx)
+	done := make(chan struct{})
+	a.stop, a.done = stop, done
+	go func() {
+		defer close(done)
+		if err := a.scheduler.Run(ctx); err != nil {
+			p.mu.Lock()
+			if i := p.findAdapter(a.name); i >= 0 && p.adapters[i] == a {
+				p.remove(a)
+			}
+			p.mu.Unlock()
+			if p.OnAdapterError != nil {
+				p.OnAdapterError(a.name, err)
+			}
+		}
+	}()
+}
+
+// remove takes a out of the pool, stopping it, and reassigns its beacons.
+func (p *Pool) remove(a *poolAdapter) {
+	i := p.findAdapter(a.name)
+	p.adapters = append(p.adapters[:i], p.adapters[i+1:]...)
+	if a.stop != nil {
+		a.stop()
+	}
+	for _, b := range p.beacons {
+		if b.adapter == a {
+			b.adapter = nil
+		}
+	}
+	a.count = 0
+	p.rebalance()
+}
+
+// rebalance assigns unassigned beacons to the least busy adapters, then
+// moves beacons from the busiest adapter to the least busy until their
+// loads differ by at most one.
+func (p *Pool) rebalance() {
+	if len(p.adapters) == 0 {
+		return
+	}
+	for _, b := range p.beacons {
+		if b.adapter == nil {
+			p.assign(b, p.leastBusy())
+		}
+	}
+	for {
+		lo, hi := p.leastBusy(), p.busiest()
+		if hi.count-lo.count <= 1 {
+			return
+		}
+		// move the beacon added last, leaving the longest assigned be
+		for i := len(p.beacons) - 1; i >= 0; i-- {
+			if b := p.beacons[i]; b.adapter == hi {
+				hi.scheduler.Remove(b.name)
+				hi.count--
+				p.assign(b, lo)
+				break
+			}
+		}
+	}
+}
+
+func (p *Pool) assign(b *pooled, a *poolAdapter) {
+	// frames are validated and names unique, so this cannot fail
+	a.scheduler.Add(b.name, b.frame)
+	a.count++
+	b.adapter = a
+}
+
+func (p *Pool) leastBusy() *poolAdapter {
+	least := p.adapters[0]
+	for _, a := range p.adapters[1:] {
+		if a.count < least.count {
+			least = a
+		}
+	}
+	return least
+}
+
+func (p *Pool) busiest() *poolAdapter {
+	most := p.adapters[0]
+	for _, a := range p.adapters[1:] {
+		if a.count > most.count {
+			most = a
+		}
+	}
+	return most
+}
+
+func (p *Pool) find(name string) int {
+	for i, b := range p.beacons {
+		if b.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Pool) findAdapter(name string) int {
+	for i, a := range p.adapters {
+		if a.name == name {
+			return i
+		}
+	}
+	return -1
+}
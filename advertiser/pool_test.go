@@ -0,0 +1,148 @@
+package advertiser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// loads counts the beacons assigned each adapter.
+func loads(assignments map[string]string) map[string]int {
+	loads := make(map[string]int)
+	for _, adapter := range assignments {
+		loads[adapter]++
+	}
+	return loads
+}
+
+func TestPoolAssignments(t *testing.T) {
+	p := NewPool(nil)
+	for i := 0; i < 5; i++ {
+		if err := p.Add(fmt.Sprint("beacon", i), staticFrame(uint16(i), 1)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := loads(p.Assignments()); got[""] != 5 {
+		t.Errorf("got %v; expected every beacon unassigned without adapters", got)
+	}
+
+	p.AddAdapter("a", &recordingAdvertiser{})
+	p.AddAdapter("b", &recordingAdvertiser{})
+	if got := loads(p.Assignments()); got["a"] != 3 || got["b"] != 2 {
+		t.Errorf("got %v; expected 3 and 2 beacons", got)
+	}
+	before := p.Assignments()
+	p.AddAdapter("c", &recordingAdvertiser{})
+	after := p.Assignments()
+	if got := loads(after); got["a"] != 2 || got["b"] != 2 || got["c"] != 1 {
+		t.Errorf("got %v; expected 2, 2 and 1 beacons", got)
+	}
+	moved := 0
+	for name := range after {
+		if after[name] != before[name] {
+			moved++
+		}
+	}
+	if moved != 1 {
+		t.Errorf("got %d beacons moved; expected 1", moved)
+	}
+
+	if !p.RemoveAdapter("a") || p.RemoveAdapter("a") {
+		t.Error("expected the adapter removed once")
+	}
+	if got := loads(p.Assignments()); got["b"]+got["c"] != 5 || got["b"]-got["c"] > 1 || got["c"]-got["b"] > 1 {
+		t.Errorf("got %v; expected the beacons spread over the rest", got)
+	}
+	if !p.Remove("beacon0") || p.Remove("beacon0") {
+		t.Error("expected the beacon removed once")
+	}
+
+	if err := p.AddAdapter("b", &recordingAdvertiser{}); !errors.Is(err, ErrAdapterExists) {
+		t.Errorf("got %v; expected %v", err, ErrAdapterExists)
+	}
+	if err := p.Add("beacon1", staticFrame(1, 1)); !errors.Is(err, ErrFrameExists) {
+		t.Errorf("got %v; expected %v", err, ErrFrameExists)
+	}
+}
+
+func TestPoolRun(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	p := NewPool(clock)
+	failed := make(chan string, 1)
+	p.OnAdapterError = func(name string, err error) { failed <- name }
+	a, b := &recordingAdvertiser{}, &recordingAdvertiser{}
+	p.AddAdapter("a", a)
+	p.AddAdapter("b", b)
+	for i := 0; i < 4; i++ {
+		p.Add(fmt.Sprint("beacon", i), staticFrame(uint16(i), 1))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- p.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-result; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if a.IsAdvertising() || b.IsAdvertising() {
+			t.Error("expected the adapters stopped")
+		}
+	}()
+
+	// each adapter multiplexes its two beacons
+	clock.BlockUntil(2)
+	clock.Advance(200 * time.Millisecond)
+	clock.BlockUntil(2)
+	assignments := p.Assignments()
+	emitting := p.Emitting()
+	for adapter, name := range emitting {
+		if assignments[name] != adapter {
+			t.Errorf("got %v emitting %v; expected it assigned to %v", adapter, name, assignments[name])
+		}
+	}
+	if len(emitting) != 2 {
+		t.Errorf("got %v; expected both adapters emitting", emitting)
+	}
+	if got := a.advertised(); len(got) != 2 || got[0] == got[1] {
+		t.Errorf("got %v; expected both of a's beacons advertised in turn", got)
+	}
+
+	// a failing adapter is dropped, and b takes over its beacons
+	a.mu.Lock()
+	a.err = errors.New("unplugged")
+	a.mu.Unlock()
+	clock.Advance(200 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for loads(p.Assignments())["b"] != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v; expected b assigned every beacon", p.Assignments())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := p.Emitting()["a"]; ok {
+		t.Error("expected the failed adapter not reported")
+	}
+	select {
+	case name := <-failed:
+		if name != "a" {
+			t.Errorf("got %v reported failed; expected a", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the failed adapter reported")
+	}
+
+	// an adapter added while running starts at once
+	c := &recordingAdvertiser{}
+	p.AddAdapter("c", c)
+	deadline = time.Now().Add(5 * time.Second)
+	for !c.IsAdvertising() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new adapter to advertise")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,57 @@
+package advertiser
+
+import (
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// A rotatingSource is a FrameSource whose payload changes at known times.
+type rotatingSource interface {
+	payloadAt(t time.Time) (Payload, error)
+	NextRotation(t time.Time) time.Time
+}
+
+// rotate advertises s with a until ctx is done, replacing the payload as
+// soon as it changes. If rotateAddress is set and a is a
+// RandomAddressSetter, a new non-resolvable private address is set with
+// each payload.
+func rotate(ctx context.Context, a Advertiser, clock Clock, s rotatingSource, rotateAddress bool) error {
+	setter, _ := a.(RandomAddressSetter)
+	if !rotateAddress {
+		setter = nil
+	}
+	defer a.StopAdvertising()
+	for {
+		now := clock.Now()
+		p, err := s.payloadAt(now)
+		if err != nil {
+			return err
+		}
+		if setter != nil {
+			addr, err := beacon.NewNonResolvableAddress()
+			if err != nil {
+				return err
+			}
+			// controllers refuse a new address while advertising
+			if err := a.StopAdvertising(); err != nil {
+				return err
+			}
+			if err := setter.SetRandomAddress(addr); err != nil {
+				return err
+			}
+		}
+		if err := a.Advertise(ctx, p); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-clock.After(s.NextRotation(now).Sub(now)):
+		}
+	}
+}
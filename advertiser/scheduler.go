@@ -0,0 +1,331 @@
+package advertiser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Dwell limits for scheduled frames. A frame must be advertised for at
+// least one advertising interval to be seen at all.
+const (
+	MinDwell     = MinNonConnectableInterval
+	DefaultDwell = time.Second
+)
+
+// A FrameSource supplies a frame's payload each time it is advertised, for
+// frames whose content changes, such as telemetry.
+type FrameSource interface {
+	Payload() (Payload, error)
+}
+
+// Static returns a FrameSource which always supplies p.
+func Static(p Payload) FrameSource {
+	return staticSource(p)
+}
+
+type staticSource Payload
+
+func (s staticSource) Payload() (Payload, error) {
+	return Payload(s), nil
+}
+
+// A Frame is one of the payloads which a Scheduler rotates through.
+type Frame struct {
+	Source FrameSource
+	// Weight is how many times the frame is advertised in each rotation,
+	// relative to the other frames. Zero means 1.
+	Weight int
+	// Dwell is how long each advertisement of the frame lasts. Zero
+	// means DefaultDwell.
+	Dwell time.Duration
+}
+
+// Validate returns an error if f cannot be scheduled.
+func (f Frame) Validate() error {
+	if f.Source == nil {
+		return errors.New("advertiser: frame has no source")
+	}
+	if f.Weight < 0 {
+		return fmt.Errorf("advertiser: negative frame weight %d", f.Weight)
+	}
+	if f.Dwell != 0 && f.Dwell < MinDwell {
+		return fmt.Errorf("advertiser: dwell %v is shorter than %v", f.Dwell, MinDwell)
+	}
+	return nil
+}
+
+func (f Frame) weight() int {
+	if f.Weight == 0 {
+		return 1
+	}
+	return f.Weight
+}
+
+func (f Frame) dwell() time.Duration {
+	if f.Dwell == 0 {
+		return DefaultDwell
+	}
+	return f.Dwell
+}
+
+// ErrFrameExists is returned by Scheduler.Add when a frame of the same
+// name is already scheduled.
+var ErrFrameExists = errors.New("advertiser: frame already scheduled")
+
+// A Scheduler time-multiplexes several frames on one Advertiser, such as
+// the UID, URL and TLM frames of an Eddystone beacon. Each rotation
+// advertises every frame as many times as its weight, interleaved with
+// the others rather than back to back where the weights allow. Its
+// methods may be called from several goroutines, including while Run is
+// running.
+type Scheduler struct {
+	// OnSkip, if set, is called by Run with the name of a frame whose
+	// source failed, and the error, when it is skipped. A frame which
+	// keeps failing is reported again only once its source has
+	// recovered. It must be set before Run is called.
+	OnSkip func(name string, err error)
+
+	advertiser Advertiser
+	clock      Clock
+
+	mu      sync.Mutex // guards the rest
+	frames  []*scheduled
+	current *scheduled    // the frame being advertised, or nil
+	changed chan struct{} // signalled when Run should pick again at once
+}
+
+type scheduled struct {
+	name    string
+	frame   Frame
+	credit  int   // smooth weighted round robin credit
+	skipped error // the source's last failure, so it is reported once
+}
+
+// NewScheduler returns a Scheduler advertising with a, which times
+// frames with clock, or SystemClock if clock is nil.
+func NewScheduler(a Advertiser, clock Clock) *Scheduler {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Scheduler{
+		advertiser: a,
+		clock:      clock,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// Add schedules f under name. It joins the rotation with no credit, so
+// it takes its first turn once its weight has earned one, and the other
+// frames keep their places.
+func (s *Scheduler) Add(name string, f Frame) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(name) >= 0 {
+		return fmt.Errorf("%w: %q", ErrFrameExists, name)
+	}
+	s.frames = append(s.frames, &scheduled{name: name, frame: f})
+	if s.current == nil {
+		s.signal()
+	}
+	return nil
+}
+
+// Remove unschedules the frame of the given name, and returns false if
+// there is none. If it is being advertised, the next frame replaces it.
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(name)
+	if i < 0 {
+		return false
+	}
+	if s.current == s.frames[i] {
+		s.current = nil
+		s.signal()
+	}
+	removed := s.frames[i]
+	s.frames = append(s.frames[:i], s.frames[i+1:]...)
+	s.spread(removed.credit)
+	return true
+}
+
+// Names returns the names of the scheduled frames, in the order they
+// were added.
+func (s *Scheduler) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(s.frames))
+	for i, f := range s.frames {
+		names[i] = f.name
+	}
+	return names
+}
+
+// Current returns the name of the frame being advertised, and false if
+// there is none.
+func (s *Scheduler) Current() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return "", false
+	}
+	return s.current.name, true
+}
+
+func (s *Scheduler) find(name string) int {
+	for i, f := range s.frames {
+		if f.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// spread shares out the credit of a removed frame among the rest, so
+// that their credits still sum to zero and none gains or loses more than
+// one turn's worth.
+func (s *Scheduler) spread(credit int) {
+	n := len(s.frames)
+	if n == 0 {
+		return
+	}
+	share, rest := credit/n, credit%n
+	for i, f := range s.frames {
+		f.credit += share
+		if i < rest {
+			f.credit++
+		} else if i < -rest {
+			f.credit--
+		}
+	}
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// next picks the frame to advertise next by smooth weighted round robin,
+// and returns nil if there are no frames.
+func (s *Scheduler) next() *scheduled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *scheduled
+	total := 0
+	for _, f := range s.frames {
+		f.credit += f.frame.weight()
+		total += f.frame.weight()
+		if best == nil || f.credit > best.credit {
+			best = f
+		}
+	}
+	if best != nil {
+		best.credit -= total
+	}
+	s.current = best
+	return best
+}
+
+// Run advertises the scheduled frames in turn until ctx is done, then
+// stops advertising. With no frames scheduled it waits for one to be
+// added. A frame whose source fails is skipped for that turn, and
+// reported to OnSkip. A frame picked again, with its payload unchanged,
+// is left advertising rather than restarted.
+// Run returns an error if the Advertiser fails, including, for an
+// ErrorReporter, once an advertisement has started. Only one Run may run
+// at a time.
+func (s *Scheduler) Run(ctx context.Context) error {
+	defer s.advertiser.StopAdvertising()
+	defer s.setCurrent(nil)
+	var errs <-chan error
+	if r, ok := s.advertiser.(ErrorReporter); ok {
+		errs = r.Errors()
+	}
+	// frames added before Run need no signal
+	select {
+	case <-s.changed:
+	default:
+	}
+	var last *scheduled // the frame advertised, if still advertising
+	for {
+		f, p := s.pick()
+		var wait <-chan time.Time
+		if f == nil {
+			// nothing to advertise; check again after a while in case
+			// a source recovers
+			last = nil
+			if err := s.advertiser.StopAdvertising(); err != nil {
+				return err
+			}
+			wait = s.clock.After(DefaultDwell)
+		} else if f == last && s.advertising(p) {
+			// the same frame again, unchanged, such as when it is the
+			// only one; restarting would leave a gap
+			wait = s.clock.After(f.frame.dwell())
+		} else {
+			last = f
+			if err := s.advertiser.Advertise(ctx, p); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			wait = s.clock.After(f.frame.dwell())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case <-s.changed:
+		case <-wait:
+		}
+	}
+}
+
+// advertising returns true if the Advertiser is advertising p.
+func (s *Scheduler) advertising(p Payload) bool {
+	current, ok := s.advertiser.Current()
+	return ok && current.Kind == p.Kind && current.ID == p.ID && bytes.Equal(current.Data, p.Data)
+}
+
+// pick returns the next frame whose source supplies a payload, and nil if
+// there is none.
+func (s *Scheduler) pick() (*scheduled, Payload) {
+	s.mu.Lock()
+	n := len(s.frames)
+	s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f := s.next()
+		if f == nil {
+			break
+		}
+		p, err := f.frame.Source.Payload()
+		if err == nil {
+			f.skipped = nil
+			return f, p
+		}
+		if f.skipped == nil && s.OnSkip != nil {
+			s.OnSkip(f.name, err)
+		}
+		f.skipped = err
+	}
+	s.setCurrent(nil)
+	return nil, Payload{}
+}
+
+func (s *Scheduler) setCurrent(f *scheduled) {
+	s.mu.Lock()
+	s.current = f
+	s.mu.Unlock()
+}
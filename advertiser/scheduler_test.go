@@ -0,0 +1,278 @@
+package advertiser
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// recordingAdvertiser is an Advertiser which records the ids of the
+// payloads it advertises.
+type recordingAdvertiser struct {
+	mu      sync.Mutex
+	ids     []uint16
+	current *Payload
+	err     error
+}
+
+func (a *recordingAdvertiser) Advertise(ctx context.Context, p Payload) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	a.ids = append(a.ids, p.ID)
+	a.current = &p
+	return nil
+}
+
+func (a *recordingAdvertiser) StopAdvertising() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.current = nil
+	return nil
+}
+
+func (a *recordingAdvertiser) IsAdvertising() bool {
+	_, ok := a.Current()
+	return ok
+}
+
+func (a *recordingAdvertiser) Current() (Payload, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.current == nil {
+		return Payload{}, false
+	}
+	return *a.current, true
+}
+
+func (a *recordingAdvertiser) advertised() []uint16 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]uint16(nil), a.ids...)
+}
+
+// runScheduler runs s until the test ends, returning Run's result.
+func runScheduler(t *testing.T, s *Scheduler) <-chan error {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		result <- s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return result
+}
+
+func staticFrame(id uint16, weight int) Frame {
+	return Frame{Source: Static(MfgDataPayload(id, ad)), Weight: weight, Dwell: 200 * time.Millisecond}
+}
+
+func TestScheduler(t *testing.T) {
+	a := &recordingAdvertiser{}
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduler(a, clock)
+	for _, name := range []string{"a", "b", "c"} {
+		weight := 1
+		if name == "a" {
+			weight = 2
+		}
+		if err := s.Add(name, staticFrame(uint16(name[0]), weight)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Add("a", staticFrame(1, 1)); !errors.Is(err, ErrFrameExists) {
+		t.Errorf("got %v; expected ErrFrameExists", err)
+	}
+	runScheduler(t, s)
+
+	for i := 0; i < 8; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(200 * time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	// a picked twice running is left advertising, not restarted
+	expected := "abcabca"
+	if got := string(toBytes(a.advertised())); got != expected {
+		t.Errorf("got rotation %q; expected %q", got, expected)
+	}
+	if name, ok := s.Current(); !ok || name != "a" {
+		t.Errorf("got current %q, %v; expected a", name, ok)
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	a := &recordingAdvertiser{}
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduler(a, clock)
+	runScheduler(t, s)
+
+	// with nothing scheduled it waits
+	clock.BlockUntil(1)
+	if a.IsAdvertising() {
+		t.Error("advertising with no frames")
+	}
+	s.Add("a", staticFrame('a', 1))
+	s.Add("b", staticFrame('b', 1))
+	clock.BlockUntil(2)
+
+	// removing the current frame moves straight on to the next
+	if !s.Remove("a") || s.Remove("a") {
+		t.Error("expected a to be removed once")
+	}
+	for len(a.advertised()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if current, _ := a.Current(); current.ID != 'b' {
+		t.Errorf("got %c; expected b after removing a", rune(current.ID))
+	}
+	if names := s.Names(); len(names) != 1 || names[0] != "b" {
+		t.Errorf("got %v; expected only b", names)
+	}
+}
+
+func TestSchedulerChurn(t *testing.T) {
+	s := NewScheduler(&recordingAdvertiser{}, nil)
+	s.Add("a", staticFrame('a', 1))
+	s.Add("b", staticFrame('b', 1))
+
+	// frames coming and going, as in a busy Pool, leave the others'
+	// turns alone
+	picks := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		picks[s.next().name]++
+		s.Add("c", staticFrame('c', 3))
+		s.Remove("c")
+	}
+	if picks["a"] != 5 || picks["b"] != 5 {
+		t.Errorf("got picks %v; expected a and b to alternate", picks)
+	}
+
+	// a frame removed with credit owing hands it on without skewing the
+	// rotation
+	s.Add("c", staticFrame('c', 1))
+	s.next()
+	s.Remove("c")
+	total := 0
+	for _, f := range s.frames {
+		total += f.credit
+	}
+	if total != 0 {
+		t.Errorf("got credits summing to %d; expected 0", total)
+	}
+}
+
+// countingSource supplies payloads whose id is its count, which changes
+// only when bumped.
+type countingSource struct {
+	mu    sync.Mutex
+	count uint16
+}
+
+func (c *countingSource) Payload() (Payload, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return MfgDataPayload(c.count, ad), nil
+}
+
+func (c *countingSource) bump() {
+	c.mu.Lock()
+	c.count++
+	c.mu.Unlock()
+}
+
+func TestSchedulerUnchanged(t *testing.T) {
+	a := &recordingAdvertiser{}
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduler(a, clock)
+	source := &countingSource{}
+	s.Add("only", Frame{Source: source, Dwell: 200 * time.Millisecond})
+	runScheduler(t, s)
+
+	// a lone frame which does not change is advertised once
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(200 * time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	if got := a.advertised(); len(got) != 1 {
+		t.Errorf("got advertisements %v; expected one", got)
+	}
+
+	// but is advertised again once its payload changes
+	source.bump()
+	clock.Advance(200 * time.Millisecond)
+	clock.BlockUntil(1)
+	if got := a.advertised(); len(got) != 2 || got[1] != 1 {
+		t.Errorf("got advertisements %v; expected the new payload", got)
+	}
+}
+
+type failingSource struct{}
+
+func (failingSource) Payload() (Payload, error) {
+	return Payload{}, errors.New("sensor unavailable")
+}
+
+func TestSchedulerErrors(t *testing.T) {
+	a := &recordingAdvertiser{}
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduler(a, clock)
+	var skipped []string
+	s.OnSkip = func(name string, err error) { skipped = append(skipped, name) }
+	s.Add("broken", Frame{Source: failingSource{}})
+	s.Add("a", staticFrame('a', 1))
+	runScheduler(t, s)
+
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(200 * time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	if got := string(toBytes(a.advertised())); got != "a" {
+		t.Errorf("got %q; expected the broken frame to be skipped, and a advertised once", got)
+	}
+	if len(skipped) != 1 || skipped[0] != "broken" {
+		t.Errorf("got %v skipped; expected the broken frame reported once", skipped)
+	}
+
+	if err := s.Add("fast", Frame{Source: failingSource{}, Dwell: time.Millisecond}); err == nil {
+		t.Error("expected a dwell under MinDwell to be refused")
+	}
+	if err := s.Add("none", Frame{}); err == nil {
+		t.Error("expected a frame without a source to be refused")
+	}
+
+	// a failure after advertising started ends Run too
+	d := &blockingDevice{fail: make(chan error)}
+	s = NewScheduler(newAdvertiser(d, AdvertiseOptions{}), clock)
+	s.Add("a", staticFrame('a', 1))
+	result := runScheduler(t, s)
+	d.fail <- errors.New("controller reset")
+	if err := <-result; err == nil {
+		t.Error("expected the later failure")
+	}
+
+	a = &recordingAdvertiser{err: errors.New("hci: command disallowed")}
+	s = NewScheduler(a, clock)
+	s.Add("a", staticFrame('a', 1))
+	if err := <-runScheduler(t, s); err == nil {
+		t.Error("expected the advertiser's error")
+	}
+}
+
+func toBytes(ids []uint16) []byte {
+	b := make([]byte, len(ids))
+	for i, id := range ids {
+		b[i] = byte(id)
+	}
+	return b
+}
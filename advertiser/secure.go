@@ -0,0 +1,73 @@
+package advertiser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+// A SecureIDSource is a FrameSource of a beacon whose rotated field, as
+// its beacon.SecureID defines, is that of the moment each frame is
+// generated. Its methods may be called from several goroutines.
+type SecureIDSource struct {
+	id        *beacon.SecureID
+	template  *beacon.Beacon
+	layout    string
+	companyID uint16
+	clock     Clock
+}
+
+// NewSecureIDSource returns a SecureIDSource advertising template with
+// the field id rotates, which template must have, framed as BeaconPayload
+// frames it. It tells the time with clock, or SystemClock if clock is nil.
+func NewSecureIDSource(id *beacon.SecureID, template *beacon.Beacon, clock Clock) (*SecureIDSource, error) {
+	layout, ok := beacon.DefaultLayouts[template.Type]
+	if !ok {
+		return nil, fmt.Errorf("advertiser: no layout for beacon type %q", template.Type)
+	}
+	return NewSecureIDSourceWithLayout(id, template, layout, DefaultCompanyID, clock)
+}
+
+// NewSecureIDSourceWithLayout returns a SecureIDSource as
+// NewSecureIDSource does, but whose frames are framed with the given
+// layout and company id, as BeaconPayloadWithLayout frames them.
+func NewSecureIDSourceWithLayout(id *beacon.SecureID, template *beacon.Beacon, layout string, companyID uint16, clock Clock) (*SecureIDSource, error) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	s := &SecureIDSource{id: id, template: template, layout: layout, companyID: companyID, clock: clock}
+	if _, err := s.payloadAt(clock.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Payload returns a frame of the beacon as it is now.
+func (s *SecureIDSource) Payload() (Payload, error) {
+	return s.payloadAt(s.clock.Now())
+}
+
+func (s *SecureIDSource) payloadAt(t time.Time) (Payload, error) {
+	b, err := s.id.Beacon(s.template, t)
+	if err != nil {
+		return Payload{}, err
+	}
+	return BeaconPayloadWithLayout(b, s.layout, s.companyID)
+}
+
+// NextRotation returns when the rotated field after the one at t takes
+// effect.
+func (s *SecureIDSource) NextRotation(t time.Time) time.Time {
+	return s.id.NextRotation(t)
+}
+
+// Rotate advertises the beacon with a until ctx is done, replacing its
+// rotated field at each window boundary. If rotateAddress is set and a is
+// a RandomAddressSetter, a new non-resolvable private address is set with
+// each window, which on some hardware makes the advertising
+// non-connectable, as RandomAddressSetter describes.
+func (s *SecureIDSource) Rotate(ctx context.Context, a Advertiser, rotateAddress bool) error {
+	return rotate(ctx, a, s.clock, s, rotateAddress)
+}
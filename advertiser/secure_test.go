@@ -0,0 +1,94 @@
+package advertiser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"golang.org/x/net/context"
+)
+
+func TestSecureIDSourceRotate(t *testing.T) {
+	template := beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 7, 0, -59)
+	id := &beacon.SecureID{
+		Type:    "altbeacon",
+		Ids:     template.Ids,
+		Rotated: beacon.FieldRef{Index: 2},
+		Secret:  []byte("0123456789abcdef"),
+		Period:  time.Minute,
+	}
+	clock := NewManualClock(time.Unix(90, 0))
+	s, err := NewSecureIDSource(id, template, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := &recordingAdvertiser{}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Rotate(ctx, a, false) }()
+
+	parser := beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"])
+	var minors []uint16
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		current, _ := a.Current()
+		b := parser.Parse(current.Data)
+		if v := id.Verify(b, clock.Now()); v != beacon.Verified {
+			t.Errorf("rotation %d: got %v; expected the advertised beacon verified", i, v)
+		}
+		minors = append(minors, b.Ids[2].Uint16())
+		// the first wait is to the window boundary
+		if i == 0 {
+			clock.Advance(30 * time.Second)
+		} else {
+			clock.Advance(time.Minute)
+		}
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if minors[0] == minors[1] || minors[1] == minors[2] {
+		t.Errorf("got minors %v; expected a new one each window", minors)
+	}
+
+	id.Rotated = beacon.FieldRef{Data: true, Index: 3}
+	if _, err := NewSecureIDSource(id, template, clock); err == nil {
+		t.Error("expected a template without the rotated field to be refused")
+	}
+}
+
+func TestSecureIDSourceLayout(t *testing.T) {
+	const layout = "m:2-3=0215,i:4-19,i:20-21,i:22-23,p:24-24"
+	ids := beacon.UUIDMajorMinorFields("e858fc8a-372b-4bef-a053-93f98cd4e177", 7, 0)
+	template := beacon.NewBeacon("ibeacon", ids, nil, beacon.FieldFromInt8(-59))
+	id := &beacon.SecureID{
+		Type:    "ibeacon",
+		Ids:     ids,
+		Rotated: beacon.FieldRef{Index: 2},
+		Secret:  []byte("0123456789abcdef"),
+		Period:  time.Minute,
+	}
+	clock := NewManualClock(time.Unix(90, 0))
+	if _, err := NewSecureIDSource(id, &template, clock); err == nil {
+		t.Error("expected a type without a default layout to be refused")
+	}
+	s, err := NewSecureIDSourceWithLayout(id, &template, layout, 0x004c, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := s.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Kind != MfgData || p.ID != 0x004c {
+		t.Errorf("got %v %#04x; expected manufacturer data under 0x004c", p.Kind, p.ID)
+	}
+	b := beacon.NewParser("ibeacon", layout).Parse(p.Data)
+	if b == nil {
+		t.Fatalf("expected % x to parse as an ibeacon", p.Data)
+	}
+	if v := id.Verify(b, clock.Now()); v != beacon.Verified {
+		t.Errorf("got %v; expected the advertised beacon verified", v)
+	}
+}
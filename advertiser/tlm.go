@@ -0,0 +1,108 @@
+package advertiser
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+// advDelay is the mean of the random 0-10ms delay the Bluetooth Core
+// specification adds to each advertising interval.
+const advDelay = 5 * time.Millisecond
+
+// A TLMSource is a FrameSource of Eddystone-TLM frames describing the
+// advertising since it was created or restarted, for a Scheduler to
+// rotate with the frames it accompanies. Each frame is generated afresh.
+// Its methods may be called from several goroutines.
+type TLMSource struct {
+	// AdvCount, if set, reads the number of advertising PDUs sent since
+	// advertising started, from hardware which counts them. Frames report
+	// EstimatedAdvCount instead if it is not set or fails.
+	AdvCount func() (uint32, error)
+	// Battery, if set, reads the battery voltage in mV. Frames report no
+	// battery if it is not set or fails.
+	Battery func() (uint16, error)
+	// Temperature, if set, reads the temperature in degrees Celsius.
+	// Frames report no sensor if it is not set or fails.
+	Temperature func() (float64, error)
+
+	clock    Clock
+	period   time.Duration // the mean time between advertising events
+	channels uint32
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// NewTLMSource returns a TLMSource for hardware advertising as opts
+// configure, to be created when advertising starts. It times the uptime
+// with clock, or SystemClock if clock is nil, and unless AdvCount is set
+// reports the advertising PDU count estimated from the interval and
+// channels in opts.
+func NewTLMSource(opts AdvertiseOptions, clock Clock) *TLMSource {
+	if clock == nil {
+		clock = SystemClock
+	}
+	min, max := opts.Intervals()
+	s := &TLMSource{
+		clock:  clock,
+		period: (min+max)/2 + advDelay,
+	}
+	channels := opts.Channels
+	if channels == 0 {
+		channels = AllChannels
+	}
+	for ; channels != 0; channels >>= 1 {
+		s.channels += uint32(channels & 1)
+	}
+	s.Restart()
+	return s
+}
+
+// Restart resets the uptime and count, for when advertising restarts.
+func (s *TLMSource) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = s.clock.Now()
+}
+
+func (s *TLMSource) uptime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now().Sub(s.start)
+}
+
+// EstimatedAdvCount returns the number of advertising PDUs which hardware
+// advertising as configured would have sent since advertising started.
+// It is not a count of PDUs actually sent.
+func (s *TLMSource) EstimatedAdvCount() uint32 {
+	return uint32(s.uptime()/s.period) * s.channels
+}
+
+// TLM returns the telemetry as it stands.
+func (s *TLMSource) TLM() beacon.TLM {
+	tlm := beacon.TLM{Uptime: s.uptime()}
+	tlm.AdvCount = s.EstimatedAdvCount()
+	if s.AdvCount != nil {
+		if n, err := s.AdvCount(); err == nil {
+			tlm.AdvCount = n
+		}
+	}
+	if s.Battery != nil {
+		if mV, err := s.Battery(); err == nil {
+			tlm.BatteryVoltage = mV
+		}
+	}
+	if s.Temperature != nil {
+		if celsius, err := s.Temperature(); err == nil {
+			tlm.Temperature = &celsius
+		}
+	}
+	return tlm
+}
+
+// Payload returns an Eddystone-TLM frame of the current telemetry.
+func (s *TLMSource) Payload() (Payload, error) {
+	return BeaconPayload(beacon.NewEddystoneTLMBeacon(s.TLM()))
+}
@@ -0,0 +1,86 @@
+package advertiser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+func TestTLMSource(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewTLMSource(AdvertiseOptions{}, clock)
+	s.Battery = func() (uint16, error) { return 3000, nil }
+	clock.Advance(21 * time.Second)
+
+	p, err := s.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Kind != ServiceData || p.ID != 0xfeaa {
+		t.Errorf("got %v %#04x; expected eddystone service data", p.Kind, p.ID)
+	}
+	parser := beacon.NewParser(beacon.BeaconTypeEddystoneTLM, beacon.DefaultLayouts[beacon.BeaconTypeEddystoneTLM])
+	tlm, err := beacon.ParseEddystoneTLM(parser.Parse(p.Data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// every 100ms plus the mean delay, on three channels
+	if tlm.AdvCount != 600 || tlm.Uptime != 21*time.Second || tlm.BatteryVoltage != 3000 {
+		t.Errorf("got %+v; expected 600 PDUs in 21s on 3000mV", tlm)
+	}
+	if tlm.Temperature != nil {
+		t.Errorf("got temperature %v; expected none", *tlm.Temperature)
+	}
+
+	s.Temperature = func() (float64, error) { return 0, errors.New("sensor unavailable") }
+	s.Restart()
+	clock.Advance(time.Second)
+	tlm = s.TLM()
+	if tlm.Uptime != time.Second || tlm.AdvCount != 27 || tlm.Temperature != nil {
+		t.Errorf("got %+v; expected the counts restarted without a temperature", tlm)
+	}
+
+	s = NewTLMSource(AdvertiseOptions{MinInterval: time.Second, Channels: Channel37}, clock)
+	clock.Advance(10 * time.Second)
+	if tlm := s.TLM(); tlm.AdvCount != 9 || s.EstimatedAdvCount() != 9 {
+		t.Errorf("got %v PDUs; expected 9 on one channel every second", tlm.AdvCount)
+	}
+
+	// hardware which counts its PDUs is believed over the estimate
+	s.AdvCount = func() (uint32, error) { return 12, nil }
+	if tlm := s.TLM(); tlm.AdvCount != 12 {
+		t.Errorf("got %v PDUs; expected the hardware's count", tlm.AdvCount)
+	}
+	s.AdvCount = func() (uint32, error) { return 0, errors.New("no counter") }
+	if tlm := s.TLM(); tlm.AdvCount != 9 {
+		t.Errorf("got %v PDUs; expected the estimate when the count fails", tlm.AdvCount)
+	}
+}
+
+func TestSchedulerTLM(t *testing.T) {
+	a := &recordingAdvertiser{}
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewScheduler(a, clock)
+	s.Add("url", staticFrame('u', 1))
+	s.Add("tlm", Frame{Source: NewTLMSource(AdvertiseOptions{}, clock), Dwell: time.Second})
+	runScheduler(t, s)
+
+	var uptimes []time.Duration
+	for len(uptimes) < 2 {
+		clock.BlockUntil(1)
+		if current, _ := a.Current(); current.Kind == ServiceData {
+			b := beacon.NewParser(beacon.BeaconTypeEddystoneTLM, beacon.DefaultLayouts[beacon.BeaconTypeEddystoneTLM]).Parse(current.Data)
+			tlm, err := beacon.ParseEddystoneTLM(b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			uptimes = append(uptimes, tlm.Uptime)
+		}
+		clock.Advance(time.Second)
+	}
+	if uptimes[1]-uptimes[0] != 2*time.Second {
+		t.Errorf("got uptimes %v; expected each frame freshly generated", uptimes)
+	}
+}
@@ -5,6 +5,7 @@ package beacon
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -16,6 +17,15 @@ type Beacon struct {
 	Power  Field
 	rssis  []int8
 	Device string
+	// Verification says whether the beacon's secure id checked out, if
+	// the Scanner which found it has SecureIDs.
+	Verification Verification
+	// adapterRSSIs holds the rssi measurements made by each adapter
+	adapterRSSIs map[string][]int8
+	// ad and parser are the advertisement data the beacon was parsed
+	// from and the parser which parsed it, if it was
+	ad     []byte
+	parser *Parser
 }
 
 // A Slice is a list of Beacons
@@ -31,6 +41,13 @@ func NewBeacon(t string, ids []Field, data []Field, power Field) Beacon {
 	return beacon
 }
 
+// Ad returns the advertisement data the beacon was parsed from, as
+// ScanData.Bytes carries it, and the Parser which parsed it. It returns
+// nil if the beacon was not parsed.
+func (b *Beacon) Ad() ([]byte, *Parser) {
+	return b.ad, b.parser
+}
+
 // Generates a description of the beacon
 func (b *Beacon) String() string {
 	idStrings := make([]string, len(b.Ids))
@@ -57,6 +74,40 @@ func (b *Beacon) AddRSSI(rssi int8) {
 	b.rssis = append(b.rssis, rssi)
 }
 
+// AddAdapterRSSI adds an rssi measurement made by the given adapter to the
+// beacon.
+func (b *Beacon) AddAdapterRSSI(adapter string, rssi int8) {
+	b.AddRSSI(rssi)
+	if b.adapterRSSIs == nil {
+		b.adapterRSSIs = make(map[string][]int8)
+	}
+	b.adapterRSSIs[adapter] = append(b.adapterRSSIs[adapter], rssi)
+}
+
+// AdapterRSSI calculates the average rssi measured by the given adapter,
+// or returns false if it has not seen the beacon.
+func (b *Beacon) AdapterRSSI(adapter string) (float64, bool) {
+	rssis := b.adapterRSSIs[adapter]
+	if len(rssis) == 0 {
+		return 0, false
+	}
+	total := 0.0
+	for _, rssi := range rssis {
+		total += float64(rssi)
+	}
+	return total / float64(len(rssis)), true
+}
+
+// Adapters returns the adapters which have seen the beacon, in order.
+func (b *Beacon) Adapters() []string {
+	adapters := make([]string, 0, len(b.adapterRSSIs))
+	for adapter := range b.adapterRSSIs {
+		adapters = append(adapters, adapter)
+	}
+	sort.Strings(adapters)
+	return adapters
+}
+
 // Equal tests whether two Beacons have the same identifiers and same mac adddress.
 func (a *Beacon) Equal(b *Beacon) bool {
 	return strings.Compare(a.Device, b.Device) == 0 && reflect.DeepEqual(a.Ids, b.Ids)
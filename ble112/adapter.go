@@ -0,0 +1,54 @@
+package ble112
+
+import (
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+func init() {
+	beacon.RegisterAdapters("ble112", func() ([]beacon.Adapter, error) {
+		devices, err := probe(ProbeTimeout)
+		adapters := make([]beacon.Adapter, len(devices))
+		for i, device := range devices {
+			adapters[i] = device
+		}
+		return adapters, err
+	})
+}
+
+var _ beacon.Adapter = (*Device)(nil)
+
+// ProbeTimeout bounds how long enumerating adapters waits for each port
+// to answer as a BLE112.
+var ProbeTimeout = 500 * time.Millisecond
+
+// DeviceType returns "ble112".
+func (d *Device) DeviceType() string {
+	return "ble112"
+}
+
+// Capabilities returns the capabilities of the BLE112.
+func (d *Device) Capabilities() beacon.Capability {
+	return beacon.CapScan | beacon.CapAdvertise | beacon.CapConnect
+}
+
+// Health returns an error if the BLE112 does not answer system_hello.
+func (d *Device) Health() error {
+	_, err := d.SendCommand(BG_MSG_CLASS_SYSTEM, BG_HELLO, NULL_DATA)
+	return err
+}
+
+// probe identifies the BLE112s on the serial ports given by DevicePaths.
+// The devices returned are closed; Open reconnects to them.
+func probe(timeout time.Duration) ([]*Device, error) {
+	paths, err := DevicePaths()
+	if err != nil {
+		return nil, err
+	}
+	devices := probePorts(paths, OpenSerialPort, timeout)
+	for _, device := range devices {
+		device.Close()
+	}
+	return devices, nil
+}
@@ -0,0 +1,34 @@
+package ble112
+
+import (
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/advertiser"
+)
+
+var _ advertiser.RandomAddressSetter = (*Device)(nil)
+
+// SetRandomAddress advertises from addr, a random address, instead of the
+// BLE112's public address, with gap_set_nonresolvable_address. The BLE112
+// only uses it for non-connectable advertising, so from then on it
+// advertises non-connectably, whatever the Device's AdvertiseOptions say.
+// The address is set again after the BLE112 resets.
+func (device *Device) SetRandomAddress(addr beacon.MacAddress) error {
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_NONRESOLVABLE, addr[:]); err != nil {
+		return err
+	}
+	device.mu.Lock()
+	device.randomAddress = &addr
+	device.mu.Unlock()
+	return nil
+}
+
+// RandomAddress returns the address set with SetRandomAddress, and false
+// if there is none.
+func (device *Device) RandomAddress() (beacon.MacAddress, bool) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.randomAddress == nil {
+		return beacon.MacAddress{}, false
+	}
+	return *device.randomAddress, true
+}
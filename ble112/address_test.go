@@ -0,0 +1,74 @@
+package ble112_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/advertiser"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func TestDeviceSetRandomAddress(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	// the default options are connectable, which the random address
+	// overrides
+	addr, _ := beacon.NewNonResolvableAddress()
+	if err := device.SetRandomAddress(addr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, ok := e.RandomAddress(); !ok || got != addr {
+		t.Errorf("got %v, %v; expected %v", got, ok, addr)
+	}
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the address survives a reset
+	if err := device.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, ok := e.RandomAddress(); !ok || got != addr {
+		t.Errorf("got %v, %v after reset; expected %v", got, ok, addr)
+	}
+
+	if _, connectable := e.Mode(); connectable != ble112.BG_GAP_NON_CONNECTABLE {
+		t.Errorf("got connectable mode %v; expected advertising from the random address to be non-connectable", connectable)
+	}
+}
+
+func TestDeviceRotateAddress(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+	s, err := advertiser.NewEIDSource(make([]byte, 16), 10, time.Now(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// rotating the address works under the default, connectable, options
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- s.Rotate(ctx, device, true) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for !device.IsAdvertising() {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("not advertising: %v", <-result)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := e.RandomAddress(); !ok {
+		t.Error("expected a random address set")
+	}
+	if _, connectable := e.Mode(); connectable != ble112.BG_GAP_NON_CONNECTABLE {
+		t.Errorf("got connectable mode %v; expected non-connectable", connectable)
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
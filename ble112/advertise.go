@@ -0,0 +1,29 @@
+package ble112
+
+import "github.com/RadiusNetworks/go-beacon/advertiser"
+
+// advParameters encodes the options for gap_set_adv_parameters.
+func advParameters(opts advertiser.AdvertiseOptions) []byte {
+	min, max := opts.Intervals()
+	lo, hi := advertiser.IntervalUnits(min), advertiser.IntervalUnits(max)
+	return []byte{byte(lo), byte(lo >> 8), byte(hi), byte(hi >> 8), byte(opts.ChannelMap())}
+}
+
+// Transmit power range of the BLE112. hardware_set_txpower takes a level
+// from 0 to 15, spread roughly evenly over this range.
+const (
+	MinTxPower = -23
+	MaxTxPower = 3
+)
+
+// txPowerLevel converts a transmit power in dBm into the highest
+// hardware_set_txpower level which does not exceed it.
+func txPowerLevel(dBm int8) byte {
+	switch {
+	case dBm <= MinTxPower:
+		return 0
+	case dBm >= MaxTxPower:
+		return 15
+	}
+	return byte((int(dBm) - MinTxPower) * 15 / (MaxTxPower - MinTxPower))
+}
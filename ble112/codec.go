@@ -0,0 +1,204 @@
+package ble112
+
+import "fmt"
+
+// A message describes one BGAPI command response or event: its name as
+// given in the Bluegiga API reference and the length of its payload.
+// Messages whose last parameter is a uint8array have a variable payload
+// of at least length bytes, the final one of which is the array length.
+type message struct {
+	name     string
+	length   int
+	variable bool
+}
+
+type messageKey struct {
+	event bool
+	class byte
+	id    byte
+}
+
+// messages lists the BGAPI messages the BLE112 is known to send. The
+// framed reader uses it to validate headers, so a byte dropped on the
+// serial line is detected instead of misparsing everything after it.
+var messages = map[messageKey]message{
+	// system
+	{false, 0, 1}:  {"system_hello", 0, false},
+	{false, 0, 2}:  {"system_address_get", 6, false},
+	{false, 0, 3}:  {"system_reg_write", 2, false},
+	{false, 0, 4}:  {"system_reg_read", 3, false},
+	{false, 0, 5}:  {"system_get_counters", 5, false},
+	{false, 0, 6}:  {"system_get_connections", 1, false},
+	{false, 0, 7}:  {"system_read_memory", 5, true},
+	{false, 0, 8}:  {"system_get_info", 12, false},
+	{false, 0, 9}:  {"system_endpoint_tx", 2, false},
+	{false, 0, 10}: {"system_whitelist_append", 2, false},
+	{false, 0, 11}: {"system_whitelist_remove", 2, false},
+	{false, 0, 12}: {"system_whitelist_clear", 0, false},
+	{false, 0, 13}: {"system_endpoint_rx", 3, true},
+	{false, 0, 14}: {"system_endpoint_set_watermarks", 2, false},
+	{true, 0, 0}:   {"system_boot", 12, false},
+	{true, 0, 1}:   {"system_debug", 1, true},
+	{true, 0, 2}:   {"system_endpoint_watermark_rx", 2, false},
+	{true, 0, 3}:   {"system_endpoint_watermark_tx", 2, false},
+	{true, 0, 4}:   {"system_script_failure", 4, false},
+	{true, 0, 5}:   {"system_no_license_key", 0, false},
+	{true, 0, 6}:   {"system_protocol_error", 2, false},
+
+	// flash
+	{false, 1, 0}: {"flash_ps_defrag", 0, false},
+	{false, 1, 1}: {"flash_ps_dump", 0, false},
+	{false, 1, 2}: {"flash_ps_erase_all", 0, false},
+	{false, 1, 3}: {"flash_ps_save", 2, false},
+	{false, 1, 4}: {"flash_ps_load", 3, true},
+	{false, 1, 5}: {"flash_ps_erase", 0, false},
+	{false, 1, 6}: {"flash_erase_page", 2, false},
+	{false, 1, 7}: {"flash_write_data", 2, false},
+	{false, 1, 8}: {"flash_read_data", 1, true},
+	{true, 1, 0}:  {"flash_ps_key", 3, true},
+
+	// attributes
+	{false, 2, 0}: {"attributes_write", 2, false},
+	{false, 2, 1}: {"attributes_read", 7, true},
+	{false, 2, 2}: {"attributes_read_type", 5, true},
+	{false, 2, 3}: {"attributes_user_read_response", 0, false},
+	{false, 2, 4}: {"attributes_user_write_response", 0, false},
+	{true, 2, 0}:  {"attributes_value", 7, true},
+	{true, 2, 1}:  {"attributes_user_read_request", 6, false},
+	{true, 2, 2}:  {"attributes_status", 3, false},
+
+	// connection
+	{false, 3, 0}: {"connection_disconnect", 3, false},
+	{false, 3, 1}: {"connection_get_rssi", 2, false},
+	{false, 3, 2}: {"connection_update", 3, false},
+	{false, 3, 3}: {"connection_version_update", 3, false},
+	{false, 3, 7}: {"connection_get_status", 1, false},
+	{true, 3, 0}:  {"connection_status", 16, false},
+	{true, 3, 1}:  {"connection_version_ind", 6, false},
+	{true, 3, 2}:  {"connection_feature_ind", 2, true},
+	{true, 3, 3}:  {"connection_raw_rx", 2, true},
+	{true, 3, 4}:  {"connection_disconnected", 3, false},
+
+	// attclient
+	{false, 4, 0}:  {"attclient_find_by_type_value", 3, false},
+	{false, 4, 1}:  {"attclient_read_by_group_type", 3, false},
+	{false, 4, 2}:  {"attclient_read_by_type", 3, false},
+	{false, 4, 3}:  {"attclient_find_information", 3, false},
+	{false, 4, 4}:  {"attclient_read_by_handle", 3, false},
+	{false, 4, 5}:  {"attclient_attribute_write", 3, false},
+	{false, 4, 6}:  {"attclient_write_command", 3, false},
+	{false, 4, 7}:  {"attclient_indicate_confirm", 2, false},
+	{false, 4, 8}:  {"attclient_read_long", 3, false},
+	{false, 4, 9}:  {"attclient_prepare_write", 3, false},
+	{false, 4, 10}: {"attclient_execute_write", 3, false},
+	{false, 4, 11}: {"attclient_read_multiple", 3, false},
+	{true, 4, 0}:   {"attclient_indicated", 3, false},
+	{true, 4, 1}:   {"attclient_procedure_completed", 5, false},
+	{true, 4, 2}:   {"attclient_group_found", 6, true},
+	{true, 4, 3}:   {"attclient_attribute_found", 7, true},
+	{true, 4, 4}:   {"attclient_find_information_found", 4, true},
+	{true, 4, 5}:   {"attclient_attribute_value", 5, true},
+	{true, 4, 6}:   {"attclient_read_multiple_response", 2, true},
+
+	// sm
+	{false, 5, 0}: {"sm_encrypt_start", 3, false},
+	{false, 5, 1}: {"sm_set_bondable_mode", 0, false},
+	{false, 5, 2}: {"sm_delete_bonding", 2, false},
+	{false, 5, 3}: {"sm_set_parameters", 0, false},
+	{false, 5, 4}: {"sm_passkey_entry", 2, false},
+	{false, 5, 5}: {"sm_get_bonds", 1, false},
+	{false, 5, 6}: {"sm_set_oob_data", 0, false},
+	{true, 5, 0}:  {"sm_smp_data", 3, true},
+	{true, 5, 1}:  {"sm_bonding_fail", 3, false},
+	{true, 5, 2}:  {"sm_passkey_display", 5, false},
+	{true, 5, 3}:  {"sm_passkey_request", 1, false},
+	{true, 5, 4}:  {"sm_bond_status", 4, false},
+
+	// gap
+	{false, 6, 0}:  {"gap_set_privacy_flags", 0, false},
+	{false, 6, 1}:  {"gap_set_mode", 2, false},
+	{false, 6, 2}:  {"gap_discover", 2, false},
+	{false, 6, 3}:  {"gap_connect_direct", 3, false},
+	{false, 6, 4}:  {"gap_end_procedure", 2, false},
+	{false, 6, 5}:  {"gap_connect_selective", 3, false},
+	{false, 6, 6}:  {"gap_set_filtering", 2, false},
+	{false, 6, 7}:  {"gap_set_scan_parameters", 2, false},
+	{false, 6, 8}:  {"gap_set_adv_parameters", 2, false},
+	{false, 6, 9}:  {"gap_set_adv_data", 2, false},
+	{false, 6, 10}: {"gap_set_directed_connectable_mode", 2, false},
+	{false, 6, 12}: {"gap_set_nonresolvable_address", 2, false},
+	{true, 6, 0}:   {"gap_scan_response", 11, true},
+	{true, 6, 1}:   {"gap_mode_changed", 2, false},
+
+	// hardware
+	{false, 7, 12}: {"hardware_set_txpower", 0, false},
+	{true, 7, 0}:   {"hardware_io_port_status", 7, false},
+	{true, 7, 1}:   {"hardware_soft_timer", 1, false},
+	{true, 7, 2}:   {"hardware_adc_result", 3, false},
+
+	// dfu
+	{false, 9, 1}: {"dfu_flash_set_address", 2, false},
+	{false, 9, 2}: {"dfu_flash_upload", 2, false},
+	{false, 9, 3}: {"dfu_flash_upload_finish", 2, false},
+	{true, 9, 0}:  {"dfu_boot", 4, false},
+}
+
+// lookupMessage returns the description of the message identified by a
+// BGAPI header, and whether it is known.
+func lookupMessage(header []byte) (message, bool) {
+	m, ok := messages[messageKey{header[0]&BG_EVENT != 0, header[2], header[3]}]
+	return m, ok
+}
+
+// payloadLength returns the payload length encoded in a BGAPI header,
+// including the three high bits carried in the first byte.
+func payloadLength(header []byte) int {
+	return int(header[0]&0x07)<<8 | int(header[1])
+}
+
+// validHeader reports whether header could start a frame: the technology
+// type must be Bluetooth Smart and the class, ID and length must match a
+// known message.
+func validHeader(header []byte) bool {
+	if header[0]&0x78 != 0 {
+		return false
+	}
+	m, ok := lookupMessage(header)
+	if !ok {
+		return false
+	}
+	n := payloadLength(header)
+	if m.variable {
+		return n >= m.length && n <= m.length+255
+	}
+	return n == m.length
+}
+
+// validPayload reports whether the payload of a frame is consistent with
+// its header, which for variable length messages means the trailing array
+// length must account for exactly the remaining bytes.
+func validPayload(header []byte, payload []byte) bool {
+	m, _ := lookupMessage(header)
+	if !m.variable {
+		return true
+	}
+	return int(payload[m.length-1]) == len(payload)-m.length
+}
+
+// unansweredCommands names the commands which have no response.
+var unansweredCommands = map[messageKey]string{
+	{false, 0, 0}: "system_reset",
+	{false, 9, 0}: "dfu_reset",
+}
+
+// CommandName returns the BGAPI name of a command, such as
+// "gap_set_mode", or a description of its class and ID if it is unknown.
+func CommandName(class byte, id byte) string {
+	if m, ok := messages[messageKey{false, class, id}]; ok {
+		return m.name
+	}
+	if name, ok := unansweredCommands[messageKey{false, class, id}]; ok {
+		return name
+	}
+	return fmt.Sprintf("command %d/%d", class, id)
+}
@@ -0,0 +1,484 @@
+package ble112
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// ErrClosed is returned when a command is sent to, or a scan is started
+// on, a Device whose connection is not open.
+var ErrClosed = errors.New("connection closed")
+
+// A PortOpener opens the connection to a BLE112 attached at the given
+// port.
+type PortOpener func(port string) (io.ReadWriteCloser, error)
+
+// serialReadTimeout bounds each read from the serial port so that Close
+// is not left waiting on a blocked read.
+const serialReadTimeout = 100 * time.Millisecond
+
+// OpenSerialPort opens port as a 115200 baud serial connection. It is the
+// PortOpener used by NewDevice.
+func OpenSerialPort(port string) (io.ReadWriteCloser, error) {
+	c := serial.Config{Name: port, Baud: 115200, ReadTimeout: serialReadTimeout}
+	p, err := serial.OpenPort(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &serialPort{Port: p}, nil
+}
+
+// serialPort retries reads that time out with no data, which the serial
+// package reports as io.EOF, until the port is closed.
+type serialPort struct {
+	*serial.Port
+	closed int32
+}
+
+func (p *serialPort) Read(b []byte) (int, error) {
+	for {
+		start := time.Now()
+		n, err := p.Port.Read(b)
+		// an immediate EOF means the device has gone away rather than
+		// that the read timed out
+		if n == 0 && err == io.EOF && atomic.LoadInt32(&p.closed) == 0 &&
+			time.Since(start) >= serialReadTimeout/2 {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *serialPort) Close() error {
+	atomic.StoreInt32(&p.closed, 1)
+	return p.Port.Close()
+}
+
+// A subscription receives the events read from the device until it is
+// cancelled or the connection closes. A scan subscription takes only as
+// many events as its buffer holds, dropping the rest while its consumer
+// falls behind. Any other subscription waits on a command's events, such
+// as a boot or a procedure completing, which must not be lost: it is not
+// sent gap_scan_response events, and queues the rest without bound.
+type subscription struct {
+	events chan *Response
+	done   chan struct{}
+	once   sync.Once
+	scan   bool
+
+	mu    sync.Mutex // guards the rest, for subscriptions which queue
+	queue []*Response
+	ended bool          // set when the connection closes
+	wake  chan struct{} // signalled when queue or ended changes
+}
+
+func (s *subscription) cancel() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// send hands r to the subscription without blocking, and returns false if
+// it was dropped.
+func (s *subscription) send(r *Response) bool {
+	if !s.scan {
+		if r.IsGapScan() {
+			return true
+		}
+		s.mu.Lock()
+		s.queue = append(s.queue, r)
+		s.mu.Unlock()
+		s.signal()
+		return true
+	}
+	select {
+	case s.events <- r:
+	case <-s.done:
+	default:
+		return false
+	}
+	return true
+}
+
+// end closes the subscription's events once those queued are taken.
+func (s *subscription) end() {
+	if s.scan {
+		close(s.events)
+		return
+	}
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *subscription) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward passes queued events to the subscriber until it cancels, or the
+// queue is empty and the connection has closed.
+func (s *subscription) forward() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			ended := s.ended
+			s.mu.Unlock()
+			if ended {
+				close(s.events)
+				return
+			}
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		r := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		select {
+		case s.events <- r:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// conn is the persistent connection to a BLE112. A single goroutine reads
+// frames from it, handing command responses to the pending command and
+// events to every subscription.
+type conn struct {
+	// accessed atomically, so first for 64-bit alignment on 32-bit
+	// platforms
+	lastEvent     int64  // unix nanoseconds
+	scanEvents    uint64 // gap_scan_response events
+	droppedEvents uint64 // events a full scan subscription could not take
+	received      uint64 // packets received, polled for FilterStats
+
+	rwc       io.ReadWriteCloser
+	reader    *Reader
+	responses chan *Response
+	closed    chan struct{}
+
+	mu            sync.Mutex
+	subscriptions map[*subscription]bool
+	pollStop      chan struct{} // closed to stop polling the counters; nil if not polling
+
+	staleMu sync.Mutex // guards stale, and handing responses over
+	stale   [][2]byte  // class and id of commands which timed out, oldest first
+}
+
+func newConn(rwc io.ReadWriteCloser) *conn {
+	c := &conn{
+		rwc:           rwc,
+		reader:        NewReader(rwc),
+		responses:     make(chan *Response, 1),
+		closed:        make(chan struct{}),
+		subscriptions: make(map[*subscription]bool),
+		lastEvent:     time.Now().UnixNano(),
+	}
+	go c.loop()
+	return c
+}
+
+func (c *conn) loop() {
+	defer func() {
+		c.mu.Lock()
+		for s := range c.subscriptions {
+			s.end()
+			delete(c.subscriptions, s)
+		}
+		// closed under the lock, so that no subscription is added
+		// once the rest have ended
+		close(c.closed)
+		c.mu.Unlock()
+	}()
+
+	for {
+		r, err := c.reader.ReadResponse()
+		if err != nil {
+			return
+		}
+		if !r.IsEvent() {
+			// only the latest response is kept; sendCommand discards
+			// any that do not answer its command
+			c.staleMu.Lock()
+			if !c.late(r) {
+				select {
+				case <-c.responses:
+				default:
+				}
+				c.responses <- r
+			}
+			c.staleMu.Unlock()
+			continue
+		}
+		atomic.StoreInt64(&c.lastEvent, time.Now().UnixNano())
+		if r.Command() == 0 && (r.Class() == BG_MSG_CLASS_SYSTEM || r.Class() == BG_MSG_CLASS_DFU) {
+			// a BLE112 which has restarted answers nothing sent before
+			c.staleMu.Lock()
+			c.stale = nil
+			c.staleMu.Unlock()
+		}
+		if r.IsGapScan() {
+			atomic.AddUint64(&c.scanEvents, 1)
+		}
+
+		c.mu.Lock()
+		subscriptions := make([]*subscription, 0, len(c.subscriptions))
+		for s := range c.subscriptions {
+			subscriptions = append(subscriptions, s)
+		}
+		c.mu.Unlock()
+		// a scan which falls behind loses events rather than holding
+		// up the command responses behind them
+		for _, s := range subscriptions {
+			if !s.send(r) {
+				atomic.AddUint64(&c.droppedEvents, 1)
+			}
+		}
+	}
+}
+
+// subscribe returns a subscription to every event but gap_scan_response,
+// none of which are dropped.
+func (c *conn) subscribe() *subscription {
+	s := &subscription{
+		events: make(chan *Response),
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+	go s.forward()
+	return c.add(s)
+}
+
+// subscribeScans returns a subscription to every event, which drops those
+// it has no room for.
+func (c *conn) subscribeScans() *subscription {
+	s := &subscription{
+		events: make(chan *Response, 16),
+		done:   make(chan struct{}),
+		scan:   true,
+	}
+	return c.add(s)
+}
+
+func (c *conn) add(s *subscription) *subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		s.end()
+	default:
+		c.subscriptions[s] = true
+	}
+	return s
+}
+
+// late returns true if r answers a command which timed out, and so must
+// not be taken for the response to a later one. The BLE112 answers
+// commands in order, so any which timed out before r's command will never
+// be answered, and are forgotten. It is called with staleMu held.
+func (c *conn) late(r *Response) bool {
+	key := [2]byte{r.Class(), r.Command()}
+	for i, stale := range c.stale {
+		if stale == key {
+			c.stale = c.stale[i+1:]
+			return true
+		}
+	}
+	c.stale = nil
+	return false
+}
+
+func (c *conn) unsubscribe(s *subscription) {
+	s.cancel()
+	c.mu.Lock()
+	delete(c.subscriptions, s)
+	c.mu.Unlock()
+}
+
+// write writes a command without waiting for a response.
+func (c *conn) write(msgClass byte, msg byte, data []byte) error {
+	cmd := []byte{BG_COMMAND | byte(len(data)>>8)&0x07, byte(len(data)), msgClass, msg}
+	cmd = append(cmd, data...)
+	_, err := c.rwc.Write(cmd)
+	return err
+}
+
+// sendCommand writes a command and waits up to timeout for its response.
+func (c *conn) sendCommand(msgClass byte, msg byte, data []byte, timeout time.Duration) (*Response, error) {
+	// a response to a command written without waiting for it must not
+	// be taken for this one's
+	select {
+	case <-c.responses:
+	default:
+	}
+	if err := c.write(msgClass, msg, data); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-c.responses:
+			if r.Class() == msgClass && r.Command() == msg {
+				return r, nil
+			}
+		case <-c.closed:
+			return nil, ErrClosed
+		case <-timer.C:
+			// its response may yet arrive, after the next command has
+			// been sent; the reader holds staleMu while it hands one
+			// over, so it is either here now or will be known late
+			c.staleMu.Lock()
+			defer c.staleMu.Unlock()
+			select {
+			case r := <-c.responses:
+				if r.Class() == msgClass && r.Command() == msg {
+					return r, nil
+				}
+			default:
+			}
+			c.stale = append(c.stale, [2]byte{msgClass, msg})
+			return nil, ErrCommandTimeout
+		}
+	}
+}
+
+func (c *conn) close() error {
+	err := c.rwc.Close()
+	<-c.closed
+	return err
+}
+
+// Open opens the connection to the BLE112 if it is not already open. The
+// connection stays open until Close is called.
+func (device *Device) Open() error {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.conn != nil {
+		select {
+		case <-device.conn.closed:
+			device.conn.rwc.Close()
+		default:
+			return nil
+		}
+	}
+
+	opener := device.opener
+	if opener == nil {
+		opener = OpenSerialPort
+	}
+	rwc, err := opener(device.Port)
+	if err != nil {
+		return err
+	}
+	device.conn = newConn(rwc)
+	return nil
+}
+
+// Close closes the connection to the BLE112. Scans in progress end and
+// commands waiting for a response fail with ErrClosed.
+func (device *Device) Close() error {
+	device.mu.Lock()
+	c := device.conn
+	device.conn = nil
+	device.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// connected returns true if the Device's connection is open and has not
+// failed.
+func (device *Device) connected() bool {
+	c, err := device.currentConn()
+	if err != nil {
+		return false
+	}
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (device *Device) currentConn() (*conn, error) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.conn == nil {
+		return nil, ErrClosed
+	}
+	return device.conn, nil
+}
+
+// SendCommand sends a command to the BLE112 and returns its response.
+// Commands are serialized, so concurrent callers never interleave on the
+// wire and each receives the response to its own command.
+//
+// Errors are returned as a *CommandError. If the BLE112 responds with a
+// non-zero result code, the response is returned along with the error.
+func (device *Device) SendCommand(msgClass byte, msg byte, data []byte) (*Response, error) {
+	name := CommandName(msgClass, msg)
+	c, err := device.currentConn()
+	if err != nil {
+		return nil, &CommandError{name, err}
+	}
+	timeout := device.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	device.cmdMu.Lock()
+	r, err := c.sendCommand(msgClass, msg, data, timeout)
+	device.cmdMu.Unlock()
+	if err != nil {
+		return nil, &CommandError{name, err}
+	}
+	if result := r.Result(); result != 0 {
+		return r, &CommandError{name, result}
+	}
+	return r, nil
+}
+
+// lastEvent returns when the current connection last received an event,
+// or when it was opened if it has received none.
+func (device *Device) lastEvent() time.Time {
+	c, err := device.currentConn()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, atomic.LoadInt64(&c.lastEvent))
+}
+
+// DroppedEvents returns how many events the current connection has
+// dropped because the consumer of a Scan fell behind.
+func (device *Device) DroppedEvents() uint64 {
+	c, err := device.currentConn()
+	if err != nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.droppedEvents)
+}
+
+// FramingStats returns the framing counters for the current connection.
+func (device *Device) FramingStats() FramingStats {
+	c, err := device.currentConn()
+	if err != nil {
+		return FramingStats{}
+	}
+	return c.reader.Stats()
+}
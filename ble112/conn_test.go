@@ -0,0 +1,162 @@
+package ble112
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respond answers every command read from c with a canned response until
+// c is closed. Commands must arrive whole; a torn command fails the test.
+func respond(t *testing.T, c net.Conn) {
+	for {
+		header := make([]byte, headerLength)
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		payload := make([]byte, payloadLength(header))
+		if _, err := io.ReadFull(c, payload); err != nil {
+			return
+		}
+		var response []byte
+		switch {
+		case header[2] == BG_MSG_CLASS_SYSTEM && header[3] == BG_GET_ADDRESS:
+			response = addressResponse
+		case header[2] == BG_MSG_CLASS_SYSTEM && header[3] == BG_GET_INFO:
+			response = infoResponse
+		case header[2] == BG_MSG_CLASS_SYSTEM && header[3] == 1:
+			response = helloResponse
+		case header[2] == BG_MSG_CLASS_GAP:
+			response = []byte{0x00, 0x02, BG_MSG_CLASS_GAP, header[3], 0x00, 0x00}
+		default:
+			t.Errorf("unexpected command %x", header)
+			return
+		}
+		c.Write(scanEvent)
+		c.Write(response)
+	}
+}
+
+func TestDeviceFromConn(t *testing.T) {
+	host, dongle := net.Pipe()
+	go respond(t, dongle)
+
+	device, err := NewDeviceFromConn(host)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := device.DeviceAddress(); got != "00:07:80:14:47:d5" {
+		t.Errorf("got %v; expected 00:07:80:14:47:d5", got)
+	}
+	if got := device.DeviceVersion(); got != "1.3.2-122" {
+		t.Errorf("got %v; expected 1.3.2-122", got)
+	}
+
+	// concurrent commands each get their own response, despite events
+	// arriving in between
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, 1, NULL_DATA)
+			if err != nil || !bytes.Equal(r.Data, helloResponse) {
+				t.Errorf("got %v, %v; expected hello response", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := device.Close(); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, 1, NULL_DATA); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v; expected ErrClosed", err)
+	}
+	if err := device.Open(); err == nil {
+		t.Errorf("expected a closed connection not to reopen")
+	}
+}
+
+func TestDeviceLateResponse(t *testing.T) {
+	host, dongle := net.Pipe()
+	go func() {
+		late := true
+		for {
+			header := make([]byte, headerLength)
+			if _, err := io.ReadFull(dongle, header); err != nil {
+				return
+			}
+			payload := make([]byte, payloadLength(header))
+			if _, err := io.ReadFull(dongle, payload); err != nil {
+				return
+			}
+			if header[2] != BG_MSG_CLASS_GAP {
+				continue
+			}
+			// the first command is answered too late, with a failure
+			if late {
+				late = false
+				time.Sleep(100 * time.Millisecond)
+				dongle.Write([]byte{0x00, 0x02, BG_MSG_CLASS_GAP, header[3], 0x81, 0x01})
+				continue
+			}
+			// in time, but not so quickly as to displace the late one
+			time.Sleep(10 * time.Millisecond)
+			dongle.Write([]byte{0x00, 0x02, BG_MSG_CLASS_GAP, header[3], 0x00, 0x00})
+		}
+	}()
+
+	device := &Device{conn: newConn(host), Timeout: 50 * time.Millisecond}
+	defer device.Close()
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA); !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("got %v; expected a timeout", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA); err != nil {
+		t.Errorf("got %v; expected the late response discarded", err)
+	}
+}
+
+func TestDeviceLateResponseAfterRetry(t *testing.T) {
+	host, dongle := net.Pipe()
+	go func() {
+		var late []byte
+		for {
+			header := make([]byte, headerLength)
+			if _, err := io.ReadFull(dongle, header); err != nil {
+				return
+			}
+			payload := make([]byte, payloadLength(header))
+			if _, err := io.ReadFull(dongle, payload); err != nil {
+				return
+			}
+			if header[2] != BG_MSG_CLASS_GAP {
+				continue
+			}
+			// the first command is answered, with a failure, only once
+			// the retry has been written
+			if late == nil {
+				late = []byte{0x00, 0x02, BG_MSG_CLASS_GAP, header[3], 0x81, 0x01}
+				continue
+			}
+			dongle.Write(late)
+			// not so quickly as to displace the late one
+			time.Sleep(10 * time.Millisecond)
+			dongle.Write([]byte{0x00, 0x02, BG_MSG_CLASS_GAP, header[3], 0x00, 0x00})
+		}
+	}()
+
+	device := &Device{conn: newConn(host), Timeout: 50 * time.Millisecond}
+	defer device.Close()
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA); !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("got %v; expected a timeout", err)
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA); err != nil {
+		t.Errorf("got %v; expected the late response discarded", err)
+	}
+}
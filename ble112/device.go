@@ -1,41 +1,78 @@
 package ble112
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/RadiusNetworks/go-beacon"
 	"github.com/RadiusNetworks/go-beacon/advertiser"
-	"github.com/tarm/serial"
 )
 
 // Device represents a USB connected BLE112 which can be used for
-// BLE scanning or advertising.
+// BLE scanning or advertising. The connection to the BLE112 is opened by
+// NewDevice and stays open until Close is called.
 type Device struct {
 	Port       string
 	MacAddress *beacon.MacAddress
-	f          *serial.Port
-}
-
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
+	// Info holds the firmware and hardware versions read by NewDevice.
+	Info *Info
+	// Timeout bounds how long a command waits for its response. Zero
+	// means DefaultTimeout.
+	Timeout time.Duration
+	// ConnectTimeout bounds how long Connect waits for a peripheral. Zero
+	// means DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+	// ScanOptions configures scans started by StartScan and Scan.
+	ScanOptions ScanOptions
+	// AdvertiseOptions configures advertising started by StartAdvertising,
+	// AdvertiseMfgData and AdvertiseServiceData.
+	AdvertiseOptions advertiser.AdvertiseOptions
+	opener           PortOpener
+
+	mu            sync.Mutex // guards the fields below, except cmdMu
+	conn          *conn
+	scanning      bool
+	advertising   []byte              // the data advertised, or nil
+	payload       *advertiser.Payload // what advertising came from, if Advertise
+	advertEnded   chan struct{}       // closed when the payload stops
+	randomAddress *beacon.MacAddress  // set by SetRandomAddress, or nil
+	whitelist     []WhitelistEntry    // the whitelist, which a reset clears
+	cmdMu         sync.Mutex          // serializes command/response exchanges
 }
 
 const (
 	BG_COMMAND              = byte(0)
 	BG_MSG_CLASS_SYSTEM     = byte(0)
+	BG_MSG_CLASS_FLASH      = byte(1)
 	BG_MSG_CLASS_CONNECTION = byte(3)
+	BG_MSG_CLASS_ATTCLIENT  = byte(4)
 	BG_MSG_CLASS_GAP        = byte(6)
+	BG_MSG_CLASS_HARDWARE   = byte(7)
+	BG_MSG_CLASS_DFU        = byte(9)
+	BG_RESET                = byte(0)
+	BG_HELLO                = byte(1)
 	BG_GET_ADDRESS          = byte(2)
+	BG_GET_COUNTERS         = byte(5)
+	BG_GET_INFO             = byte(8)
+	BG_WHITELIST_APPEND     = byte(10)
+	BG_WHITELIST_REMOVE     = byte(11)
+	BG_WHITELIST_CLEAR      = byte(12)
+	BG_PS_DUMP              = byte(1)
+	BG_PS_SAVE              = byte(3)
+	BG_PS_LOAD              = byte(4)
+	BG_PS_ERASE             = byte(5)
 	BG_DISCONNECT           = byte(0)
 	BG_SET_MODE             = byte(1)
 	BG_DISCOVER             = byte(2)
+	BG_CONNECT_DIRECT       = byte(3)
 	BG_DISCOVER_STOP        = byte(4)
+	BG_SET_FILTERING        = byte(6)
 	BG_SCAN_PARAMS          = byte(7)
 	BG_GAP_NON_DISCOVERABLE = byte(0)
 	BG_GAP_NON_CONNECTABLE  = byte(0)
@@ -44,41 +81,95 @@ const (
 	BG_GAP_USER_DATA        = byte(4)
 	BG_GAP_SET_ADV_PARAM    = byte(8)
 	BG_GAP_SET_ADV_DATA     = byte(9)
+	BG_SET_NONRESOLVABLE    = byte(12)
+	BG_SET_TXPOWER          = byte(12)
+	BG_READ_BY_GROUP_TYPE   = byte(1)
+	BG_READ_BY_TYPE         = byte(2)
+	BG_FIND_INFORMATION     = byte(3)
+	BG_ATTRIBUTE_WRITE      = byte(5)
+	BG_WRITE_COMMAND        = byte(6)
+	BG_INDICATE_CONFIRM     = byte(7)
+	BG_READ_LONG            = byte(8)
+	BG_DFU_RESET            = byte(0)
+	BG_DFU_SET_ADDRESS      = byte(1)
+	BG_DFU_UPLOAD           = byte(2)
+	BG_DFU_UPLOAD_FINISH    = byte(3)
 	BG_EVENT                = byte(0x80)
 )
 
 var NULL_DATA = make([]byte, 0)
 
+// DefaultTimeout is how long a command waits for its response when the
+// Device's Timeout is not set.
+const DefaultTimeout = time.Second
+
 // NewDevice creates and initializes a new BLE112Device
 // given a particular port
 func NewDevice(port string) (*Device, error) {
-	var device Device
-	device.Port = port
+	return NewDeviceWithOpener(port, OpenSerialPort)
+}
 
-	// stop scanning, clear buffer
+// NewDeviceWithOpener creates and initializes a new BLE112Device, using
+// open to connect to the given port.
+func NewDeviceWithOpener(port string, open PortOpener) (*Device, error) {
+	device := Device{Port: port, opener: open}
 	if err := device.Open(); err != nil {
 		return nil, err
 	}
-	device.StopScan()
-	device.Close()
+	if err := device.init(); err != nil {
+		device.Close()
+		return nil, err
+	}
+	return &device, nil
+}
 
-	device.GetAddress()
+// NewDeviceFromConn creates and initializes a new BLE112Device which
+// communicates over an already open connection. Once closed, the Device
+// cannot be reopened.
+func NewDeviceFromConn(rwc io.ReadWriteCloser) (*Device, error) {
+	used := false
+	return NewDeviceWithOpener("", func(string) (io.ReadWriteCloser, error) {
+		if used {
+			return nil, ErrClosed
+		}
+		used = true
+		return rwc, nil
+	})
+}
 
+func (device *Device) init() error {
+	if err := device.StopScan(); err != nil {
+		return err
+	}
+	if _, err := device.GetAddress(); err != nil {
+		return err
+	}
 	if device.MacAddress == nil {
-		return nil, errors.New("Non-BLE112 MAC address detected")
+		return errors.New("Non-BLE112 MAC address detected")
 	}
-	return &device, nil
+	if _, err := device.GetInfo(); err != nil {
+		return err
+	}
+	return nil
 }
 
 func (device *Device) String() string {
 	return fmt.Sprintf("BLE112 %v @ %v", device.MacAddress, device.Port)
 }
 
+// DeviceVersion returns the BLE112's firmware version, such as
+// "1.3.2-122", or "N/A" if it is not known.
 func (d *Device) DeviceVersion() string {
-	return "N/A"
+	if d.Info == nil {
+		return "N/A"
+	}
+	return d.Info.String()
 }
 
 func (d *Device) DeviceAddress() string {
+	if d.MacAddress == nil {
+		return ""
+	}
 	return d.MacAddress.String()
 }
 
@@ -86,197 +177,282 @@ func (d *Device) DevicePath() string {
 	return d.Port
 }
 
-// Open opens the serial port connection to the BLE112
-func (device *Device) Open() error {
-	var err error
-	c := serial.Config{Name: device.Port, Baud: 115200}
-	device.f, err = serial.OpenPort(&c)
-	return err
-}
-
-// Close closes the serial port connection
-func (device *Device) Close() {
-	device.f.Close()
-	device.f = nil
-}
-
-// SendCommand sends a command to a BLE112
-func (device *Device) SendCommand(msgClass byte, msg byte, data []byte) (*Response, error) {
-	dataSize := byte(len(data))
-	cmd := []byte{BG_COMMAND, dataSize, msgClass, msg}
-	cmd = append(cmd, data...)
-	device.f.Write(cmd)
-
-	return device.Read()
-}
-
 // GetAddress retrieves the BLE112's mac address, stores it on the device struct,
 // and returns it (or returns an error, if one is encountered).
 func (device *Device) GetAddress() (beacon.MacAddress, error) {
 	if device.MacAddress != nil {
 		return *device.MacAddress, nil
 	}
-	device.Open()
-	defer device.Close()
-	var r *Response
-	var err error
-	retries := 4
-	for err == nil && retries >= 0 {
-		// sometimes it doesn't respond and we have to ask it again
-		// not sure why.
-		r, err = device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_GET_ADDRESS, NULL_DATA)
-		if err != nil {
-			return beacon.MacAddress{}, err
-		}
-		retries--
-		if len(r.Data) >= 10 && bytes.Equal(r.Data[0:4], []byte{0, 6, 0, 2}) {
-			break
-		}
-	}
-
+	r, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_GET_ADDRESS, NULL_DATA)
 	if err != nil {
 		return beacon.MacAddress{}, err
 	}
-	if len(r.Data) < 10 {
+	if len(r.Payload()) < 6 {
 		return beacon.MacAddress{}, fmt.Errorf("error getting address: not enough bytes")
 	}
 	var macAddress beacon.MacAddress
-	copy(macAddress[:], r.Data[4:10])
+	copy(macAddress[:], r.Payload()[0:6])
 	device.MacAddress = &macAddress
 	return macAddress, nil
 }
 
-func (device *Device) StartAdvertising(data []byte) {
-	device.SendCommand(BG_MSG_CLASS_CONNECTION, BG_DISCONNECT, NULL_DATA)
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE})
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_GAP_SET_ADV_PARAM, []byte{0xA0, 0x00, 0xA0, 0x00, 0x07})
+// StartAdvertising advertises the given AD structures, following the
+// flags, as user data, as configured by the Device's AdvertiseOptions.
+func (device *Device) StartAdvertising(data []byte) error {
+	if err := device.sendAdvertisement(data); err != nil {
+		return err
+	}
+	device.setAdvertising(data, nil)
+	return nil
+}
+
+// Advertise advertises p, as StartAdvertising does, until ctx is done or
+// advertising is stopped or replaced.
+func (device *Device) Advertise(ctx context.Context, p advertiser.Payload) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data := p.ADStructures()
+	if err := device.sendAdvertisement(data); err != nil {
+		return err
+	}
+	ended := device.setAdvertising(data, &p)
+	go func() {
+		select {
+		case <-ctx.Done():
+			device.mu.Lock()
+			current := device.advertEnded == ended
+			device.mu.Unlock()
+			if current {
+				device.StopAdvertising()
+			}
+		case <-ended:
+		}
+	}()
+	return nil
+}
+
+// IsAdvertising returns true while the BLE112 is advertising.
+func (device *Device) IsAdvertising() bool {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	return device.advertising != nil
+}
+
+// Current returns the payload being advertised, and false if there is
+// none or the advertisement was started with StartAdvertising.
+func (device *Device) Current() (advertiser.Payload, bool) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.payload == nil {
+		return advertiser.Payload{}, false
+	}
+	return *device.payload, true
+}
+
+// setAdvertising records the data advertised, or nil if advertising
+// stopped, and the payload it came from, if any. It ends the previous
+// advertisement, and returns a channel which is closed when this one
+// ends.
+func (device *Device) setAdvertising(data []byte, p *advertiser.Payload) chan struct{} {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.advertEnded != nil {
+		close(device.advertEnded)
+		device.advertEnded = nil
+	}
+	device.advertising = append([]byte(nil), data...)
+	if data == nil {
+		device.advertising = nil
+	}
+	device.payload = p
+	if p != nil {
+		device.advertEnded = make(chan struct{})
+	}
+	return device.advertEnded
+}
+
+// sendAdvertisement configures the BLE112 to advertise data.
+func (device *Device) sendAdvertisement(data []byte) error {
+	device.mu.Lock()
+	opts := device.AdvertiseOptions
+	if device.randomAddress != nil {
+		// connectable advertising would be from the public address
+		opts.NonConnectable = true
+	}
+	device.mu.Unlock()
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("ble112: %v", err)
+	}
+	if err := device.disconnect(); err != nil {
+		return err
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE}); err != nil {
+		return err
+	}
+	if opts.TxPower != nil {
+		if _, err := device.SendCommand(BG_MSG_CLASS_HARDWARE, BG_SET_TXPOWER, []byte{txPowerLevel(*opts.TxPower)}); err != nil {
+			return err
+		}
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_GAP_SET_ADV_PARAM, advParameters(opts)); err != nil {
+		return err
+	}
 	b := append([]byte{0x00, byte(len(data) + 3), 0x02, 0x01, 0x06}, data...)
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_GAP_SET_ADV_DATA, b)
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_USER_DATA, BG_GAP_CONNECTABLE})
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_GAP_SET_ADV_DATA, b); err != nil {
+		return err
+	}
+	connectable := BG_GAP_CONNECTABLE
+	if opts.NonConnectable {
+		connectable = BG_GAP_NON_CONNECTABLE
+	}
+	_, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_USER_DATA, connectable})
+	return err
+}
+
+// disconnect closes any connection to a remote device.
+func (device *Device) disconnect() error {
+	_, err := device.SendCommand(BG_MSG_CLASS_CONNECTION, BG_DISCONNECT, []byte{0})
+	if errors.Is(err, ErrNotConnected) {
+		return nil
+	}
+	return err
 }
 
 // advertiser.Advertiser interface
 
+var _ advertiser.Advertiser = (*Device)(nil)
+
 // AdvertiseMfgData advertises manufacturer data using the given mfg id
-func (device *Device) AdvertiseMfgData(id uint16, ad advertiser.Advertisement) {
-	device.Open()
-	header := []byte{uint8(len(ad) + 1), 0xff, uint8(id), uint8(id >> 8)}
-	device.StartAdvertising(append(header, ad[2:]...))
-	device.Close()
+func (device *Device) AdvertiseMfgData(id uint16, ad advertiser.Advertisement) error {
+	return device.StartAdvertising(mfgData(id, ad))
 }
 
 // AdvertiseServiceData advertises the given service data with the given service uuid
-func (device *Device) AdvertiseServiceData(id uint16, ad advertiser.Advertisement) {
-	device.Open()
-	header := []byte{
-		0x03, 0x03, uint8(id), uint8(id >> 8),
-		uint8(len(ad) + 1), 0x16, uint8(id), uint8(id >> 8),
-	}
-	device.StartAdvertising(append(header, ad[2:]...))
-	device.Close()
+func (device *Device) AdvertiseServiceData(id uint16, ad advertiser.Advertisement) error {
+	return device.StartAdvertising(serviceData(id, ad))
+}
+
+// mfgData frames ad, as generated by beacon.Parser.GenerateAd, as
+// manufacturer data under the given company id.
+func mfgData(id uint16, ad advertiser.Advertisement) []byte {
+	return advertiser.MfgDataPayload(id, ad).ADStructures()
+}
+
+// serviceData frames ad, as generated by beacon.Parser.GenerateAd, as
+// service data under the given 16-bit service uuid.
+func serviceData(id uint16, ad advertiser.Advertisement) []byte {
+	return advertiser.ServiceDataPayload(id, ad).ADStructures()
 }
 
 // StopAdvertising stops advertising data
-func (device *Device) StopAdvertising() {
-	device.Open()
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE})
-	device.Close()
+func (device *Device) StopAdvertising() error {
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE}); err != nil {
+		return err
+	}
+	device.setAdvertising(nil, nil)
+	return nil
 }
 
-// StartScan tells the BLE112 to start scanning.
-func (device *Device) StartScan() {
-	device.SendCommand(BG_MSG_CLASS_CONNECTION, BG_DISCONNECT, NULL_DATA)
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE})
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA)
-	scanParams := []byte{200, 0, 200, 0, 0}
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_SCAN_PARAMS, scanParams)
-	device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER, []byte{BG_GAP_DISCOVER_ALL})
+// StartScan tells the BLE112 to start scanning, as configured by the
+// Device's ScanOptions.
+func (device *Device) StartScan() error {
+	device.mu.Lock()
+	opts := device.ScanOptions
+	device.mu.Unlock()
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := device.disconnect(); err != nil {
+		return err
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE}); err != nil {
+		return err
+	}
+	device.setAdvertising(nil, nil)
+	if err := device.StopScan(); err != nil {
+		return err
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_FILTERING, opts.filtering()); err != nil {
+		return err
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SCAN_PARAMS, opts.scanParameters()); err != nil {
+		return err
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER, []byte{discoverModes[opts.Mode]}); err != nil {
+		return err
+	}
+	device.mu.Lock()
+	device.scanning = true
+	device.mu.Unlock()
+	return nil
 }
 
-// StopScan tells the BLE112 to stop scanning.
-func (device *Device) StopScan() {
-	device.f.Write([]byte{BG_COMMAND, 0, BG_MSG_CLASS_GAP, BG_DISCOVER_STOP})
+// StopScan tells the BLE112 to stop scanning. It is not an error to stop
+// a BLE112 which is not scanning.
+func (device *Device) StopScan() error {
+	_, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA)
+	if err != nil && !errors.Is(err, ErrDeviceInWrongState) {
+		return err
+	}
+	device.mu.Lock()
+	device.scanning = false
+	device.mu.Unlock()
+	return nil
 }
 
 // Scan uses the BLE112 device to scan for advertisements. It appends scans to
-// the data channel, and exits when it recieves something on the done channel.
+// the data channel, and exits when it recieves something on the done channel
+// or the connection to the device is closed.
 func (device *Device) Scan(data chan beacon.ScanData, done chan bool) {
-	device.Open()
-	device.StartScan()
-
-	readChan := make(chan *Response, 2)
-	shouldStop := false
-	go func() {
-		for !shouldStop {
-			r, err := device.Read()
-			if err == nil {
-				readChan <- r
-			}
-		}
-		close(readChan)
-	}()
+	defer close(data)
+	c, err := device.currentConn()
+	if err != nil {
+		return
+	}
+	events := c.subscribeScans()
+	if err := device.StartScan(); err != nil {
+		c.unsubscribe(events)
+		return
+	}
+	// deferred calls run last first: stop taking events before waiting
+	// on the response to gap_end_procedure
+	defer device.StopScan()
+	defer c.unsubscribe(events)
 
-loop:
 	for {
 		select {
-		case r, more := <-readChan:
+		case r, more := <-events.events:
 			if !more {
-				break loop
+				return
 			}
-			if r.IsAdvertisement() {
-				if r.IsMfgAd() {
-					data <- beacon.ScanData{
-						Bytes:  r.Data[20:],
-						Device: r.MacAddress().String(),
-						RSSI:   r.RSSI(),
-						Raw:    &r.Data,
-					}
-				} else {
-					data <- beacon.ScanData{
-						Bytes:  r.Data[24:],
-						Device: r.MacAddress().String(),
-						RSSI:   r.RSSI(),
-						Raw:    &r.Data,
-					}
-				}
+			if !r.IsAdvertisement() && !r.IsScanResponse() {
+				continue
+			}
+			// scan responses with no beacon data, such as those
+			// carrying only a name, are still delivered, with their
+			// AD structures left in Raw
+			scan := beacon.ScanData{
+				Bytes:       r.AdData(),
+				Device:      r.MacAddress().String(),
+				AddressType: r.AddressType(),
+				AdvType:     r.PacketType(),
+				RSSI:        r.RSSI(),
+				Raw:         &r.Data,
+				Adapter:     device.DeviceAddress(),
+			}
+			select {
+			case data <- scan:
+			case <-done:
+				return
 			}
 		case <-done:
-			shouldStop = true
+			return
 		}
 	}
-	close(data)
-	device.StopScan()
-	device.Close()
-}
-
-// Read from the BLE112 device
-func (device *Device) Read() (*Response, error) {
-	var err error
-	var byteCount int
-	var output []byte
-
-	if device.f == nil {
-		return nil, errors.New("Device already closed!")
-	}
-	header := make([]byte, 4)
-	byteCount, err = device.f.Read(header)
-	if err != nil {
-		return nil, err
-	}
-	output = append(output, header...)
-
-	bytesLeft := int(header[1])
-	for bytesLeft > 0 {
-		buffer := make([]byte, bytesLeft)
-		byteCount, err = device.f.Read(buffer)
-		if err != nil {
-			return nil, err
-		}
-		output = append(output, buffer[0:byteCount]...)
-		bytesLeft -= byteCount
-	}
-
-	return &Response{output}, err
 }
 
 // DevicePaths returns a list of paths that correspond with possible
@@ -296,16 +472,12 @@ func DevicePaths() ([]string, error) {
 	return paths, err
 }
 
-// Devices finds all the BLE112 devices that are currently on the system.
+// Devices finds all the BLE112 devices that are currently on the system,
+// probing ports concurrently. The devices returned are open.
 func Devices() []*Device {
-	var devices []*Device
 	paths, err := DevicePaths()
-	check(err)
-	for _, port := range paths {
-		var device, _ = NewDevice(port)
-		if device != nil && device.MacAddress != nil {
-			devices = append(devices, device)
-		}
+	if err != nil {
+		return nil
 	}
-	return devices
+	return probePorts(paths, OpenSerialPort, ProbeTimeout)
 }
@@ -0,0 +1,29 @@
+package ble112_test
+
+import (
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func TestNewDeviceSerialPort(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	defer e.Close()
+	path, err := e.OpenPTY()
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+
+	device, err := ble112.NewDevice(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Close()
+	if *device.MacAddress != emulator.DefaultAddress {
+		t.Errorf("got %v; expected %v", device.MacAddress, emulator.DefaultAddress)
+	}
+	if scans := scan(t, device, 3); scans[0].Device != beaconAddr.String() {
+		t.Errorf("got %v; expected %v", scans[0].Device, beaconAddr)
+	}
+}
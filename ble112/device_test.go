@@ -0,0 +1,442 @@
+package ble112_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/advertiser"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+var (
+	altBeacon   = beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 1, 1, -59)
+	altBeaconAd = beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"]).GenerateAd(altBeacon)
+	beaconAddr  = beacon.ParseMacAddress("00:07:80:aa:bb:cc")
+)
+
+func newEmulator(cfg emulator.Config) *emulator.Emulator {
+	if cfg.Beacons == nil {
+		cfg.Beacons = []emulator.Beacon{{
+			Address: beaconAddr,
+			Data:    emulator.MfgData(0x0118, altBeaconAd),
+			RSSI:    -60,
+		}}
+	}
+	return emulator.New(cfg)
+}
+
+func newDevice(t *testing.T, e *emulator.Emulator) *ble112.Device {
+	device, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { device.Close() })
+	return device
+}
+
+// scan scans with device until n scans are received.
+func scan(t *testing.T, device *ble112.Device, n int) []beacon.ScanData {
+	data := make(chan beacon.ScanData)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		device.Scan(data, done)
+		finished <- true
+	}()
+
+	var scans []beacon.ScanData
+	timeout := time.After(5 * time.Second)
+	for len(scans) < n {
+		select {
+		case scan := <-data:
+			scans = append(scans, scan)
+		case <-timeout:
+			t.Fatalf("got %v scans; expected %v", len(scans), n)
+		}
+	}
+	close(done)
+	for range data {
+	}
+	<-finished
+	return scans
+}
+
+func TestNewDevice(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+	if *device.MacAddress != emulator.DefaultAddress {
+		t.Errorf("got %v; expected %v", device.MacAddress, emulator.DefaultAddress)
+	}
+	if device.DevicePath() != "emulated" {
+		t.Errorf("got %v; expected emulated", device.DevicePath())
+	}
+	if device.Info == nil || *device.Info != emulator.DefaultInfo {
+		t.Errorf("got info %+v; expected %+v", device.Info, emulator.DefaultInfo)
+	}
+	if v := device.DeviceVersion(); v != "1.3.2-122" {
+		t.Errorf("got version %v; expected 1.3.2-122", v)
+	}
+}
+
+func TestDeviceAdapter(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	var adapter beacon.Adapter = device
+	if adapter.DeviceType() != "ble112" || !adapter.Capabilities().Has(beacon.CapScan|beacon.CapAdvertise) {
+		t.Errorf("got %v with %v; expected a ble112 that scans and advertises", adapter.DeviceType(), adapter.Capabilities())
+	}
+	if err := adapter.Health(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	adapter.Close()
+	if err := adapter.Health(); !errors.Is(err, ble112.ErrClosed) {
+		t.Errorf("got %v; expected ErrClosed", err)
+	}
+	if err := adapter.Open(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := adapter.Health(); err != nil {
+		t.Errorf("unexpected error after reopening: %v", err)
+	}
+}
+
+func TestDeviceCounters(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	scans := scan(t, device, 3)
+	c, err := device.Counters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if int(c.RxOK) < len(scans) {
+		t.Errorf("got %v packets received; expected at least %v", c.RxOK, len(scans))
+	}
+	if c.FreeBuffers == 0 {
+		t.Error("got no free buffers")
+	}
+	if c, _ := device.Counters(); c.RxOK != 0 {
+		t.Errorf("got %v packets received; expected the counters to reset", c.RxOK)
+	}
+}
+
+func TestDeviceScan(t *testing.T) {
+	e := newEmulator(emulator.Config{RSSINoise: 3})
+	device := newDevice(t, e)
+
+	for _, scan := range scan(t, device, 5) {
+		if scan.Device != beaconAddr.String() {
+			t.Errorf("got %v; expected %v", scan.Device, beaconAddr)
+		}
+		if scan.RSSI < -63 || scan.RSSI > -57 {
+			t.Errorf("rssi %v is outside the configured noise", scan.RSSI)
+		}
+		if b := beacon.Parse(scan.Bytes, beacon.DefaultParsers()); b == nil || !b.Ids.Equal(altBeacon.Ids) {
+			t.Errorf("got %v; expected %v", b, altBeacon)
+		}
+	}
+	if e.Discovering() {
+		t.Error("expected scanning to stop")
+	}
+}
+
+func TestDeviceScanCorruption(t *testing.T) {
+	e := newEmulator(emulator.Config{CorruptionRate: 0.3, Seed: 1})
+	device := newDevice(t, e)
+
+	// a dropped byte can go unnoticed until the following frame, but the
+	// stream must recover rather than misparse everything after it
+	valid := 0
+	for _, scan := range scan(t, device, 40) {
+		if b := beacon.Parse(scan.Bytes, beacon.DefaultParsers()); b != nil && b.Ids.Equal(altBeacon.Ids) {
+			valid++
+		}
+	}
+	if valid < 20 {
+		t.Errorf("only %v of 40 scans were valid", valid)
+	}
+	if stats := device.FramingStats(); stats.Resyncs == 0 {
+		t.Errorf("expected corruption to be detected, got %+v", stats)
+	}
+}
+
+func TestDeviceScanClosed(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	data := make(chan beacon.ScanData)
+	go device.Scan(data, make(chan bool))
+	<-data
+	e.Close()
+	for range data {
+	}
+}
+
+func TestDeviceScanSlowConsumer(t *testing.T) {
+	var beacons []emulator.Beacon
+	for i := 0; i < 40; i++ {
+		beacons = append(beacons, emulator.Beacon{
+			Address: beacon.MacAddress{byte(i), 1},
+			Data:    emulator.MfgData(0x0118, altBeaconAd),
+			RSSI:    -60,
+		})
+	}
+	e := newEmulator(emulator.Config{Beacons: beacons, ScanInterval: time.Millisecond})
+	device := newDevice(t, e)
+
+	data := make(chan beacon.ScanData)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		device.Scan(data, done)
+		finished <- true
+	}()
+	<-data
+	// a consumer which stalls must not hold up command responses
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if err := device.Health(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(done)
+	<-finished
+	if elapsed := time.Since(start); elapsed > ble112.DefaultTimeout/2 {
+		t.Errorf("took %v to stop scanning", elapsed)
+	}
+	if e.Discovering() {
+		t.Error("expected scanning to stop")
+	}
+	if device.DroppedEvents() == 0 {
+		t.Error("expected the stalled consumer to drop events")
+	}
+}
+
+func TestDeviceAdvertise(t *testing.T) {
+	e := newEmulator(emulator.Config{Latency: time.Millisecond})
+	device := newDevice(t, e)
+
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := e.AdvData(); !bytes.Equal(got, emulator.MfgData(0x0118, altBeaconAd)) {
+		t.Errorf("got %x; expected %x", got, emulator.MfgData(0x0118, altBeaconAd))
+	}
+	if discoverable, _ := e.Mode(); discoverable != ble112.BG_GAP_USER_DATA {
+		t.Errorf("got mode %v; expected user data", discoverable)
+	}
+	if got, expected := e.AdvParams(), []byte{0xa0, 0x00, 0xa0, 0x00, 0x07}; !bytes.Equal(got, expected) {
+		t.Errorf("got adv parameters %x; expected %x", got, expected)
+	}
+	if _, ok := e.TxPower(); ok {
+		t.Error("tx power set without being configured")
+	}
+
+	if err := device.StopAdvertising(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discoverable, _ := e.Mode(); discoverable != ble112.BG_GAP_NON_DISCOVERABLE {
+		t.Errorf("got mode %v; expected non-discoverable", discoverable)
+	}
+}
+
+func TestEmulatorMalformedAdvData(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	// a length past the end of the payload fails the command rather
+	// than the emulator
+	for _, payload := range [][]byte{{0x00, 0x10, 0x02, 0x01, 0x06}, {0x00}} {
+		_, err := device.SendCommand(ble112.BG_MSG_CLASS_GAP, ble112.BG_GAP_SET_ADV_DATA, payload)
+		if !errors.Is(err, ble112.ErrInvalidParameter) {
+			t.Errorf("%x: got %v; expected an invalid parameter", payload, err)
+		}
+	}
+	if err := device.Health(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDeviceAdvertiseContext(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := advertiser.MfgDataPayload(0x0118, altBeaconAd)
+	if err := device.Advertise(ctx, p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := e.AdvData(), emulator.MfgData(0x0118, altBeaconAd); !bytes.Equal(got, expected) {
+		t.Errorf("got %x; expected %x", got, expected)
+	}
+	if current, ok := device.Current(); !ok || current.ID != 0x0118 || !device.IsAdvertising() {
+		t.Errorf("got %+v, %v; expected the payload", current, ok)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for device.IsAdvertising() {
+		if time.Now().After(deadline) {
+			t.Fatal("still advertising after the context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if discoverable, _ := e.Mode(); discoverable != ble112.BG_GAP_NON_DISCOVERABLE {
+		t.Errorf("got mode %v; expected non-discoverable", discoverable)
+	}
+	if _, ok := device.Current(); ok {
+		t.Error("payload still current after the context was cancelled")
+	}
+	if err := device.Advertise(ctx, p); err != context.Canceled {
+		t.Errorf("got %v; expected a cancelled context to be refused", err)
+	}
+}
+
+func TestDeviceAdvertiseOptions(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	device.AdvertiseOptions = advertiser.AdvertiseOptions{
+		MinInterval:    500 * time.Millisecond,
+		MaxInterval:    time.Second,
+		Channels:       advertiser.Channel37 | advertiser.Channel39,
+		NonConnectable: true,
+		TxPower:        advertiser.TxPower(-6),
+	}
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := e.AdvParams(), []byte{0x20, 0x03, 0x40, 0x06, 0x05}; !bytes.Equal(got, expected) {
+		t.Errorf("got adv parameters %x; expected %x", got, expected)
+	}
+	if _, connectable := e.Mode(); connectable != ble112.BG_GAP_NON_CONNECTABLE {
+		t.Errorf("got connectable mode %v; expected non-connectable", connectable)
+	}
+	if level, ok := e.TxPower(); !ok || level != 9 {
+		t.Errorf("got tx power level %v; expected 9", level)
+	}
+
+	device.AdvertiseOptions.TxPower = advertiser.TxPower(20)
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if level, _ := e.TxPower(); level != 15 {
+		t.Errorf("got tx power level %v; expected 15", level)
+	}
+
+	device.AdvertiseOptions = advertiser.AdvertiseOptions{MinInterval: 50 * time.Millisecond, NonConnectable: true}
+	before := len(e.Commands())
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err == nil {
+		t.Error("expected an error for a non-connectable interval under 100ms")
+	}
+	if len(e.Commands()) != before {
+		t.Errorf("commands sent despite invalid options: %v", e.Commands()[before:])
+	}
+}
+
+func TestDeviceCommandErrors(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	e.SetResult("gap_set_adv_data", ble112.ErrInvalidParameter)
+	err := device.AdvertiseMfgData(0x0118, altBeaconAd)
+	if !errors.Is(err, ble112.ErrInvalidParameter) {
+		t.Errorf("got %v; expected invalid parameter", err)
+	}
+	var cmdErr *ble112.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "gap_set_adv_data" {
+		t.Errorf("got %v; expected the failing command to be named", err)
+	}
+	e.SetResult("gap_set_adv_data", 0)
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	device.Timeout = 50 * time.Millisecond
+	e.Ignore("gap_set_mode", true)
+	if err := device.StopAdvertising(); !errors.Is(err, ble112.ErrCommandTimeout) {
+		t.Errorf("got %v; expected a timeout", err)
+	}
+	e.Ignore("gap_set_mode", false)
+	if err := device.StopAdvertising(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewDeviceSilent(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	e.Ignore("system_address_get", true)
+	_, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+	if !errors.Is(err, ble112.ErrCommandTimeout) {
+		t.Errorf("got %v; expected a timeout", err)
+	}
+}
+
+func TestDeviceScanOptions(t *testing.T) {
+	name := []byte{0x07, 0x09, 'b', 'e', 'a', 'c', 'o', 'n'}
+	e := newEmulator(emulator.Config{Beacons: []emulator.Beacon{{
+		Address:      beaconAddr,
+		Data:         emulator.MfgData(0x0118, altBeaconAd),
+		ScanResponse: name,
+	}}})
+	device := newDevice(t, e)
+	device.ScanOptions = ble112.ScanOptions{
+		Interval: 100 * time.Millisecond,
+		Window:   50 * time.Millisecond,
+		Active:   true,
+	}
+
+	types := make(map[uint8]int)
+	for _, scan := range scan(t, device, 6) {
+		types[scan.AdvType]++
+		if scan.AdvType != ble112.PacketScanResponse {
+			continue
+		}
+		// a scan response with no mfg or service data has no beacon
+		// data, but its AD structures are in Raw
+		if len(scan.Bytes) != 0 {
+			t.Errorf("got scan response bytes %x; expected none", scan.Bytes)
+		}
+		if !bytes.HasSuffix(*scan.Raw, name) {
+			t.Errorf("got raw scan response %x; expected it to end with %x", *scan.Raw, name)
+		}
+	}
+	if types[ble112.PacketConnectable] == 0 || types[ble112.PacketScanResponse] == 0 {
+		t.Errorf("expected advertisements and scan responses, got %v", types)
+	}
+	if got := e.ScanParams(); !bytes.Equal(got, []byte{160, 0, 80, 0, 1}) {
+		t.Errorf("got scan parameters %v", got)
+	}
+
+	device.ScanOptions = ble112.ScanOptions{Interval: 10 * time.Millisecond, Window: 20 * time.Millisecond}
+	if err := device.StartScan(); err == nil {
+		t.Error("expected a window longer than the interval to be rejected")
+	}
+}
+
+func TestDeviceAdvertiseClone(t *testing.T) {
+	// a packet with a name between the flags and the beacon
+	mfg := emulator.MfgData(0x004c, altBeaconAd)
+	name := []byte{0x05, 0x09, 't', 'e', 's', 't'}
+	packet := append(append(append([]byte(nil), mfg[:3]...), name...), mfg[3:]...)
+	e := newEmulator(emulator.Config{Beacons: []emulator.Beacon{{Address: beaconAddr, Data: packet, RSSI: -60}}})
+	device := newDevice(t, e)
+
+	scanned := scan(t, device, 1)[0]
+	p, err := advertiser.CloneScan(scanned, beacon.DefaultParsers(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.Advertise(context.Background(), p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the beacon's AD structure is cloned exactly, but not the name
+	if got := e.AdvData(); !bytes.Equal(got, mfg) {
+		t.Errorf("got %x; expected %x", got, mfg)
+	}
+}
@@ -0,0 +1,261 @@
+package ble112
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The BLE112's flash, and the part of it which DFU rewrites. The USB DFU
+// bootloader lives below DFUStart and is never replaced.
+const (
+	FlashSize = 0x20000
+	DFUStart  = 0x1000
+)
+
+// DFUBlockSize is the most data sent in a single dfu_flash_upload.
+const DFUBlockSize = 64
+
+// ErrFirmwareMismatch is returned by UpdateFirmware when the BLE112 boots
+// reporting a firmware version other than the image's.
+var ErrFirmwareMismatch = errors.New("ble112: firmware version differs")
+
+// A Segment is a contiguous run of firmware bytes starting at a flash
+// address.
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// An Image is a firmware image, as built by bgbuild.
+type Image struct {
+	Segments []Segment // in address order, not overlapping
+	// Version, if set, is the version the firmware reports once booted,
+	// as Info.String formats it. Image files do not record it.
+	Version string
+}
+
+// Size returns the number of bytes in the image.
+func (img *Image) Size() int {
+	n := 0
+	for _, s := range img.Segments {
+		n += len(s.Data)
+	}
+	return n
+}
+
+// Checksum returns the CRC-32 of the image's bytes, in address order, for
+// telling images apart, such as in logs. It is not verified against the
+// BLE112.
+func (img *Image) Checksum() uint32 {
+	h := crc32.NewIEEE()
+	for _, s := range img.Segments {
+		h.Write(s.Data)
+	}
+	return h.Sum32()
+}
+
+// dfuSegments returns the segments of the image which DFU writes: those
+// above the bootloader.
+func (img *Image) dfuSegments() []Segment {
+	var segments []Segment
+	for _, s := range img.Segments {
+		if end := s.Address + uint32(len(s.Data)); end <= DFUStart {
+			continue
+		} else if s.Address < DFUStart {
+			s = Segment{DFUStart, s.Data[DFUStart-s.Address:]}
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
+
+func (img *Image) validate() error {
+	var end uint32
+	for i, s := range img.Segments {
+		if i > 0 && s.Address < end {
+			return fmt.Errorf("ble112: image segment at %#x overlaps the one before", s.Address)
+		}
+		end = s.Address + uint32(len(s.Data))
+		if end > FlashSize {
+			return fmt.Errorf("ble112: image extends to %#x, past the end of flash at %#x", end, FlashSize)
+		}
+	}
+	if len(img.dfuSegments()) == 0 {
+		return fmt.Errorf("ble112: image has nothing above the bootloader")
+	}
+	return nil
+}
+
+// ParseHex parses an Intel HEX firmware image, verifying the checksum of
+// each record.
+func ParseHex(r io.Reader) (*Image, error) {
+	memory := make(map[uint32]byte)
+	var base uint32
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, fmt.Errorf("ble112: hex line %d: missing ':'", line)
+		}
+		record, err := hex.DecodeString(text[1:])
+		if err != nil || len(record) < 5 || len(record) != 5+int(record[0]) {
+			return nil, fmt.Errorf("ble112: hex line %d: malformed record", line)
+		}
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("ble112: hex line %d: checksum mismatch", line)
+		}
+		offset := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case 0x00: // data
+			for i, b := range data {
+				memory[base+offset+uint32(i)] = b
+			}
+		case 0x01: // end of file
+			return newImage(memory), nil
+		case 0x02: // extended segment address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("ble112: hex line %d: malformed record", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case 0x04: // extended linear address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("ble112: hex line %d: malformed record", line)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case 0x03, 0x05: // start addresses, which DFU does not use
+		default:
+			return nil, fmt.Errorf("ble112: hex line %d: unknown record type %#02x", line, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("ble112: hex file has no end of file record")
+}
+
+// newImage gathers bytes by address into segments.
+func newImage(memory map[uint32]byte) *Image {
+	addresses := make([]int, 0, len(memory))
+	for a := range memory {
+		addresses = append(addresses, int(a))
+	}
+	sort.Ints(addresses)
+	img := &Image{}
+	for i, a := range addresses {
+		if i == 0 || a != addresses[i-1]+1 {
+			img.Segments = append(img.Segments, Segment{Address: uint32(a)})
+		}
+		s := &img.Segments[len(img.Segments)-1]
+		s.Data = append(s.Data, memory[uint32(a)])
+	}
+	return img
+}
+
+// ParseBin returns a raw binary firmware image, which starts at the given
+// flash address.
+func ParseBin(data []byte, address uint32) *Image {
+	return &Image{Segments: []Segment{{address, append([]byte(nil), data...)}}}
+}
+
+// LoadImage reads a firmware image from a .hex file, or from a raw .bin
+// file holding the whole of flash from address zero.
+func LoadImage(path string) (*Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".hex") {
+		return ParseHex(bytes.NewReader(data))
+	}
+	return ParseBin(data, 0), nil
+}
+
+// DFUProgress is called as a firmware update proceeds, with the number of
+// bytes written so far and the total to write.
+type DFUProgress func(written int, total int)
+
+// UpdateFirmware rewrites the BLE112's firmware with img over BGAPI DFU.
+// It restarts the BLE112 into its bootloader with system_reset, uploads
+// the image above the bootloader, then restarts it into the new firmware
+// and reads its version into Info. progress, if set, is called after each
+// block.
+//
+// The image is checked in two places: ParseHex verifies the checksum of
+// each Intel HEX record as the image is loaded, and, if img.Version is
+// set, the update fails with ErrFirmwareMismatch unless the new firmware
+// reports that version. The flash written is not read back, and nothing
+// compares it with Checksum. A BLE112 left in its bootloader by a failed
+// update answers only DFU commands until it is power cycled.
+func (device *Device) UpdateFirmware(img *Image, progress DFUProgress) error {
+	if err := img.validate(); err != nil {
+		return err
+	}
+	segments := img.dfuSegments()
+	total := 0
+	for _, s := range segments {
+		total += len(s.Data)
+	}
+
+	err := device.reboot("system_reset", BG_MSG_CLASS_SYSTEM, BG_RESET, []byte{1}, BG_MSG_CLASS_DFU, DefaultBootTimeout)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for _, s := range segments {
+		a := s.Address
+		address := []byte{byte(a), byte(a >> 8), byte(a >> 16), byte(a >> 24)}
+		if _, err := device.SendCommand(BG_MSG_CLASS_DFU, BG_DFU_SET_ADDRESS, address); err != nil {
+			return err
+		}
+		for data := s.Data; len(data) > 0; {
+			n := len(data)
+			if n > DFUBlockSize {
+				n = DFUBlockSize
+			}
+			block := append([]byte{byte(n)}, data[:n]...)
+			if _, err := device.SendCommand(BG_MSG_CLASS_DFU, BG_DFU_UPLOAD, block); err != nil {
+				return err
+			}
+			data = data[n:]
+			written += n
+			if progress != nil {
+				progress(written, total)
+			}
+		}
+	}
+	if _, err := device.SendCommand(BG_MSG_CLASS_DFU, BG_DFU_UPLOAD_FINISH, NULL_DATA); err != nil {
+		return err
+	}
+
+	// restart into the new firmware, which has to be given time to
+	// initialize on first boot
+	err = device.reboot("dfu_reset", BG_MSG_CLASS_DFU, BG_DFU_RESET, []byte{0}, BG_MSG_CLASS_SYSTEM, 2*DefaultBootTimeout)
+	if err != nil {
+		return err
+	}
+	info, err := device.GetInfo()
+	if err != nil {
+		return err
+	}
+	if img.Version != "" && info.String() != img.Version {
+		return fmt.Errorf("%w: booted %v; expected %v", ErrFirmwareMismatch, info, img.Version)
+	}
+	return device.restore()
+}
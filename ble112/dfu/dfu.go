@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RadiusNetworks/go-beacon/ble112"
+)
+
+func main() {
+	port := flag.String("port", "", "serial port of the BLE112 (default: the first found)")
+	version := flag.String("version", "", "firmware version the image reports, such as 1.3.2-122, checked once it boots")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-port port] [-version version] firmware.hex|firmware.bin\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	img, err := ble112.LoadImage(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	img.Version = *version
+	var device *ble112.Device
+	if *port != "" {
+		device, err = ble112.NewDevice(*port)
+		if err != nil {
+			fail(err)
+		}
+	} else {
+		devices := ble112.Devices()
+		if len(devices) == 0 {
+			fail(fmt.Errorf("No BLE112 devices found!"))
+		}
+		device = devices[0]
+		for _, other := range devices[1:] {
+			other.Close()
+		}
+	}
+	defer device.Close()
+
+	fmt.Printf("Updating %v from firmware %v with %v (%d bytes)\n",
+		device, device.DeviceVersion(), flag.Arg(0), img.Size())
+	err = device.UpdateFirmware(img, func(written int, total int) {
+		fmt.Printf("\r%3d%% %d/%d bytes", written*100/total, written, total)
+	})
+	fmt.Println()
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Updated to firmware %v (image crc32 %08x)\n", device.DeviceVersion(), img.Checksum())
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
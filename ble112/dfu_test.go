@@ -0,0 +1,120 @@
+package ble112_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+// firmwareHex has 16 bytes in the bootloader, 20 bytes at 0x1000 and 4
+// bytes at 0x10010, above an extended linear address record.
+const firmwareHex = `:10000000000102030405060708090A0B0C0D0E0F78
+:10100000101112131415161718191A1B1C1D1E1F68
+:041010002021222356
+:020000040001F9
+:040010003031323326
+:00000001FF
+`
+
+func TestParseHex(t *testing.T) {
+	img, err := ble112.ParseHex(strings.NewReader(firmwareHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(img.Segments) != 3 || img.Size() != 40 {
+		t.Fatalf("got %+v; expected 3 segments of 40 bytes", img.Segments)
+	}
+	if s := img.Segments[1]; s.Address != 0x1000 || len(s.Data) != 20 || s.Data[19] != 0x23 {
+		t.Errorf("got %#x %x; expected 20 bytes at 0x1000", s.Address, s.Data)
+	}
+	if s := img.Segments[2]; s.Address != 0x10010 || !bytes.Equal(s.Data, []byte("0123")) {
+		t.Errorf("got %#x %x; expected the extended address applied", s.Address, s.Data)
+	}
+
+	for _, bad := range []string{
+		strings.Replace(firmwareHex, "0E0F78", "0E0F79", 1), // checksum
+		strings.Replace(firmwareHex, ":00000001FF\n", "", 1),
+		"10000000000102030405060708090A0B0C0D0E0F78\n",
+	} {
+		if _, err := ble112.ParseHex(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestDeviceUpdateFirmware(t *testing.T) {
+	for _, disconnects := range []bool{false, true} {
+		e := newEmulator(emulator.Config{ResetDisconnects: disconnects})
+		device := newDevice(t, e)
+		if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		img := ble112.ParseBin(bytes.Repeat([]byte{0x5a}, 0x1100), 0)
+		var progress []int
+		err := device.UpdateFirmware(img, func(written int, total int) {
+			if total != 0x100 {
+				t.Errorf("got total %v; expected only the bytes above the bootloader", total)
+			}
+			progress = append(progress, written)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(progress) != 0x100/ble112.DFUBlockSize || progress[len(progress)-1] != 0x100 {
+			t.Errorf("got progress %v; expected one call per block", progress)
+		}
+		if got := e.Flash(0, 2); !bytes.Equal(got, []byte{0xff, 0xff}) {
+			t.Errorf("got %x; expected the bootloader untouched", got)
+		}
+		if got := e.Flash(ble112.DFUStart, 0x101); !bytes.Equal(got, append(bytes.Repeat([]byte{0x5a}, 0x100), 0xff)) {
+			t.Errorf("got %x; expected the image written above the bootloader", got)
+		}
+		if e.InDFU() {
+			t.Error("still in DFU mode after the update")
+		}
+		if got, expected := e.AdvData(), emulator.MfgData(0x0118, altBeaconAd); !bytes.Equal(got, expected) {
+			t.Errorf("got adv data %x; expected the advertisement restored", got)
+		}
+	}
+}
+
+func TestDeviceUpdateFirmwareVersion(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	img := ble112.ParseBin(bytes.Repeat([]byte{0x5a}, 0x100), ble112.DFUStart)
+	img.Version = emulator.DefaultInfo.String()
+	if err := device.UpdateFirmware(img, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the emulator boots the version it was configured with, whatever is
+	// written, as a BLE112 whose update did not take would
+	img.Version = "1.4.0-143"
+	if err := device.UpdateFirmware(img, nil); !errors.Is(err, ble112.ErrFirmwareMismatch) {
+		t.Errorf("got %v; expected the booted version found to differ", err)
+	}
+}
+
+func TestDeviceUpdateFirmwareErrors(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+	commands := len(e.Commands())
+
+	for _, img := range []*ble112.Image{
+		ble112.ParseBin(make([]byte, 16), 0),                  // bootloader only
+		ble112.ParseBin(make([]byte, 16), ble112.FlashSize-8), // past the end of flash
+	} {
+		if err := device.UpdateFirmware(img, nil); err == nil {
+			t.Errorf("expected %+v to be rejected", img.Segments[0].Address)
+		}
+	}
+	if len(e.Commands()) != commands {
+		t.Errorf("got commands %v; expected bad images not to reach the BLE112", e.Commands())
+	}
+}
@@ -0,0 +1,22 @@
+package emulator
+
+// MfgData returns advertising data with flags and the manufacturer data
+// in ad, as generated by beacon.Parser.GenerateAd, under the given
+// company id. It is framed the same way as ble112.Device.AdvertiseMfgData.
+func MfgData(id uint16, ad []byte) []byte {
+	data := []byte{0x02, 0x01, 0x06, byte(len(ad) + 1), 0xff, byte(id), byte(id >> 8)}
+	return append(data, ad[2:]...)
+}
+
+// ServiceData returns advertising data with flags and the service data in
+// ad, as generated by beacon.Parser.GenerateAd, under the given 16-bit
+// service uuid. It is framed the same way as
+// ble112.Device.AdvertiseServiceData.
+func ServiceData(id uint16, ad []byte) []byte {
+	data := []byte{
+		0x02, 0x01, 0x06,
+		0x03, 0x03, byte(id), byte(id >> 8),
+		byte(len(ad) + 1), 0x16, byte(id), byte(id >> 8),
+	}
+	return append(data, ad[2:]...)
+}
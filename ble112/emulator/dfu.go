@@ -0,0 +1,55 @@
+package emulator
+
+import (
+	"encoding/binary"
+
+	"github.com/RadiusNetworks/go-beacon/ble112"
+)
+
+// dfuCommand handles the DFU commands. Only dfu_reset is understood
+// outside the bootloader.
+func (e *Emulator) dfuCommand(id byte, payload []byte) ([]byte, bool) {
+	if id == ble112.BG_DFU_RESET {
+		e.reset()
+		e.booting = true
+		e.dfu = payload[0] == 1
+		return nil, false
+	}
+	if !e.dfu {
+		return nil, false
+	}
+	switch id {
+	case ble112.BG_DFU_SET_ADDRESS:
+		address := binary.LittleEndian.Uint32(payload)
+		if address < ble112.DFUStart || address >= ble112.FlashSize {
+			return result(uint16(ble112.ErrInvalidParameter)), true
+		}
+		e.dfuAddress = address
+	case ble112.BG_DFU_UPLOAD:
+		data := array(payload, 0)
+		if int(e.dfuAddress)+len(data) > len(e.flash) {
+			return result(uint16(ble112.ErrInvalidParameter)), true
+		}
+		copy(e.flash[e.dfuAddress:], data)
+		e.dfuAddress += uint32(len(data))
+	case ble112.BG_DFU_UPLOAD_FINISH:
+	default:
+		return nil, false
+	}
+	return result(0), true
+}
+
+// InDFU reports whether the emulator is running its DFU bootloader.
+func (e *Emulator) InDFU() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dfu
+}
+
+// Flash returns n bytes of the emulator's flash, starting at address.
+// Flash is erased, to 0xff, until written by DFU.
+func (e *Emulator) Flash(address uint32, n int) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.flash[address:int(address)+n]...)
+}
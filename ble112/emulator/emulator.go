@@ -0,0 +1,712 @@
+// Package emulator provides a BLE112 emulator which speaks BGAPI over an
+// in-memory pipe or a pseudo-terminal, so that the ble112 package and
+// beacon.Scanner can be tested without hardware.
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+)
+
+// DefaultInfo is the system_get_info response of firmware 1.3.2 build 122
+// on a BLE112.
+var DefaultInfo = ble112.Info{Major: 1, Minor: 3, Patch: 2, Build: 122, LLVersion: 6, Protocol: 1, Hardware: 1}
+
+// DefaultAddress is the mac address reported by an emulator when none is
+// configured.
+var DefaultAddress = beacon.MacAddress{0x01, 0x00, 0x00, 0x80, 0x07, 0x00}
+
+// A Beacon is an advertiser that the emulator reports while discovering.
+type Beacon struct {
+	Address     beacon.MacAddress
+	AddressType uint8
+	PacketType  uint8
+	// Data is the advertising data, made up of AD structures.
+	Data []byte
+	// ScanResponse is reported, when scanning actively, as a separate
+	// scan response packet.
+	ScanResponse []byte
+	RSSI         int8
+}
+
+// Config describes the emulated BLE112 and its surroundings.
+type Config struct {
+	// Address is the BLE112's mac address. DefaultAddress is used if it
+	// is zero.
+	Address beacon.MacAddress
+	// Info is the BLE112's firmware and hardware versions. DefaultInfo is
+	// used if it is zero.
+	Info ble112.Info
+	// Beacons are reported once per ScanInterval while discovering.
+	Beacons []Beacon
+	// ScanInterval defaults to 10ms.
+	ScanInterval time.Duration
+	// RSSINoise is the largest random deviation added to a beacon's RSSI.
+	RSSINoise int8
+	// CorruptionRate is the probability that a byte is dropped from an
+	// event before it is sent, as happens on a lossy serial line.
+	CorruptionRate float64
+	// Latency delays every command response.
+	Latency time.Duration
+	// Seed seeds the random source for noise and corruption.
+	Seed int64
+	// ResetDisconnects makes system_reset close the connection, as a
+	// BLE112 on USB drops off the bus when it restarts, rather than
+	// sending system_boot.
+	ResetDisconnects bool
+	// Peripherals are the devices the BLE112 can connect to.
+	Peripherals []Peripheral
+	// Standalone emulates custom firmware which, at boot, advertises the
+	// beacon provisioned in the persistent store by
+	// ble112.Device.Provision. The stock firmware does not.
+	Standalone bool
+}
+
+// An Emulator answers BGAPI commands like a BLE112 and injects
+// gap_scan_response events for its configured beacons while discovering.
+// Its state persists across connections, as a dongle's would.
+type Emulator struct {
+	cfg Config
+
+	mu           sync.Mutex
+	rand         *rand.Rand
+	commands     []string
+	discovering  bool
+	discoverMode byte
+	scanParams   []byte
+	filtering    []byte
+	reported     map[int]bool
+	advParams    []byte
+	advData      []byte
+	address      *beacon.MacAddress // set by gap_set_nonresolvable_address
+	txPower      int
+	stalled      bool
+	whitelist    map[ble112.WhitelistEntry]bool
+	counters     ble112.Counters
+	peripherals  []*database
+	ps           map[uint16][]byte // the persistent store, kept across resets
+	connecting   bool
+	events       [][]byte // sent after the current command's response
+	booting      bool     // the current command restarted the BLE112
+	dfu          bool     // running the DFU bootloader
+	flash        []byte
+	dfuAddress   uint32
+	mode         [2]byte
+	sessions     map[*session]bool
+	results      map[string]ble112.Result
+	ignored      map[string]bool
+	held         []heldCommand // ignored commands, answered once no longer
+}
+
+// A heldCommand is a command the emulator ignored, which it answers late
+// over the session it arrived on.
+type heldCommand struct {
+	s       *session
+	class   byte
+	id      byte
+	payload []byte
+}
+
+// New returns an Emulator with the given configuration.
+func New(cfg Config) *Emulator {
+	if cfg.Address == (beacon.MacAddress{}) {
+		cfg.Address = DefaultAddress
+	}
+	if cfg.Info == (ble112.Info{}) {
+		cfg.Info = DefaultInfo
+	}
+	if cfg.ScanInterval == 0 {
+		cfg.ScanInterval = 10 * time.Millisecond
+	}
+	e := &Emulator{
+		cfg:       cfg,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		sessions:  make(map[*session]bool),
+		results:   make(map[string]ble112.Result),
+		ignored:   make(map[string]bool),
+		txPower:   -1,
+		whitelist: make(map[ble112.WhitelistEntry]bool),
+		ps:        make(map[uint16][]byte),
+		flash:     bytes.Repeat([]byte{0xff}, ble112.FlashSize),
+	}
+	for i := range cfg.Peripherals {
+		e.peripherals = append(e.peripherals, newDatabase(&cfg.Peripherals[i]))
+	}
+	return e
+}
+
+// Conn returns the host side of a new in-memory connection to the
+// emulator.
+func (e *Emulator) Conn() io.ReadWriteCloser {
+	host, dongle := net.Pipe()
+	go e.Serve(dongle)
+	return host
+}
+
+// Opener returns a ble112.PortOpener which connects to the emulator
+// regardless of the port it is given.
+func (e *Emulator) Opener() ble112.PortOpener {
+	return func(string) (io.ReadWriteCloser, error) {
+		return e.Conn(), nil
+	}
+}
+
+// Serve speaks BGAPI over rwc until it is closed or returns an error.
+func (e *Emulator) Serve(rwc io.ReadWriteCloser) error {
+	s := &session{e: e, rwc: rwc, done: make(chan struct{})}
+	e.mu.Lock()
+	e.sessions[s] = true
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.sessions, s)
+		e.mu.Unlock()
+		close(s.done)
+		rwc.Close()
+	}()
+
+	go s.discover()
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(rwc, header); err != nil {
+			return err
+		}
+		payload := make([]byte, int(header[0]&0x07)<<8|int(header[1]))
+		if _, err := io.ReadFull(rwc, payload); err != nil {
+			return err
+		}
+		if err := s.handle(header[2], header[3], payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes every connection to the emulator.
+func (e *Emulator) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for s := range e.sessions {
+		s.rwc.Close()
+	}
+	return nil
+}
+
+// Unplug closes every connection to the emulator and resets the radio,
+// as unplugging a dongle does. The emulator can be connected to again
+// afterwards, as if plugged back in.
+func (e *Emulator) Unplug() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for s := range e.sessions {
+		s.rwc.Close()
+	}
+	e.reset()
+}
+
+// reset returns the radio to its state at power on.
+func (e *Emulator) reset() {
+	e.discovering = false
+	e.stalled = false
+	e.mode = [2]byte{}
+	e.advData = nil
+	e.address = nil
+	e.advParams = nil
+	e.scanParams = nil
+	e.filtering = nil
+	e.txPower = -1
+	e.whitelist = make(map[ble112.WhitelistEntry]bool)
+	e.connecting = false
+	e.dfu = false
+	e.held = nil // a restarted BLE112 answers nothing sent before
+	for _, db := range e.peripherals {
+		db.connected = false
+	}
+	if e.cfg.Standalone {
+		e.advertiseProvisioned()
+	}
+}
+
+// Whitelist returns the entries in the emulator's whitelist.
+func (e *Emulator) Whitelist() []ble112.WhitelistEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var entries []ble112.WhitelistEntry
+	for entry := range e.whitelist {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Stall makes the emulator stop reporting beacons while still answering
+// commands, as hung BLE112s do, until it is reset.
+func (e *Emulator) Stall() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stalled = true
+}
+
+// Commands returns the names of the commands received so far, in order.
+func (e *Emulator) Commands() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.commands...)
+}
+
+// Discovering reports whether the emulator is running gap_discover.
+func (e *Emulator) Discovering() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.discovering
+}
+
+// RandomAddress returns the address last set with
+// gap_set_nonresolvable_address, and false if none has been set since the
+// last reset.
+func (e *Emulator) RandomAddress() (beacon.MacAddress, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.address == nil {
+		return beacon.MacAddress{}, false
+	}
+	return *e.address, true
+}
+
+// AdvData returns the advertising data last set with gap_set_adv_data.
+func (e *Emulator) AdvData() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.advData...)
+}
+
+// AdvParams returns the parameters last set with gap_set_adv_parameters.
+func (e *Emulator) AdvParams() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.advParams...)
+}
+
+// TxPower returns the power level last set with hardware_set_txpower, or
+// false if it has not been set.
+func (e *Emulator) TxPower() (level byte, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return byte(e.txPower), e.txPower >= 0
+}
+
+// ScanParams returns the parameters last set with gap_set_scan_parameters.
+func (e *Emulator) ScanParams() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.scanParams...)
+}
+
+// Filtering returns the parameters last set with gap_set_filtering.
+func (e *Emulator) Filtering() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.filtering...)
+}
+
+// Mode returns the discoverable and connectable modes last set with
+// gap_set_mode.
+func (e *Emulator) Mode() (discoverable byte, connectable byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mode[0], e.mode[1]
+}
+
+// SetResult makes the emulator fail the named command, such as
+// "gap_set_mode", with the given result code instead of carrying it out.
+// A zero result restores normal behavior.
+func (e *Emulator) SetResult(command string, result ble112.Result) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if result == 0 {
+		delete(e.results, command)
+	} else {
+		e.results[command] = result
+	}
+}
+
+// Ignore makes the emulator leave the named command unanswered, as a
+// BLE112 too busy to respond would. If ignore is false, the commands left
+// unanswered are carried out, and answered late, before Ignore returns.
+func (e *Emulator) Ignore(command string, ignore bool) {
+	e.mu.Lock()
+	e.ignored[command] = ignore
+	var held []heldCommand
+	if !ignore {
+		rest := e.held[:0]
+		for _, h := range e.held {
+			if ble112.CommandName(h.class, h.id) == command {
+				held = append(held, h)
+			} else {
+				rest = append(rest, h)
+			}
+		}
+		e.held = rest
+	}
+	e.mu.Unlock()
+	for _, h := range held {
+		h.s.answer(h.class, h.id, h.payload)
+	}
+}
+
+// Inject sends an event to every connected host.
+func (e *Emulator) Inject(class byte, id byte, payload []byte) {
+	e.mu.Lock()
+	sessions := make([]*session, 0, len(e.sessions))
+	for s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	e.mu.Unlock()
+	for _, s := range sessions {
+		s.write(Frame(true, class, id, payload))
+	}
+}
+
+// Frame encodes a BGAPI frame.
+func Frame(event bool, class byte, id byte, payload []byte) []byte {
+	header := []byte{byte(len(payload)>>8) & 0x07, byte(len(payload)), class, id}
+	if event {
+		header[0] |= ble112.BG_EVENT
+	}
+	return append(header, payload...)
+}
+
+// ScanResponse encodes the payload of a gap_scan_response event.
+func ScanResponse(b Beacon) []byte {
+	payload := []byte{byte(b.RSSI), b.PacketType}
+	payload = append(payload, b.Address[:]...)
+	payload = append(payload, b.AddressType, 0xff, byte(len(b.Data)))
+	return append(payload, b.Data...)
+}
+
+// result encodes a 16-bit BGAPI result code.
+func result(code uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, code)
+	return b
+}
+
+// failure encodes the response to a command which failed with result r.
+// Most responses are just the result; the rest are padded to length.
+func failure(class byte, id byte, r ble112.Result) []byte {
+	switch class {
+	case ble112.BG_MSG_CLASS_SYSTEM, 1: // system, flash
+		if id == 13 || (class == 1 && id == 4) { // endpoint_rx, ps_load
+			return append(result(uint16(r)), 0)
+		}
+	case ble112.BG_MSG_CLASS_CONNECTION, 4: // connection, attclient
+		return append([]byte{0}, result(uint16(r))...)
+	case ble112.BG_MSG_CLASS_GAP:
+		if id == 3 || id == 5 { // gap_connect_direct, gap_connect_selective
+			return append(result(uint16(r)), 0)
+		}
+	}
+	return result(uint16(r))
+}
+
+type session struct {
+	e    *Emulator
+	rwc  io.ReadWriteCloser
+	done chan struct{}
+	wmu  sync.Mutex
+}
+
+func (s *session) write(frame []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.rwc.Write(frame)
+	return err
+}
+
+func (s *session) respond(class byte, id byte, payload []byte) error {
+	if s.e.cfg.Latency > 0 {
+		time.Sleep(s.e.cfg.Latency)
+	}
+	return s.write(Frame(false, class, id, payload))
+}
+
+func (s *session) handle(class byte, id byte, payload []byte) error {
+	if s.e.hold(s, class, id, payload) {
+		return nil
+	}
+	return s.answer(class, id, payload)
+}
+
+// answer carries out a command and sends its response and events.
+func (s *session) answer(class byte, id byte, payload []byte) error {
+	response, ok := s.e.process(class, id, payload)
+	if s.e.takeBooting() {
+		return s.boot()
+	}
+	if ok {
+		if err := s.respond(class, id, response); err != nil {
+			return err
+		}
+	}
+	for _, event := range s.e.takeEvents() {
+		if err := s.write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boot finishes a system_reset or dfu_reset, either by sending
+// system_boot, or dfu_boot from the bootloader, or, if configured, by
+// dropping the connection.
+func (s *session) boot() error {
+	if s.e.cfg.ResetDisconnects {
+		return io.EOF
+	}
+	if s.e.cfg.Latency > 0 {
+		time.Sleep(s.e.cfg.Latency)
+	}
+	if s.e.InDFU() {
+		return s.write(Frame(true, ble112.BG_MSG_CLASS_DFU, 0, []byte{1, 0, 0, 0}))
+	}
+	return s.write(Frame(true, ble112.BG_MSG_CLASS_SYSTEM, 0, s.e.info()))
+}
+
+// takeBooting reports whether the command just processed restarted the
+// BLE112.
+func (e *Emulator) takeBooting() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	booting := e.booting
+	e.booting = false
+	return booting
+}
+
+// hold records a command received over s, and holds it back if it is
+// ignored.
+func (e *Emulator) hold(s *session, class byte, id byte, payload []byte) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name := ble112.CommandName(class, id)
+	e.commands = append(e.commands, name)
+	if !e.ignored[name] {
+		return false
+	}
+	e.held = append(e.held, heldCommand{s, class, id, payload})
+	return true
+}
+
+// info encodes the emulator's Info as system_get_info and system_boot do.
+func (e *Emulator) info() []byte {
+	i := e.cfg.Info
+	b := make([]byte, 12)
+	for j, v := range []uint16{i.Major, i.Minor, i.Patch, i.Build, i.LLVersion} {
+		binary.LittleEndian.PutUint16(b[2*j:], v)
+	}
+	b[10], b[11] = i.Protocol, i.Hardware
+	return b
+}
+
+// process applies a command to the emulator's state and returns its
+// response payload, or false if the command has no response.
+func (e *Emulator) process(class byte, id byte, payload []byte) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name := ble112.CommandName(class, id)
+	if r, ok := e.results[name]; ok {
+		return failure(class, id, r), true
+	}
+	// pad short payloads so malformed commands read as zeros
+	n := len(payload)
+	payload = append(payload, make([]byte, 8)...)
+	if class == ble112.BG_MSG_CLASS_DFU {
+		return e.dfuCommand(id, payload)
+	}
+	if e.dfu {
+		// the bootloader knows only the DFU commands
+		return nil, false
+	}
+
+	switch class {
+	case ble112.BG_MSG_CLASS_SYSTEM:
+		switch id {
+		case ble112.BG_RESET:
+			e.reset()
+			e.booting = true
+			e.dfu = payload[0] == 1
+			return nil, false
+		case ble112.BG_HELLO:
+			return nil, true
+		case ble112.BG_GET_ADDRESS:
+			return e.cfg.Address[:], true
+		case ble112.BG_GET_COUNTERS:
+			c := e.counters
+			e.counters = ble112.Counters{}
+			return []byte{c.TxOK, c.TxRetry, c.RxOK, c.RxFail, 8}, true
+		case ble112.BG_GET_INFO:
+			return e.info(), true
+		case ble112.BG_WHITELIST_APPEND, ble112.BG_WHITELIST_REMOVE, ble112.BG_WHITELIST_CLEAR:
+			if e.discovering || e.mode[0] != ble112.BG_GAP_NON_DISCOVERABLE {
+				if id == ble112.BG_WHITELIST_CLEAR {
+					// system_whitelist_clear has no result to report
+					return nil, true
+				}
+				return result(0x0181), true
+			}
+			var entry ble112.WhitelistEntry
+			copy(entry.Address[:], payload)
+			entry.AddressType = payload[6]
+			switch id {
+			case ble112.BG_WHITELIST_APPEND:
+				e.whitelist[entry] = true
+			case ble112.BG_WHITELIST_REMOVE:
+				delete(e.whitelist, entry)
+			default:
+				e.whitelist = make(map[ble112.WhitelistEntry]bool)
+				return nil, true
+			}
+			return result(0), true
+		}
+	case ble112.BG_MSG_CLASS_FLASH:
+		return e.psCommand(id, payload)
+	case ble112.BG_MSG_CLASS_CONNECTION:
+		switch id {
+		case ble112.BG_DISCONNECT:
+			db := e.connection(payload[0])
+			if db == nil {
+				return append([]byte{payload[0]}, result(0x0186)...), true
+			}
+			// disconnected by the local host
+			e.emit(ble112.BG_MSG_CLASS_CONNECTION, ble112.BG_CONNECTION_DISCONNECTED, e.disconnect(db, ble112.ErrLocalHostTerminated))
+			return append([]byte{payload[0]}, result(0)...), true
+		}
+	case ble112.BG_MSG_CLASS_ATTCLIENT:
+		return e.attclient(id, payload)
+	case ble112.BG_MSG_CLASS_GAP:
+		switch id {
+		case ble112.BG_SET_MODE:
+			copy(e.mode[:], payload)
+		case ble112.BG_DISCOVER:
+			e.discovering = true
+			e.discoverMode = payload[0]
+			e.reported = make(map[int]bool)
+		case ble112.BG_CONNECT_DIRECT:
+			return e.connect(payload), true
+		case ble112.BG_DISCOVER_STOP:
+			if e.connecting {
+				e.connecting = false
+				break
+			}
+			if !e.discovering {
+				return result(0x0181), true
+			}
+			e.discovering = false
+		case ble112.BG_SET_FILTERING:
+			e.filtering = append([]byte(nil), payload[:n]...)
+		case ble112.BG_SCAN_PARAMS:
+			e.scanParams = append([]byte(nil), payload[:n]...)
+		case ble112.BG_GAP_SET_ADV_PARAM:
+			e.advParams = append([]byte(nil), payload[:n]...)
+		case ble112.BG_GAP_SET_ADV_DATA:
+			if n < 2 || 2+int(payload[1]) > n {
+				return result(uint16(ble112.ErrInvalidParameter)), true
+			}
+			if payload[0] == 0 {
+				e.advData = append([]byte(nil), payload[2:2+payload[1]]...)
+			}
+		case ble112.BG_SET_NONRESOLVABLE:
+			var addr beacon.MacAddress
+			copy(addr[:], payload)
+			e.address = &addr
+		default:
+			return nil, false
+		}
+		return result(0), true
+	case ble112.BG_MSG_CLASS_HARDWARE:
+		switch id {
+		case ble112.BG_SET_TXPOWER:
+			e.txPower = int(payload[0])
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// discover injects scan responses for each beacon while discovering.
+func (s *session) discover() {
+	e := s.e
+	ticker := time.NewTicker(e.cfg.ScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		for _, frame := range e.scanFrames() {
+			if err := s.write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// scanFrames returns the gap_scan_response events to report this scan
+// interval, with noise and corruption applied.
+func (e *Emulator) scanFrames() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.discovering || e.stalled {
+		return nil
+	}
+	active := len(e.scanParams) == 5 && e.scanParams[4] == 1
+	whitelisted := len(e.filtering) == 3 && e.filtering[0] == 1
+	filterDuplicates := len(e.filtering) == 3 && e.filtering[2] == 1
+
+	var frames [][]byte
+	for i, b := range e.cfg.Beacons {
+		e.counters.RxOK = increment(e.counters.RxOK)
+		if whitelisted && !e.whitelist[ble112.WhitelistEntry{Address: b.Address, AddressType: b.AddressType}] {
+			continue
+		}
+		if filterDuplicates && e.reported[i] {
+			continue
+		}
+		e.reported[i] = true
+		if e.cfg.RSSINoise > 0 {
+			n := int(e.cfg.RSSINoise)
+			b.RSSI += int8(e.rand.Intn(2*n+1) - n)
+		}
+		frames = append(frames, e.corrupt(Frame(true, ble112.BG_MSG_CLASS_GAP, 0, ScanResponse(b))))
+		if active && b.ScanResponse != nil {
+			b.PacketType, b.Data = ble112.PacketScanResponse, b.ScanResponse
+			e.counters.TxOK = increment(e.counters.TxOK) // scan request
+			e.counters.RxOK = increment(e.counters.RxOK)
+			frames = append(frames, e.corrupt(Frame(true, ble112.BG_MSG_CLASS_GAP, 0, ScanResponse(b))))
+		}
+	}
+	return frames
+}
+
+// increment adds one to a packet counter, saturating rather than wrapping.
+func increment(n uint8) uint8 {
+	if n == 255 {
+		return n
+	}
+	return n + 1
+}
+
+// corrupt drops a random byte from frame at the configured rate.
+func (e *Emulator) corrupt(frame []byte) []byte {
+	if e.cfg.CorruptionRate > 0 && e.rand.Float64() < e.cfg.CorruptionRate {
+		i := e.rand.Intn(len(frame))
+		frame = append(frame[:i:i], frame[i+1:]...)
+	}
+	return frame
+}
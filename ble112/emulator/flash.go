@@ -0,0 +1,75 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/RadiusNetworks/go-beacon/ble112"
+)
+
+// psCommand handles the persistent store commands.
+func (e *Emulator) psCommand(id byte, payload []byte) ([]byte, bool) {
+	key := binary.LittleEndian.Uint16(payload)
+	switch id {
+	case ble112.BG_PS_SAVE:
+		value := array(payload, 2)
+		if key < ble112.MinPSKey || key > ble112.MaxPSKey || len(value) > ble112.MaxPSValue {
+			return result(uint16(ble112.ErrInvalidParameter)), true
+		}
+		e.ps[key] = append([]byte(nil), value...)
+		return result(0), true
+	case ble112.BG_PS_LOAD:
+		value, ok := e.ps[key]
+		if !ok {
+			return failure(ble112.BG_MSG_CLASS_FLASH, id, ble112.ErrInvalidParameter), true
+		}
+		return append(append(result(0), byte(len(value))), value...), true
+	case ble112.BG_PS_ERASE:
+		delete(e.ps, key)
+		return nil, true
+	case ble112.BG_PS_DUMP:
+		keys := make([]int, 0, len(e.ps))
+		for k := range e.ps {
+			keys = append(keys, int(k))
+		}
+		sort.Ints(keys)
+		for _, k := range append(keys, 0xffff) {
+			value := e.ps[uint16(k)]
+			p := append([]byte{byte(k), byte(k >> 8), byte(len(value))}, value...)
+			e.emit(ble112.BG_MSG_CLASS_FLASH, ble112.BG_PS_KEY, p)
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// advertiseProvisioned does at boot what firmware reading the
+// provisioning schema would: advertise the beacon provisioned in the
+// persistent store, if any.
+func (e *Emulator) advertiseProvisioned() {
+	p, err := ble112.ParseProvisioning(e.ps)
+	if err != nil {
+		return
+	}
+	params := e.ps[ble112.PSKeyAdvParameters]
+	e.advParams = params[:5]
+	if params[5]&2 != 0 {
+		e.txPower = int(params[6])
+	}
+	e.advData = append([]byte{0x02, 0x01, 0x06}, p.Data...)
+	e.mode = [2]byte{ble112.BG_GAP_USER_DATA, ble112.BG_GAP_CONNECTABLE}
+	if p.Options.NonConnectable {
+		e.mode[1] = ble112.BG_GAP_NON_CONNECTABLE
+	}
+}
+
+// PS returns the contents of the emulator's persistent store.
+func (e *Emulator) PS() map[uint16][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ps := make(map[uint16][]byte, len(e.ps))
+	for k, v := range e.ps {
+		ps[k] = append([]byte(nil), v...)
+	}
+	return ps
+}
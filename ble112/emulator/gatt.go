@@ -0,0 +1,362 @@
+package emulator
+
+import (
+	"encoding/binary"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/gatt"
+)
+
+// A Peripheral is a connectable device, with a GATT database, which the
+// emulated BLE112 can connect to.
+type Peripheral struct {
+	Address     beacon.MacAddress
+	AddressType uint8
+	Services    []Service
+}
+
+// A Service is a primary service of a Peripheral.
+type Service struct {
+	UUID            gatt.UUID
+	Characteristics []Characteristic
+}
+
+// A Characteristic is a characteristic of a Service. Characteristics
+// which notify or indicate get a client characteristic configuration
+// descriptor.
+type Characteristic struct {
+	UUID       gatt.UUID
+	Properties gatt.Property
+	Value      []byte
+}
+
+// attribute is an entry in a peripheral's attribute table.
+type attribute struct {
+	typ   gatt.UUID
+	value []byte
+	end   uint16        // the last handle of a service
+	props gatt.Property // of a characteristic value
+	cccd  uint16        // of a characteristic value, or zero
+}
+
+// database is a peripheral's attribute table, indexed by handle-1, and
+// the state of the BLE112's connection to it.
+type database struct {
+	p          *Peripheral
+	attrs      []*attribute
+	connected  bool
+	connection byte
+}
+
+func newDatabase(p *Peripheral) *database {
+	db := &database{p: p}
+	for _, s := range p.Services {
+		service := db.add(&attribute{typ: gatt.PrimaryServiceUUID, value: s.UUID})
+		for _, c := range s.Characteristics {
+			h := uint16(len(db.attrs)) + 2
+			decl := []byte{byte(c.Properties), byte(h), byte(h >> 8)}
+			db.add(&attribute{typ: gatt.CharacteristicUUID, value: append(decl, c.UUID...)})
+			value := &attribute{typ: c.UUID, value: append([]byte(nil), c.Value...), props: c.Properties}
+			db.add(value)
+			if c.Properties&(gatt.PropNotify|gatt.PropIndicate) != 0 {
+				db.add(&attribute{typ: gatt.CCCDUUID, value: []byte{0, 0}})
+				value.cccd = uint16(len(db.attrs))
+			}
+		}
+		service.end = uint16(len(db.attrs))
+	}
+	return db
+}
+
+func (db *database) add(a *attribute) *attribute {
+	db.attrs = append(db.attrs, a)
+	return a
+}
+
+func (db *database) attribute(handle uint16) *attribute {
+	if handle == 0 || int(handle) > len(db.attrs) {
+		return nil
+	}
+	return db.attrs[handle-1]
+}
+
+// characteristic returns the value handle of the characteristic with the
+// given uuid.
+func (db *database) characteristic(uuid gatt.UUID) uint16 {
+	for i, a := range db.attrs {
+		if a.typ.Equal(uuid) && i > 0 && db.attrs[i-1].typ.Equal(gatt.CharacteristicUUID) {
+			return uint16(i + 1)
+		}
+	}
+	return 0
+}
+
+// peripheral returns the database of the peripheral with the given
+// address, or nil.
+func (e *Emulator) peripheral(addr beacon.MacAddress) *database {
+	for _, db := range e.peripherals {
+		if db.p.Address == addr {
+			return db
+		}
+	}
+	return nil
+}
+
+// connection returns the database of the peripheral connected with the
+// given connection handle, or nil.
+func (e *Emulator) connection(handle byte) *database {
+	for _, db := range e.peripherals {
+		if db.connected && db.connection == handle {
+			return db
+		}
+	}
+	return nil
+}
+
+// emit queues an event to be sent after the response to the command being
+// processed.
+func (e *Emulator) emit(class byte, id byte, payload []byte) {
+	e.events = append(e.events, Frame(true, class, id, payload))
+}
+
+// takeEvents returns and clears the queued events.
+func (e *Emulator) takeEvents() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := e.events
+	e.events = nil
+	return events
+}
+
+// connect handles gap_connect_direct. The connection completes at once if
+// a peripheral has the address, and otherwise never does.
+func (e *Emulator) connect(payload []byte) []byte {
+	if e.discovering || e.connecting {
+		return append(result(uint16(ble112.ErrDeviceInWrongState)), 0)
+	}
+	var addr beacon.MacAddress
+	copy(addr[:], payload)
+	handle := byte(0)
+	for e.connection(handle) != nil {
+		handle++
+	}
+	db := e.peripheral(addr)
+	if db == nil || db.connected || db.p.AddressType != payload[6] {
+		e.connecting = true
+		return append(result(0), handle)
+	}
+	db.connected, db.connection = true, handle
+	// connected and completed, with the requested parameters and no
+	// bonding
+	status := append([]byte{handle, 0x05}, addr[:]...)
+	status = append(status, payload[6], payload[7], payload[8], payload[11], payload[12], payload[13], payload[14], 0xff)
+	e.emit(ble112.BG_MSG_CLASS_CONNECTION, ble112.BG_CONNECTION_STATUS, status)
+	return append(result(0), handle)
+}
+
+// disconnect closes a connection and returns the payload of the
+// connection_disconnected event reporting it.
+func (e *Emulator) disconnect(db *database, reason ble112.Result) []byte {
+	db.connected = false
+	return append([]byte{db.connection}, result(uint16(reason))...)
+}
+
+// array returns the uint8array at offset in payload, truncated if the
+// payload is too short to hold it.
+func array(payload []byte, offset int) []byte {
+	if offset >= len(payload) {
+		return nil
+	}
+	end := offset + 1 + int(payload[offset])
+	if end > len(payload) {
+		end = len(payload)
+	}
+	return payload[offset+1 : end]
+}
+
+// attclient handles the attclient commands, queueing the events of each
+// procedure followed by attclient_procedure_completed.
+func (e *Emulator) attclient(id byte, payload []byte) ([]byte, bool) {
+	conn := payload[0]
+	db := e.connection(conn)
+	if db == nil {
+		return failure(ble112.BG_MSG_CLASS_ATTCLIENT, id, ble112.ErrNotConnected), true
+	}
+	start := binary.LittleEndian.Uint16(payload[1:])
+	end := binary.LittleEndian.Uint16(payload[3:])
+	uuid := gatt.UUID(array(payload, 5))
+	var found bool
+	completed := func(r ble112.Result, handle uint16) {
+		p := append([]byte{conn}, result(uint16(r))...)
+		e.emit(ble112.BG_MSG_CLASS_ATTCLIENT, ble112.BG_PROCEDURE_COMPLETED, append(p, byte(handle), byte(handle>>8)))
+	}
+
+	switch id {
+	case ble112.BG_READ_BY_GROUP_TYPE:
+		for h := start; db.attribute(h) != nil && h <= end; h++ {
+			if a := db.attribute(h); a.typ.Equal(uuid) && a.typ.Equal(gatt.PrimaryServiceUUID) {
+				p := []byte{conn, byte(h), byte(h >> 8), byte(a.end), byte(a.end >> 8), byte(len(a.value))}
+				e.emit(ble112.BG_MSG_CLASS_ATTCLIENT, ble112.BG_GROUP_FOUND, append(p, a.value...))
+				found = true
+			}
+		}
+	case ble112.BG_READ_BY_TYPE:
+		for h := start; db.attribute(h) != nil && h <= end; h++ {
+			if a := db.attribute(h); a.typ.Equal(uuid) {
+				e.emitValue(conn, h, 3, a.value)
+				found = true
+			}
+		}
+	case ble112.BG_FIND_INFORMATION:
+		for h := start; db.attribute(h) != nil && h <= end; h++ {
+			a := db.attribute(h)
+			p := []byte{conn, byte(h), byte(h >> 8), byte(len(a.typ))}
+			e.emit(ble112.BG_MSG_CLASS_ATTCLIENT, ble112.BG_FIND_INFORMATION_FOUND, append(p, a.typ...))
+			found = true
+		}
+	case ble112.BG_READ_LONG:
+		a := db.attribute(start)
+		switch {
+		case a == nil:
+			completed(ble112.ErrInvalidHandle, start)
+		case a.props != 0 && a.props&gatt.PropRead == 0:
+			completed(ble112.ErrReadNotPermitted, start)
+		default:
+			// read blob responses carry up to 22 bytes each
+			value := a.value
+			for {
+				n := len(value)
+				if n > 22 {
+					n = 22
+				}
+				e.emitValue(conn, start, ble112.BG_ATTRIBUTE_VALUE_READ_LONG, value[:n])
+				value = value[n:]
+				if len(value) == 0 {
+					break
+				}
+			}
+			completed(0, start)
+		}
+		return append([]byte{conn}, result(0)...), true
+	case ble112.BG_ATTRIBUTE_WRITE, ble112.BG_WRITE_COMMAND:
+		a := db.attribute(start)
+		value := append([]byte(nil), array(payload, 3)...)
+		allowed := gatt.PropWrite
+		if id == ble112.BG_WRITE_COMMAND {
+			allowed = gatt.PropWriteWithoutResponse
+		}
+		var r ble112.Result
+		switch {
+		case a == nil:
+			r = ble112.ErrInvalidHandle
+		case a.typ.Equal(gatt.CCCDUUID):
+			a.value = value
+		case a.props&allowed == 0:
+			r = ble112.ErrWriteNotPermitted
+		default:
+			a.value = value
+		}
+		// write commands are not acknowledged, so their errors go
+		// unreported
+		if id == ble112.BG_ATTRIBUTE_WRITE {
+			completed(r, start)
+		}
+		return append([]byte{conn}, result(0)...), true
+	case ble112.BG_INDICATE_CONFIRM:
+		return result(0), true
+	default:
+		return nil, false
+	}
+	if found {
+		completed(0, end)
+	} else {
+		completed(ble112.ErrAttributeNotFound, start)
+	}
+	return append([]byte{conn}, result(0)...), true
+}
+
+// emitValue queues an attclient_attribute_value event.
+func (e *Emulator) emitValue(conn byte, handle uint16, typ byte, value []byte) {
+	p := []byte{conn, byte(handle), byte(handle >> 8), typ, byte(len(value))}
+	e.emit(ble112.BG_MSG_CLASS_ATTCLIENT, ble112.BG_ATTRIBUTE_VALUE, append(p, value...))
+}
+
+// Notify sets the value of the characteristic with the given uuid on the
+// peripheral with the given address and, if the host has enabled them,
+// sends a notification or indication of it. It reports whether one was
+// sent.
+func (e *Emulator) Notify(addr beacon.MacAddress, uuid gatt.UUID, value []byte) bool {
+	e.mu.Lock()
+	db := e.peripheral(addr)
+	if db == nil {
+		e.mu.Unlock()
+		return false
+	}
+	h := db.characteristic(uuid)
+	a := db.attribute(h)
+	if a == nil {
+		e.mu.Unlock()
+		return false
+	}
+	a.value = append([]byte(nil), value...)
+	var config byte
+	if cccd := db.attribute(a.cccd); cccd != nil && len(cccd.value) > 0 {
+		config = cccd.value[0]
+	}
+	connected, conn := db.connected, db.connection
+	e.mu.Unlock()
+
+	var typ byte
+	switch {
+	case !connected:
+		return false
+	case config&1 != 0:
+		typ = ble112.BG_ATTRIBUTE_VALUE_NOTIFY
+	case config&2 != 0:
+		typ = ble112.BG_ATTRIBUTE_VALUE_INDICATE_C
+	default:
+		return false
+	}
+	p := []byte{conn, byte(h), byte(h >> 8), typ, byte(len(value))}
+	e.Inject(ble112.BG_MSG_CLASS_ATTCLIENT, ble112.BG_ATTRIBUTE_VALUE, append(p, value...))
+	return true
+}
+
+// Disconnect makes the peripheral with the given address drop its
+// connection, as a peripheral going out of range does.
+func (e *Emulator) Disconnect(addr beacon.MacAddress) {
+	e.mu.Lock()
+	db := e.peripheral(addr)
+	if db == nil || !db.connected {
+		e.mu.Unlock()
+		return
+	}
+	event := e.disconnect(db, ble112.ErrRemoteUserTerminated)
+	e.mu.Unlock()
+	e.Inject(ble112.BG_MSG_CLASS_CONNECTION, ble112.BG_CONNECTION_DISCONNECTED, event)
+}
+
+// Connected reports whether the BLE112 is connected to the peripheral with
+// the given address.
+func (e *Emulator) Connected(addr beacon.MacAddress) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	db := e.peripheral(addr)
+	return db != nil && db.connected
+}
+
+// CharacteristicValue returns the value of the characteristic with the
+// given uuid on the peripheral with the given address.
+func (e *Emulator) CharacteristicValue(addr beacon.MacAddress, uuid gatt.UUID) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	db := e.peripheral(addr)
+	if db == nil {
+		return nil
+	}
+	if a := db.attribute(db.characteristic(uuid)); a != nil {
+		return append([]byte(nil), a.value...)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package ble112
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Result is the 16-bit result code returned in BGAPI command responses.
+// Non-zero results are errors, and can be matched with errors.Is:
+//
+//	if errors.Is(err, ble112.ErrDeviceInWrongState) {
+//		...
+//	}
+type Result uint16
+
+// BGAPI result codes, as listed in the Bluegiga API reference.
+const (
+	ErrInvalidParameter        Result = 0x0180
+	ErrDeviceInWrongState      Result = 0x0181
+	ErrOutOfMemory             Result = 0x0182
+	ErrFeatureNotImplemented   Result = 0x0183
+	ErrCommandNotRecognized    Result = 0x0184
+	ErrTimeout                 Result = 0x0185
+	ErrNotConnected            Result = 0x0186
+	ErrFlowControl             Result = 0x0187
+	ErrUserAttribute           Result = 0x0188
+	ErrInvalidLicenseKey       Result = 0x0189
+	ErrCommandTooLong          Result = 0x018a
+	ErrOutOfBonds              Result = 0x018b
+	ErrScriptOverflow          Result = 0x018c
+	ErrAuthenticationFailure   Result = 0x0205
+	ErrPinOrKeyMissing         Result = 0x0206
+	ErrMemoryCapacityExceeded  Result = 0x0207
+	ErrConnectionTimeout       Result = 0x0208
+	ErrConnectionLimitExceeded Result = 0x0209
+	ErrCommandDisallowed       Result = 0x020c
+	ErrInvalidCommandParams    Result = 0x0212
+	ErrRemoteUserTerminated    Result = 0x0213
+	ErrLocalHostTerminated     Result = 0x0216
+	ErrLLResponseTimeout       Result = 0x0222
+	ErrLLInstantPassed         Result = 0x0228
+	ErrControllerBusy          Result = 0x023a
+	ErrUnacceptableConnInt     Result = 0x023b
+	ErrDirectedAdvTimeout      Result = 0x023c
+	ErrMICFailure              Result = 0x023d
+	ErrConnectionFailed        Result = 0x023e
+	ErrPasskeyEntryFailed      Result = 0x0301
+	ErrOOBDataNotAvailable     Result = 0x0302
+	ErrAuthRequirements        Result = 0x0303
+	ErrConfirmValueFailed      Result = 0x0304
+	ErrPairingNotSupported     Result = 0x0305
+	ErrEncryptionKeySize       Result = 0x0306
+	ErrSMCommandNotSupported   Result = 0x0307
+	ErrUnspecifiedReason       Result = 0x0308
+	ErrRepeatedAttempts        Result = 0x0309
+	ErrSMInvalidParameters     Result = 0x030a
+	ErrInvalidHandle           Result = 0x0401
+	ErrReadNotPermitted        Result = 0x0402
+	ErrWriteNotPermitted       Result = 0x0403
+	ErrInvalidPDU              Result = 0x0404
+	ErrInsufficientAuth        Result = 0x0405
+	ErrRequestNotSupported     Result = 0x0406
+	ErrInvalidOffset           Result = 0x0407
+	ErrInsufficientAuthz       Result = 0x0408
+	ErrPrepareQueueFull        Result = 0x0409
+	ErrAttributeNotFound       Result = 0x040a
+	ErrAttributeNotLong        Result = 0x040b
+	ErrInsufficientKeySize     Result = 0x040c
+	ErrInvalidAttributeLength  Result = 0x040d
+	ErrUnlikelyError           Result = 0x040e
+	ErrInsufficientEncryption  Result = 0x040f
+	ErrUnsupportedGroupType    Result = 0x0410
+	ErrInsufficientResources   Result = 0x0411
+	ErrApplicationError        Result = 0x0480
+	ErrPSStoreFull             Result = 0x0a01
+	ErrPSKeyNotFound           Result = 0x0a02
+	ErrI2CWriteFailed          Result = 0x0a03
+	ErrI2CReadFailed           Result = 0x0a04
+)
+
+var resultDescriptions = map[Result]string{
+	ErrInvalidParameter:        "invalid parameter",
+	ErrDeviceInWrongState:      "device in wrong state",
+	ErrOutOfMemory:             "out of memory",
+	ErrFeatureNotImplemented:   "feature not implemented",
+	ErrCommandNotRecognized:    "command not recognized",
+	ErrTimeout:                 "timeout",
+	ErrNotConnected:            "not connected",
+	ErrFlowControl:             "flow control",
+	ErrUserAttribute:           "user attribute",
+	ErrInvalidLicenseKey:       "invalid license key",
+	ErrCommandTooLong:          "command too long",
+	ErrOutOfBonds:              "out of bonds",
+	ErrScriptOverflow:          "script overflow",
+	ErrAuthenticationFailure:   "authentication failure",
+	ErrPinOrKeyMissing:         "pin or key missing",
+	ErrMemoryCapacityExceeded:  "memory capacity exceeded",
+	ErrConnectionTimeout:       "connection timeout",
+	ErrConnectionLimitExceeded: "connection limit exceeded",
+	ErrCommandDisallowed:       "command disallowed",
+	ErrInvalidCommandParams:    "invalid command parameters",
+	ErrRemoteUserTerminated:    "remote user terminated connection",
+	ErrLocalHostTerminated:     "connection terminated by local host",
+	ErrLLResponseTimeout:       "link layer response timeout",
+	ErrLLInstantPassed:         "link layer instant passed",
+	ErrControllerBusy:          "controller busy",
+	ErrUnacceptableConnInt:     "unacceptable connection interval",
+	ErrDirectedAdvTimeout:      "directed advertising timeout",
+	ErrMICFailure:              "MIC failure",
+	ErrConnectionFailed:        "connection failed to be established",
+	ErrPasskeyEntryFailed:      "passkey entry failed",
+	ErrOOBDataNotAvailable:     "OOB data is not available",
+	ErrAuthRequirements:        "authentication requirements",
+	ErrConfirmValueFailed:      "confirm value failed",
+	ErrPairingNotSupported:     "pairing not supported",
+	ErrEncryptionKeySize:       "encryption key size",
+	ErrSMCommandNotSupported:   "command not supported",
+	ErrUnspecifiedReason:       "unspecified reason",
+	ErrRepeatedAttempts:        "repeated attempts",
+	ErrSMInvalidParameters:     "invalid parameters",
+	ErrInvalidHandle:           "invalid handle",
+	ErrReadNotPermitted:        "read not permitted",
+	ErrWriteNotPermitted:       "write not permitted",
+	ErrInvalidPDU:              "invalid PDU",
+	ErrInsufficientAuth:        "insufficient authentication",
+	ErrRequestNotSupported:     "request not supported",
+	ErrInvalidOffset:           "invalid offset",
+	ErrInsufficientAuthz:       "insufficient authorization",
+	ErrPrepareQueueFull:        "prepare queue full",
+	ErrAttributeNotFound:       "attribute not found",
+	ErrAttributeNotLong:        "attribute not long",
+	ErrInsufficientKeySize:     "insufficient encryption key size",
+	ErrInvalidAttributeLength:  "invalid attribute value length",
+	ErrUnlikelyError:           "unlikely error",
+	ErrInsufficientEncryption:  "insufficient encryption",
+	ErrUnsupportedGroupType:    "unsupported group type",
+	ErrInsufficientResources:   "insufficient resources",
+	ErrApplicationError:        "application error",
+	ErrPSStoreFull:             "persistent store full",
+	ErrPSKeyNotFound:           "persistent store key not found",
+	ErrI2CWriteFailed:          "I2C write failed",
+	ErrI2CReadFailed:           "I2C read failed",
+}
+
+func (r Result) Error() string {
+	if d, ok := resultDescriptions[r]; ok {
+		return fmt.Sprintf("%v (0x%04x)", d, uint16(r))
+	}
+	return fmt.Sprintf("result 0x%04x", uint16(r))
+}
+
+// ErrCommandTimeout is returned, wrapped in a CommandError, when the
+// BLE112 does not respond to a command within the Device's Timeout.
+var ErrCommandTimeout = errors.New("no response")
+
+// A CommandError reports the failure of a BGAPI command. Err is the
+// command's non-zero Result, ErrCommandTimeout, ErrClosed or the error
+// from writing to the connection.
+type CommandError struct {
+	Command string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("ble112: %v: %v", e.Command, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// commandResults gives the offset of the result code within the
+// responses of commands which return one.
+var commandResults = map[messageKey]int{
+	{false, 0, 3}:  0, // system_reg_write
+	{false, 0, 9}:  0, // system_endpoint_tx
+	{false, 0, 10}: 0, // system_whitelist_append
+	{false, 0, 11}: 0, // system_whitelist_remove
+	{false, 0, 13}: 0, // system_endpoint_rx
+	{false, 0, 14}: 0, // system_endpoint_set_watermarks
+	{false, 1, 3}:  0, // flash_ps_save
+	{false, 1, 4}:  0, // flash_ps_load
+	{false, 1, 6}:  0, // flash_erase_page
+	{false, 1, 7}:  0, // flash_write_data
+	{false, 2, 0}:  0, // attributes_write
+	{false, 2, 1}:  4, // attributes_read
+	{false, 2, 2}:  2, // attributes_read_type
+	{false, 3, 0}:  1, // connection_disconnect
+	{false, 3, 2}:  1, // connection_update
+	{false, 3, 3}:  1, // connection_version_update
+	{false, 4, 0}:  1, // attclient_find_by_type_value
+	{false, 4, 1}:  1, // attclient_read_by_group_type
+	{false, 4, 2}:  1, // attclient_read_by_type
+	{false, 4, 3}:  1, // attclient_find_information
+	{false, 4, 4}:  1, // attclient_read_by_handle
+	{false, 4, 5}:  1, // attclient_attribute_write
+	{false, 4, 6}:  1, // attclient_write_command
+	{false, 4, 7}:  0, // attclient_indicate_confirm
+	{false, 4, 8}:  1, // attclient_read_long
+	{false, 4, 9}:  1, // attclient_prepare_write
+	{false, 4, 10}: 1, // attclient_execute_write
+	{false, 4, 11}: 1, // attclient_read_multiple
+	{false, 5, 0}:  1, // sm_encrypt_start
+	{false, 5, 2}:  0, // sm_delete_bonding
+	{false, 5, 4}:  0, // sm_passkey_entry
+	{false, 6, 1}:  0, // gap_set_mode
+	{false, 6, 2}:  0, // gap_discover
+	{false, 6, 3}:  0, // gap_connect_direct
+	{false, 6, 4}:  0, // gap_end_procedure
+	{false, 6, 5}:  0, // gap_connect_selective
+	{false, 6, 6}:  0, // gap_set_filtering
+	{false, 6, 7}:  0, // gap_set_scan_parameters
+	{false, 6, 8}:  0, // gap_set_adv_parameters
+	{false, 6, 9}:  0, // gap_set_adv_data
+	{false, 6, 10}: 0, // gap_set_directed_connectable_mode
+	{false, 6, 12}: 0, // gap_set_nonresolvable_address
+	{false, 9, 1}:  0, // dfu_flash_set_address
+	{false, 9, 2}:  0, // dfu_flash_upload
+	{false, 9, 3}:  0, // dfu_flash_upload_finish
+}
+
+// Result returns the result code carried by a command response, or zero
+// if the command does not return one.
+func (r *Response) Result() Result {
+	offset, ok := commandResults[messageKey{false, r.Class(), r.Command()}]
+	if !ok || r.IsEvent() || len(r.Payload()) < offset+2 {
+		return 0
+	}
+	p := r.Payload()
+	return Result(uint16(p[offset]) | uint16(p[offset+1])<<8)
+}
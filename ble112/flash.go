@@ -0,0 +1,115 @@
+package ble112
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// flash_ps_key event, sent for each key by flash_ps_dump
+const BG_PS_KEY = byte(0)
+
+// The persistent store keys available to applications, and the largest
+// value each can hold.
+const (
+	MinPSKey     = 0x8000
+	MaxPSKey     = 0x807f
+	MaxPSValue   = 32
+	psKeyDumpEnd = 0xffff // ends a flash_ps_dump
+)
+
+func checkPSKey(key uint16) error {
+	if key < MinPSKey || key > MaxPSKey {
+		return fmt.Errorf("ble112: ps key %#04x outside %#04x-%#04x", key, MinPSKey, MaxPSKey)
+	}
+	return nil
+}
+
+// PSSave stores value, which may be up to MaxPSValue bytes long, under the
+// given key in the BLE112's persistent store, which survives resets and
+// power cycles.
+func (device *Device) PSSave(key uint16, value []byte) error {
+	if err := checkPSKey(key); err != nil {
+		return err
+	}
+	if len(value) > MaxPSValue {
+		return fmt.Errorf("ble112: ps value of %d bytes exceeds %d", len(value), MaxPSValue)
+	}
+	payload := append([]byte{byte(key), byte(key >> 8), byte(len(value))}, value...)
+	_, err := device.SendCommand(BG_MSG_CLASS_FLASH, BG_PS_SAVE, payload)
+	return err
+}
+
+// PSLoad returns the value stored under the given key. The BLE112 returns
+// an error result if the key has no value.
+func (device *Device) PSLoad(key uint16) ([]byte, error) {
+	if err := checkPSKey(key); err != nil {
+		return nil, err
+	}
+	r, err := device.SendCommand(BG_MSG_CLASS_FLASH, BG_PS_LOAD, []byte{byte(key), byte(key >> 8)})
+	if err != nil {
+		return nil, err
+	}
+	p := r.Payload()
+	if len(p) < 3 || len(p) < 3+int(p[2]) {
+		return nil, fmt.Errorf("error loading ps key: not enough bytes")
+	}
+	return append([]byte(nil), p[3:3+p[2]]...), nil
+}
+
+// PSErase removes the given key from the persistent store.
+func (device *Device) PSErase(key uint16) error {
+	if err := checkPSKey(key); err != nil {
+		return err
+	}
+	_, err := device.SendCommand(BG_MSG_CLASS_FLASH, BG_PS_ERASE, []byte{byte(key), byte(key >> 8)})
+	return err
+}
+
+// PSDump returns every key in the persistent store, including the
+// BLE112's own, with its value.
+func (device *Device) PSDump() (map[uint16][]byte, error) {
+	c, err := device.currentConn()
+	if err != nil {
+		return nil, &CommandError{"flash_ps_dump", err}
+	}
+	sub := c.subscribe()
+	defer c.unsubscribe(sub)
+	if _, err := device.SendCommand(BG_MSG_CLASS_FLASH, BG_PS_DUMP, NULL_DATA); err != nil {
+		return nil, err
+	}
+
+	timeout := device.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	keys := make(map[uint16][]byte)
+	for {
+		select {
+		case r, more := <-sub.events:
+			if !more {
+				return nil, &CommandError{"flash_ps_dump", ErrClosed}
+			}
+			p := r.Payload()
+			if r.Class() != BG_MSG_CLASS_FLASH || r.Command() != BG_PS_KEY || len(p) < 3 {
+				continue
+			}
+			key := binary.LittleEndian.Uint16(p)
+			if key == psKeyDumpEnd {
+				return keys, nil
+			}
+			if len(p) >= 3+int(p[2]) {
+				keys[key] = append([]byte(nil), p[3:3+p[2]]...)
+			}
+			// the dump takes as long as there are keys
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			return nil, &CommandError{"flash_ps_dump", ErrCommandTimeout}
+		}
+	}
+}
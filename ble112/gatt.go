@@ -0,0 +1,396 @@
+package ble112
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/gatt"
+)
+
+// attclient and connection events, and their flags
+const (
+	BG_CONNECTION_STATUS          = byte(0)
+	BG_CONNECTION_DISCONNECTED    = byte(4)
+	BG_PROCEDURE_COMPLETED        = byte(1)
+	BG_GROUP_FOUND                = byte(2)
+	BG_FIND_INFORMATION_FOUND     = byte(4)
+	BG_ATTRIBUTE_VALUE            = byte(5)
+	BG_CONNECTION_CONNECTED       = byte(1)
+	BG_ATTRIBUTE_VALUE_NOTIFY     = byte(1)
+	BG_ATTRIBUTE_VALUE_INDICATE   = byte(2)
+	BG_ATTRIBUTE_VALUE_READ_LONG  = byte(4)
+	BG_ATTRIBUTE_VALUE_INDICATE_C = byte(5) // an indication awaiting confirmation
+)
+
+// Connection and procedure timeouts.
+const (
+	DefaultConnectTimeout   = 5 * time.Second
+	DefaultProcedureTimeout = 10 * time.Second
+)
+
+var _ gatt.Connector = (*Device)(nil)
+
+// Connect connects to the peripheral with the given address, which is
+// public if addressType is 0 or random if it is 1, with gap_connect_direct.
+// The BLE112 cannot connect while it is scanning.
+func (device *Device) Connect(addr beacon.MacAddress, addressType uint8) (gatt.Client, error) {
+	c, err := device.currentConn()
+	if err != nil {
+		return nil, &CommandError{"gap_connect_direct", err}
+	}
+	sub := c.subscribe()
+	// 75-95ms connection interval, 1s supervision timeout, no latency
+	params := []byte{addressType, 0x3c, 0x00, 0x4c, 0x00, 0x64, 0x00, 0x00, 0x00}
+	r, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_CONNECT_DIRECT, append(addr[:len(addr):len(addr)], params...))
+	if err != nil {
+		c.unsubscribe(sub)
+		return nil, err
+	}
+	handle := r.Payload()[2]
+
+	timeout := device.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case e, more := <-sub.events:
+			if !more {
+				return nil, &CommandError{"gap_connect_direct", ErrClosed}
+			}
+			p := e.Payload()
+			if e.Class() != BG_MSG_CLASS_CONNECTION || len(p) < 3 || p[0] != handle {
+				continue
+			}
+			switch e.Command() {
+			case BG_CONNECTION_STATUS:
+				if p[1]&BG_CONNECTION_CONNECTED != 0 {
+					return newConnection(device, c, sub, handle, addr, addressType), nil
+				}
+			case BG_CONNECTION_DISCONNECTED:
+				c.unsubscribe(sub)
+				return nil, &CommandError{"gap_connect_direct", Result(binary.LittleEndian.Uint16(p[1:]))}
+			}
+		case <-timer.C:
+			// cancel the connection attempt
+			device.SendCommand(BG_MSG_CLASS_GAP, BG_DISCOVER_STOP, NULL_DATA)
+			c.unsubscribe(sub)
+			return nil, &CommandError{"gap_connect_direct", ErrCommandTimeout}
+		}
+	}
+}
+
+// A Connection is a connection from the BLE112 to a peripheral, and a GATT
+// client of the peripheral.
+type Connection struct {
+	Address     beacon.MacAddress
+	AddressType uint8
+
+	device       *Device
+	conn         *conn
+	sub          *subscription
+	handle       byte
+	disconnected chan struct{}
+
+	procMu   sync.Mutex // serializes procedures
+	mu       sync.Mutex // guards proc and handlers
+	proc     *procedure
+	handlers map[uint16]gatt.NotificationHandler
+}
+
+var _ gatt.Client = (*Connection)(nil)
+
+// A procedure receives the attclient events of the procedure in progress.
+type procedure struct {
+	events chan *Response
+	done   chan struct{}
+}
+
+func newConnection(device *Device, c *conn, sub *subscription, handle byte, addr beacon.MacAddress, addressType uint8) *Connection {
+	cn := &Connection{
+		Address:      addr,
+		AddressType:  addressType,
+		device:       device,
+		conn:         c,
+		sub:          sub,
+		handle:       handle,
+		disconnected: make(chan struct{}),
+		handlers:     make(map[uint16]gatt.NotificationHandler),
+	}
+	go cn.run()
+	return cn
+}
+
+// run dispatches the connection's events until it closes.
+func (cn *Connection) run() {
+	defer close(cn.disconnected)
+	defer cn.conn.unsubscribe(cn.sub)
+	for r := range cn.sub.events {
+		p := r.Payload()
+		if len(p) == 0 || p[0] != cn.handle {
+			continue
+		}
+		switch r.Class() {
+		case BG_MSG_CLASS_CONNECTION:
+			if r.Command() == BG_CONNECTION_DISCONNECTED {
+				return
+			}
+		case BG_MSG_CLASS_ATTCLIENT:
+			if r.Command() == BG_ATTRIBUTE_VALUE && len(p) >= 5 {
+				switch p[3] {
+				case BG_ATTRIBUTE_VALUE_NOTIFY, BG_ATTRIBUTE_VALUE_INDICATE, BG_ATTRIBUTE_VALUE_INDICATE_C:
+					cn.notify(binary.LittleEndian.Uint16(p[1:]), p[5:])
+					if p[3] == BG_ATTRIBUTE_VALUE_INDICATE_C {
+						// confirming waits for a response, which the
+						// connection's reader cannot deliver while this
+						// goroutine holds it up
+						go cn.device.SendCommand(BG_MSG_CLASS_ATTCLIENT, BG_INDICATE_CONFIRM, []byte{cn.handle})
+					}
+					continue
+				}
+			}
+			cn.mu.Lock()
+			proc := cn.proc
+			cn.mu.Unlock()
+			if proc != nil {
+				select {
+				case proc.events <- r:
+				case <-proc.done:
+				}
+			}
+		}
+	}
+}
+
+func (cn *Connection) notify(handle uint16, value []byte) {
+	cn.mu.Lock()
+	h := cn.handlers[handle]
+	cn.mu.Unlock()
+	if h != nil {
+		h(append([]byte(nil), value...))
+	}
+}
+
+// procedure runs an attclient procedure: it sends the command, then passes
+// the payload of each event of the procedure to handle until
+// attclient_procedure_completed arrives.
+func (cn *Connection) procedure(id byte, payload []byte, handle func(event byte, p []byte)) error {
+	cn.procMu.Lock()
+	defer cn.procMu.Unlock()
+	name := CommandName(BG_MSG_CLASS_ATTCLIENT, id)
+	select {
+	case <-cn.disconnected:
+		return &CommandError{name, gatt.ErrDisconnected}
+	default:
+	}
+
+	proc := &procedure{events: make(chan *Response, 16), done: make(chan struct{})}
+	cn.mu.Lock()
+	cn.proc = proc
+	cn.mu.Unlock()
+	defer func() {
+		cn.mu.Lock()
+		cn.proc = nil
+		cn.mu.Unlock()
+		close(proc.done)
+	}()
+
+	if _, err := cn.device.SendCommand(BG_MSG_CLASS_ATTCLIENT, id, append([]byte{cn.handle}, payload...)); err != nil {
+		return err
+	}
+	timer := time.NewTimer(DefaultProcedureTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-proc.events:
+			p := r.Payload()
+			if r.Command() == BG_PROCEDURE_COMPLETED {
+				if result := Result(binary.LittleEndian.Uint16(p[1:])); result != 0 {
+					return &CommandError{name, result}
+				}
+				return nil
+			}
+			if handle != nil {
+				handle(r.Command(), p)
+			}
+		case <-cn.disconnected:
+			return &CommandError{name, gatt.ErrDisconnected}
+		case <-timer.C:
+			return &CommandError{name, ErrCommandTimeout}
+		}
+	}
+}
+
+// uint8array encodes b as a BGAPI uint8array, prefixed with its length.
+func uint8array(b []byte) []byte {
+	return append([]byte{byte(len(b))}, b...)
+}
+
+func handleRange(start uint16, end uint16) []byte {
+	return []byte{byte(start), byte(start >> 8), byte(end), byte(end >> 8)}
+}
+
+// DiscoverServices returns the peripheral's primary services.
+func (cn *Connection) DiscoverServices() ([]*gatt.Service, error) {
+	var services []*gatt.Service
+	payload := append(handleRange(0x0001, 0xffff), uint8array(gatt.PrimaryServiceUUID)...)
+	err := cn.procedure(BG_READ_BY_GROUP_TYPE, payload, func(event byte, p []byte) {
+		if event == BG_GROUP_FOUND && len(p) >= 6 {
+			services = append(services, &gatt.Service{
+				Start: binary.LittleEndian.Uint16(p[1:]),
+				End:   binary.LittleEndian.Uint16(p[3:]),
+				UUID:  gatt.UUID(append([]byte(nil), p[6:]...)),
+			})
+		}
+	})
+	return services, err
+}
+
+// DiscoverCharacteristics returns the characteristics of s, along with
+// their client characteristic configuration descriptors.
+func (cn *Connection) DiscoverCharacteristics(s *gatt.Service) ([]*gatt.Characteristic, error) {
+	var chars []*gatt.Characteristic
+	payload := append(handleRange(s.Start, s.End), uint8array(gatt.CharacteristicUUID)...)
+	err := cn.procedure(BG_READ_BY_TYPE, payload, func(event byte, p []byte) {
+		// the value of a characteristic declaration is its properties,
+		// value handle and uuid
+		if event == BG_ATTRIBUTE_VALUE && len(p) >= 10 {
+			chars = append(chars, &gatt.Characteristic{
+				Handle:      binary.LittleEndian.Uint16(p[1:]),
+				Properties:  gatt.Property(p[5]),
+				ValueHandle: binary.LittleEndian.Uint16(p[6:]),
+				UUID:        gatt.UUID(append([]byte(nil), p[8:]...)),
+			})
+		}
+	})
+	// discovery ends with attribute not found once the range is done
+	if err != nil && !errors.Is(err, ErrAttributeNotFound) {
+		return nil, err
+	} else if len(chars) == 0 {
+		return nil, nil
+	}
+
+	err = cn.procedure(BG_FIND_INFORMATION, handleRange(s.Start, s.End), func(event byte, p []byte) {
+		if event != BG_FIND_INFORMATION_FOUND || len(p) < 4 || !gatt.UUID(p[4:]).Equal(gatt.CCCDUUID) {
+			return
+		}
+		// the descriptor belongs to the last characteristic before it
+		h := binary.LittleEndian.Uint16(p[1:])
+		var owner *gatt.Characteristic
+		for _, c := range chars {
+			if c.ValueHandle < h && (owner == nil || c.ValueHandle > owner.ValueHandle) {
+				owner = c
+			}
+		}
+		if owner != nil {
+			owner.CCCDHandle = h
+		}
+	})
+	return chars, err
+}
+
+// Read returns the value of c, using attclient_read_long so that values
+// longer than a single packet are read whole.
+func (cn *Connection) Read(c *gatt.Characteristic) ([]byte, error) {
+	var value []byte
+	payload := []byte{byte(c.ValueHandle), byte(c.ValueHandle >> 8)}
+	err := cn.procedure(BG_READ_LONG, payload, func(event byte, p []byte) {
+		if event == BG_ATTRIBUTE_VALUE && len(p) >= 5 && binary.LittleEndian.Uint16(p[1:]) == c.ValueHandle {
+			value = append(value, p[5:]...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Write writes the value of c, which may be up to 20 bytes long, and waits
+// for the peripheral to acknowledge it.
+func (cn *Connection) Write(c *gatt.Characteristic, value []byte) error {
+	return cn.write(c.ValueHandle, value)
+}
+
+func (cn *Connection) write(handle uint16, value []byte) error {
+	payload := append([]byte{byte(handle), byte(handle >> 8)}, uint8array(value)...)
+	return cn.procedure(BG_ATTRIBUTE_WRITE, payload, nil)
+}
+
+// WriteWithoutResponse writes the value of c, which may be up to 20 bytes
+// long, without waiting for the peripheral.
+func (cn *Connection) WriteWithoutResponse(c *gatt.Characteristic, value []byte) error {
+	payload := append([]byte{cn.handle, byte(c.ValueHandle), byte(c.ValueHandle >> 8)}, uint8array(value)...)
+	_, err := cn.device.SendCommand(BG_MSG_CLASS_ATTCLIENT, BG_WRITE_COMMAND, payload)
+	return err
+}
+
+// ErrNoCCCD is returned by Subscribe and Unsubscribe when a characteristic
+// has no client characteristic configuration descriptor.
+var ErrNoCCCD = errors.New("ble112: characteristic cannot notify")
+
+// Subscribe enables notifications, or indications if c does not support
+// notifications, and calls h with each value received. h is called on the
+// goroutine which reads the connection's events, so it must not block.
+func (cn *Connection) Subscribe(c *gatt.Characteristic, h gatt.NotificationHandler) error {
+	var config byte
+	switch {
+	case c.CCCDHandle == 0:
+		return ErrNoCCCD
+	case c.Properties&gatt.PropNotify != 0:
+		config = 1
+	case c.Properties&gatt.PropIndicate != 0:
+		config = 2
+	default:
+		return ErrNoCCCD
+	}
+	cn.mu.Lock()
+	cn.handlers[c.ValueHandle] = h
+	cn.mu.Unlock()
+	if err := cn.write(c.CCCDHandle, []byte{config, 0}); err != nil {
+		cn.mu.Lock()
+		delete(cn.handlers, c.ValueHandle)
+		cn.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe disables notifications or indications of c.
+func (cn *Connection) Unsubscribe(c *gatt.Characteristic) error {
+	if c.CCCDHandle == 0 {
+		return ErrNoCCCD
+	}
+	cn.mu.Lock()
+	delete(cn.handlers, c.ValueHandle)
+	cn.mu.Unlock()
+	return cn.write(c.CCCDHandle, []byte{0, 0})
+}
+
+// Disconnect closes the connection and waits for the BLE112 to report it
+// closed.
+func (cn *Connection) Disconnect() error {
+	_, err := cn.device.SendCommand(BG_MSG_CLASS_CONNECTION, BG_DISCONNECT, []byte{cn.handle})
+	if errors.Is(err, ErrNotConnected) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	select {
+	case <-cn.disconnected:
+		return nil
+	case <-time.After(DefaultProcedureTimeout):
+		return &CommandError{"connection_disconnect", ErrCommandTimeout}
+	}
+}
+
+// Disconnected is closed when the connection closes, whether by
+// Disconnect, by the peripheral or by the connection to the BLE112
+// closing.
+func (cn *Connection) Disconnected() <-chan struct{} {
+	return cn.disconnected
+}
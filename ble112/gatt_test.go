@@ -0,0 +1,261 @@
+package ble112_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+	"github.com/RadiusNetworks/go-beacon/gatt"
+)
+
+var (
+	peripheralAddr = beacon.MacAddress{0x10, 0x20, 0x30, 0x40, 0x50, 0x60}
+	serviceUUID    = gatt.MustParseUUID("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6")
+	configUUID     = gatt.MustParseUUID("2f234454-cf6d-4a0f-adf2-f4911ba9ffa7")
+	sensorUUID     = gatt.MustParseUUID("2f234454-cf6d-4a0f-adf2-f4911ba9ffa8")
+	commandUUID    = gatt.MustParseUUID("2f234454-cf6d-4a0f-adf2-f4911ba9ffa9")
+	batteryUUID    = gatt.UUID16(0x180f)
+	levelUUID      = gatt.UUID16(0x2a19)
+)
+
+func newPeripheral() emulator.Peripheral {
+	return emulator.Peripheral{
+		Address:     peripheralAddr,
+		AddressType: 1,
+		Services: []emulator.Service{
+			{UUID: batteryUUID, Characteristics: []emulator.Characteristic{
+				{UUID: levelUUID, Properties: gatt.PropRead | gatt.PropNotify, Value: []byte{87}},
+			}},
+			{UUID: serviceUUID, Characteristics: []emulator.Characteristic{
+				{UUID: configUUID, Properties: gatt.PropRead | gatt.PropWrite, Value: bytes.Repeat([]byte("config"), 8)},
+				{UUID: sensorUUID, Properties: gatt.PropIndicate},
+				{UUID: commandUUID, Properties: gatt.PropWriteWithoutResponse},
+			}},
+		},
+	}
+}
+
+func connect(t *testing.T, device *ble112.Device) gatt.Client {
+	client, err := device.Connect(peripheralAddr, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func TestDeviceGATT(t *testing.T) {
+	e := newEmulator(emulator.Config{Peripherals: []emulator.Peripheral{newPeripheral()}})
+	device := newDevice(t, e)
+	client := connect(t, device)
+
+	services, err := client.DiscoverServices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services) != 2 || !services[0].UUID.Equal(batteryUUID) || !services[1].UUID.Equal(serviceUUID) {
+		t.Fatalf("got services %v; expected battery and custom services", services)
+	}
+	chars, err := client.DiscoverCharacteristics(services[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chars) != 3 {
+		t.Fatalf("got %v characteristics; expected 3", len(chars))
+	}
+	if chars[0].CCCDHandle != 0 || chars[1].CCCDHandle == 0 || chars[1].Properties != gatt.PropIndicate {
+		t.Errorf("got %+v and %+v; expected only the second to indicate", chars[0], chars[1])
+	}
+
+	config, err := gatt.FindCharacteristic(client, serviceUUID, configUUID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// longer than a single attribute value event
+	if value, err := client.Read(config); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !bytes.Equal(value, bytes.Repeat([]byte("config"), 8)) {
+		t.Errorf("got %q; expected the whole value", value)
+	}
+	if err := client.Write(config, []byte("new")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := e.CharacteristicValue(peripheralAddr, configUUID); string(got) != "new" {
+		t.Errorf("got %q; expected the written value", got)
+	}
+
+	command := chars[2]
+	if err := client.WriteWithoutResponse(command, []byte{1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = client.Write(command, []byte{2})
+	if !errors.Is(err, ble112.ErrWriteNotPermitted) {
+		t.Errorf("got %v; expected a write without response only characteristic", err)
+	}
+	if _, err := client.Read(command); !errors.Is(err, ble112.ErrReadNotPermitted) {
+		t.Errorf("got %v; expected the read to be refused", err)
+	}
+	if got := e.CharacteristicValue(peripheralAddr, commandUUID); !bytes.Equal(got, []byte{1}) {
+		t.Errorf("got %v; expected the value written without response", got)
+	}
+}
+
+func TestDeviceGATTDiscoveryErrors(t *testing.T) {
+	e := newEmulator(emulator.Config{Peripherals: []emulator.Peripheral{newPeripheral()}})
+	device := newDevice(t, e)
+	client := connect(t, device)
+	services, err := client.DiscoverServices()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// failures before any characteristic is found are not taken for an
+	// empty service
+	e.SetResult("attclient_read_by_type", ble112.ErrInvalidHandle)
+	if chars, err := client.DiscoverCharacteristics(services[1]); !errors.Is(err, ble112.ErrInvalidHandle) {
+		t.Errorf("got %v, %v; expected the procedure's error", chars, err)
+	}
+	e.SetResult("attclient_read_by_type", 0)
+
+	e.Disconnect(peripheralAddr)
+	<-client.Disconnected()
+	if chars, err := client.DiscoverCharacteristics(services[1]); !errors.Is(err, gatt.ErrDisconnected) {
+		t.Errorf("got %v, %v; expected the connection to be closed", chars, err)
+	}
+}
+
+func TestDeviceGATTNotifications(t *testing.T) {
+	e := newEmulator(emulator.Config{Peripherals: []emulator.Peripheral{newPeripheral()}})
+	device := newDevice(t, e)
+	client := connect(t, device)
+
+	values := make(chan []byte, 10)
+	handler := func(value []byte) { values <- value }
+	for _, uuid := range [][]gatt.UUID{{batteryUUID, levelUUID}, {serviceUUID, sensorUUID}} {
+		c, err := gatt.FindCharacteristic(client, uuid[0], uuid[1])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := client.Subscribe(c, handler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, uuid := range []gatt.UUID{levelUUID, sensorUUID} {
+		if !e.Notify(peripheralAddr, uuid, []byte{42}) {
+			t.Fatalf("%v not enabled", uuid)
+		}
+		select {
+		case value := <-values:
+			if !bytes.Equal(value, []byte{42}) {
+				t.Errorf("got %v; expected the notified value", value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v", uuid)
+		}
+	}
+	// the indication is confirmed
+	deadline := time.Now().Add(5 * time.Second)
+	for countCommands(e, "attclient_indicate_confirm") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("indication not confirmed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	level, _ := gatt.FindCharacteristic(client, batteryUUID, levelUUID)
+	if err := client.Unsubscribe(level); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Notify(peripheralAddr, levelUUID, []byte{41}) {
+		t.Error("notification sent after unsubscribing")
+	}
+}
+
+func TestDeviceGATTDisconnect(t *testing.T) {
+	e := newEmulator(emulator.Config{Peripherals: []emulator.Peripheral{newPeripheral()}})
+	device := newDevice(t, e)
+
+	client := connect(t, device)
+	if err := client.Disconnect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Connected(peripheralAddr) {
+		t.Error("still connected after Disconnect")
+	}
+
+	// the peripheral goes out of range
+	client = connect(t, device)
+	e.Disconnect(peripheralAddr)
+	select {
+	case <-client.Disconnected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the disconnection")
+	}
+	if _, err := client.DiscoverServices(); !errors.Is(err, gatt.ErrDisconnected) {
+		t.Errorf("got %v; expected the connection to be closed", err)
+	}
+}
+
+func TestDeviceGATTDuringScanFlood(t *testing.T) {
+	e := newEmulator(emulator.Config{Peripherals: []emulator.Peripheral{newPeripheral()}})
+	device := newDevice(t, e)
+	client := connect(t, device)
+	config, err := gatt.FindCharacteristic(client, serviceUUID, configUUID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	level, err := gatt.FindCharacteristic(client, batteryUUID, levelUUID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a slow notification handler holds up the connection's events
+	held, release := make(chan struct{}), make(chan struct{})
+	client.Subscribe(level, func([]byte) {
+		close(held)
+		<-release
+	})
+	e.Notify(peripheralAddr, levelUUID, []byte{42})
+	<-held
+
+	// while scan events flood in ahead of the read's
+	ad := emulator.ScanResponse(emulator.Beacon{Address: beaconAddr, Data: emulator.MfgData(0x0118, altBeaconAd)})
+	for i := 0; i < 100; i++ {
+		e.Inject(ble112.BG_MSG_CLASS_GAP, 0, ad)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Read(config)
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read lost its events behind the scans")
+	}
+}
+
+func TestDeviceConnectTimeout(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+	device.ConnectTimeout = 50 * time.Millisecond
+
+	if _, err := device.Connect(peripheralAddr, 1); !errors.Is(err, ble112.ErrCommandTimeout) {
+		t.Fatalf("got %v; expected no peripheral to answer", err)
+	}
+	// the attempt was cancelled, so the BLE112 can scan again
+	if err := device.StartScan(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package ble112
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Info describes a BLE112's firmware and hardware, as reported by
+// system_get_info.
+type Info struct {
+	Major     uint16
+	Minor     uint16
+	Patch     uint16
+	Build     uint16
+	LLVersion uint16 // link layer version
+	Protocol  uint8  // BGAPI protocol version
+	Hardware  uint8  // hardware revision
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%d.%d.%d-%d", i.Major, i.Minor, i.Patch, i.Build)
+}
+
+// Counters are the radio's packet counters, as reported by
+// system_get_counters. The BLE112 resets them each time they are read.
+type Counters struct {
+	TxOK        uint8 // packets transmitted
+	TxRetry     uint8 // packets retransmitted
+	RxOK        uint8 // packets received with a valid CRC
+	RxFail      uint8 // packets received with a CRC error
+	FreeBuffers uint8 // free packet buffers
+}
+
+// GetInfo retrieves the BLE112's firmware and hardware versions, stores
+// them on the device struct, and returns them.
+func (device *Device) GetInfo() (Info, error) {
+	r, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_GET_INFO, NULL_DATA)
+	if err != nil {
+		return Info{}, err
+	}
+	p := r.Payload()
+	if len(p) < 12 {
+		return Info{}, fmt.Errorf("error getting info: not enough bytes")
+	}
+	info := Info{
+		Major:     binary.LittleEndian.Uint16(p[0:]),
+		Minor:     binary.LittleEndian.Uint16(p[2:]),
+		Patch:     binary.LittleEndian.Uint16(p[4:]),
+		Build:     binary.LittleEndian.Uint16(p[6:]),
+		LLVersion: binary.LittleEndian.Uint16(p[8:]),
+		Protocol:  p[10],
+		Hardware:  p[11],
+	}
+	device.Info = &info
+	return info, nil
+}
+
+// Counters reads, and so resets, the BLE112's packet counters.
+func (device *Device) Counters() (Counters, error) {
+	r, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_GET_COUNTERS, NULL_DATA)
+	if err != nil {
+		return Counters{}, err
+	}
+	p := r.Payload()
+	if len(p) < 5 {
+		return Counters{}, fmt.Errorf("error getting counters: not enough bytes")
+	}
+	return Counters{TxOK: p[0], TxRetry: p[1], RxOK: p[2], RxFail: p[3], FreeBuffers: p[4]}, nil
+}
@@ -0,0 +1,410 @@
+package ble112
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+// An EventType says whether a BLE112 was attached or detached.
+type EventType int
+
+// Manager event types.
+const (
+	Attached EventType = iota
+	Detached
+)
+
+func (t EventType) String() string {
+	if t == Attached {
+		return "attached"
+	}
+	return "detached"
+}
+
+// An Event reports a BLE112 being attached to or detached from the
+// machine.
+type Event struct {
+	Type   EventType
+	Device *Device
+}
+
+// DefaultPollInterval is how often a Manager lists ports when its
+// PollInterval is not set.
+const DefaultPollInterval = time.Second
+
+// A Manager watches for BLE112s being plugged in and unplugged. It probes
+// new ports as they appear, closes devices whose port disappears or whose
+// connection fails, and carries scans and advertisements over to a
+// dongle with the same mac address when it is plugged back in.
+type Manager struct {
+	// PollInterval is how often ports are listed. Zero means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+	// ProbeTimeout bounds how long a new port has to answer as a BLE112.
+	// Zero means the package's ProbeTimeout.
+	ProbeTimeout time.Duration
+	// Configure, if set, is called with each BLE112 as it is attached,
+	// before its advertisement is restored and scans resume on it.
+	Configure func(*Device)
+
+	paths func() ([]string, error)
+	open  PortOpener
+
+	mu      sync.Mutex
+	devices map[string]*Device // by port
+	failed  map[string]bool    // ports which did not answer as BLE112s
+	adverts map[beacon.MacAddress][]byte
+	notify  []chan<- Event
+	changed chan struct{} // closed and replaced whenever a device attaches
+	started bool
+	stop    chan struct{}
+	stopped chan struct{} // closed when the polling loop ends
+	once    sync.Once     // closes stop
+}
+
+// NewManager returns a Manager for the serial ports given by DevicePaths.
+func NewManager() *Manager {
+	return NewManagerWithPorts(DevicePaths, OpenSerialPort)
+}
+
+// NewManagerWithPorts returns a Manager which lists ports with paths and
+// connects to them with open.
+func NewManagerWithPorts(paths func() ([]string, error), open PortOpener) *Manager {
+	return &Manager{
+		paths:   paths,
+		open:    open,
+		devices: make(map[string]*Device),
+		failed:  make(map[string]bool),
+		adverts: make(map[beacon.MacAddress][]byte),
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Notify makes the Manager send attach and detach events to ch. Sends do
+// not block, so ch should be buffered; events are dropped when it is
+// full.
+func (m *Manager) Notify(ch chan<- Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notify = append(m.notify, ch)
+}
+
+// Start probes the ports present now, then keeps watching for changes in
+// the background until Stop is called. It must be called only once.
+func (m *Manager) Start() {
+	m.mu.Lock()
+	m.started = true
+	m.mu.Unlock()
+	m.poll()
+	go m.run()
+}
+
+// Stop stops watching for changes and closes every attached device.
+// Scans started through ScanDevice end. It may be called more than once,
+// and whether or not Start was.
+func (m *Manager) Stop() {
+	m.once.Do(func() { close(m.stop) })
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+	if started {
+		<-m.stopped
+	}
+	m.mu.Lock()
+	devices := m.devices
+	m.devices = make(map[string]*Device)
+	m.mu.Unlock()
+	for _, device := range devices {
+		device.Close()
+	}
+}
+
+// Devices returns the BLE112s currently attached.
+func (m *Manager) Devices() []*Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devices := make([]*Device, 0, len(m.devices))
+	for _, device := range m.devices {
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Device returns the attached BLE112 with the given mac address, or nil.
+func (m *Manager) Device(addr beacon.MacAddress) *Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.device(addr)
+}
+
+func (m *Manager) device(addr beacon.MacAddress) *Device {
+	for _, device := range m.devices {
+		if *device.MacAddress == addr {
+			return device
+		}
+	}
+	return nil
+}
+
+// Advertise makes the BLE112 with the given mac address advertise data,
+// as StartAdvertising does, now if it is attached and again whenever it
+// is reattached.
+func (m *Manager) Advertise(addr beacon.MacAddress, data []byte) error {
+	m.mu.Lock()
+	m.adverts[addr] = append([]byte(nil), data...)
+	device := m.device(addr)
+	m.mu.Unlock()
+	if device == nil {
+		return nil
+	}
+	return device.StartAdvertising(data)
+}
+
+// StopAdvertising stops the BLE112 with the given mac address advertising
+// and forgets its advertisement.
+func (m *Manager) StopAdvertising(addr beacon.MacAddress) error {
+	m.mu.Lock()
+	delete(m.adverts, addr)
+	device := m.device(addr)
+	m.mu.Unlock()
+	if device == nil {
+		return nil
+	}
+	return device.StopAdvertising()
+}
+
+// ScanDevice returns a beacon.ScanDevice which scans with the BLE112 with
+// the given mac address. Its scans wait for the BLE112 to be attached,
+// and resume when it is reattached after being unplugged, until done or
+// the Manager is stopped.
+func (m *Manager) ScanDevice(addr beacon.MacAddress) beacon.ScanDevice {
+	return &managedScanDevice{m: m, addr: addr}
+}
+
+func (m *Manager) run() {
+	defer close(m.stopped)
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.poll()
+		}
+	}
+}
+
+// poll detaches devices which have gone away and probes new ports.
+func (m *Manager) poll() {
+	paths, err := m.paths()
+	if err != nil {
+		return
+	}
+	present := make(map[string]bool, len(paths))
+	for _, port := range paths {
+		present[port] = true
+	}
+
+	m.mu.Lock()
+	var detached []*Device
+	for port, device := range m.devices {
+		if !present[port] || !device.connected() {
+			delete(m.devices, port)
+			detached = append(detached, device)
+		}
+	}
+	// a port which failed is probed again once it has gone away and come
+	// back
+	for port := range m.failed {
+		if !present[port] {
+			delete(m.failed, port)
+		}
+	}
+	var fresh []string
+	for _, port := range paths {
+		if m.devices[port] == nil && !m.failed[port] {
+			fresh = append(fresh, port)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, device := range detached {
+		device.Close()
+		m.emit(Event{Detached, device})
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	timeout := m.ProbeTimeout
+	if timeout == 0 {
+		timeout = ProbeTimeout
+	}
+	attached := probePorts(fresh, m.open, timeout)
+	m.mu.Lock()
+	for _, port := range fresh {
+		m.failed[port] = true
+	}
+	for _, device := range attached {
+		delete(m.failed, device.Port)
+	}
+	m.mu.Unlock()
+	for _, device := range attached {
+		m.attach(device)
+	}
+}
+
+func (m *Manager) attach(device *Device) {
+	if m.Configure != nil {
+		m.Configure(device)
+	}
+	m.mu.Lock()
+	data, advertising := m.adverts[*device.MacAddress]
+	m.mu.Unlock()
+	if advertising {
+		// a failure here shows up as a failed connection on the next poll
+		device.StartAdvertising(data)
+	}
+
+	m.mu.Lock()
+	m.devices[device.Port] = device
+	close(m.changed)
+	m.changed = make(chan struct{})
+	m.mu.Unlock()
+	m.emit(Event{Attached, device})
+}
+
+func (m *Manager) emit(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.notify {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// waitFor waits for the BLE112 with the given mac address to be attached
+// and returns it, or returns nil once done or the Manager is stopped. If
+// the attached device is previous, it is only returned after
+// PollInterval, so that a failing device is not retried in a tight loop.
+func (m *Manager) waitFor(addr beacon.MacAddress, previous *Device, done chan bool) *Device {
+	var retry <-chan time.Time
+	for {
+		m.mu.Lock()
+		device := m.device(addr)
+		changed := m.changed
+		m.mu.Unlock()
+		if device != nil && device != previous {
+			return device
+		}
+		if device != nil && retry == nil {
+			interval := m.PollInterval
+			if interval == 0 {
+				interval = DefaultPollInterval
+			}
+			retry = time.After(interval)
+		}
+		select {
+		case <-changed:
+		case <-retry:
+			return device
+		case <-done:
+			return nil
+		case <-m.stop:
+			return nil
+		}
+	}
+}
+
+type managedScanDevice struct {
+	m    *Manager
+	addr beacon.MacAddress
+}
+
+func (s *managedScanDevice) Scan(data chan beacon.ScanData, done chan bool) {
+	defer close(data)
+	var previous *Device
+	for {
+		device := s.m.waitFor(s.addr, previous, done)
+		if device == nil {
+			return
+		}
+		previous = device
+		if !forwardScan(device, data, done) {
+			return
+		}
+	}
+}
+
+// forwardScan scans with device, forwarding its ScanData to data, until
+// the device's scan ends or done. It returns false if done.
+func forwardScan(device *Device, data chan beacon.ScanData, done chan bool) bool {
+	scans := make(chan beacon.ScanData)
+	stop := make(chan bool)
+	go device.Scan(scans, stop)
+	for {
+		select {
+		case scan, more := <-scans:
+			if !more {
+				return true
+			}
+			select {
+			case data <- scan:
+				continue
+			case <-done:
+			}
+		case <-done:
+		}
+		close(stop)
+		for range scans {
+		}
+		return false
+	}
+}
+
+// probePorts connects to each port concurrently and returns the open
+// devices which answer as BLE112s within timeout.
+func probePorts(paths []string, open PortOpener, timeout time.Duration) []*Device {
+	found := make(chan *Device, len(paths))
+	for _, port := range paths {
+		go func(port string) {
+			result := make(chan *Device, 1)
+			go func() {
+				device, err := NewDeviceWithOpener(port, open)
+				if err != nil {
+					device = nil
+				}
+				result <- device
+			}()
+			select {
+			case device := <-result:
+				found <- device
+			case <-time.After(timeout):
+				found <- nil
+				// close the device if it eventually answers
+				if device := <-result; device != nil {
+					device.Close()
+				}
+			}
+		}(port)
+	}
+
+	var devices []*Device
+	for range paths {
+		if device := <-found; device != nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
@@ -0,0 +1,189 @@
+package ble112_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+// ports is a set of serial ports, each connected to an emulator or to
+// nothing, which can be changed as a test runs.
+type ports struct {
+	mu    sync.Mutex
+	ports map[string]*emulator.Emulator
+}
+
+func (p *ports) set(port string, e *emulator.Emulator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ports[port] = e
+}
+
+func (p *ports) remove(port string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.ports, port)
+}
+
+func (p *ports) paths() ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var paths []string
+	for port := range p.ports {
+		paths = append(paths, port)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (p *ports) open(port string) (io.ReadWriteCloser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.ports[port]; e != nil {
+		return e.Conn(), nil
+	}
+	return nil, errors.New("not a BLE112")
+}
+
+func nextEvent(t *testing.T, events chan ble112.Event) ble112.Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return ble112.Event{}
+}
+
+func nextScan(t *testing.T, data chan beacon.ScanData) beacon.ScanData {
+	select {
+	case scan := <-data:
+		return scan
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a scan")
+	}
+	return beacon.ScanData{}
+}
+
+func TestManager(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	p := &ports{ports: map[string]*emulator.Emulator{
+		"/dev/ttyACM0": e,
+		"/dev/ttyS0":   nil,
+	}}
+	m := ble112.NewManagerWithPorts(p.paths, p.open)
+	m.PollInterval = 10 * time.Millisecond
+	m.ProbeTimeout = 200 * time.Millisecond
+	events := make(chan ble112.Event, 10)
+	m.Notify(events)
+	m.Start()
+	defer m.Stop()
+
+	event := nextEvent(t, events)
+	if event.Type != ble112.Attached || event.Device.Port != "/dev/ttyACM0" {
+		t.Fatalf("got %v %v; expected /dev/ttyACM0 attached", event.Type, event.Device)
+	}
+	if devices := m.Devices(); len(devices) != 1 {
+		t.Errorf("got %v devices; expected only the BLE112", len(devices))
+	}
+	addr := emulator.DefaultAddress
+	if m.Device(addr) != event.Device {
+		t.Errorf("device not found by mac address")
+	}
+
+	ad := emulator.MfgData(0x0118, altBeaconAd)
+	if err := m.Advertise(addr, ad[3:]); err != nil { // without the flags
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := make(chan beacon.ScanData)
+	done := make(chan bool)
+	go m.ScanDevice(addr).Scan(data, done)
+	if scan := nextScan(t, data); scan.Device != beaconAddr.String() {
+		t.Errorf("got scan from %v; expected %v", scan.Device, beaconAddr)
+	}
+
+	// unplug the dongle mid-scan
+	p.remove("/dev/ttyACM0")
+	e.Unplug()
+	for {
+		event = nextEvent(t, events)
+		if event.Type == ble112.Detached {
+			break
+		}
+	}
+	if event.Device.Port != "/dev/ttyACM0" {
+		t.Errorf("got %v detached; expected /dev/ttyACM0", event.Device.Port)
+	}
+	if len(m.Devices()) != 0 {
+		t.Errorf("got %v devices; expected none", len(m.Devices()))
+	}
+
+	// plug it back in, at a different port
+	p.set("/dev/ttyACM1", e)
+	event = nextEvent(t, events)
+	if event.Type != ble112.Attached || event.Device.Port != "/dev/ttyACM1" {
+		t.Fatalf("got %v %v; expected /dev/ttyACM1 attached", event.Type, event.Device.Port)
+	}
+	if got := e.AdvData(); !bytes.Equal(got, ad) {
+		t.Errorf("got adv data %x; expected it to be restored", got)
+	}
+	// at most one scan from before the unplug is still in flight
+	nextScan(t, data)
+	nextScan(t, data)
+	if !e.Discovering() {
+		t.Error("scan did not resume on the reattached dongle")
+	}
+
+	close(done)
+	for range data {
+	}
+}
+
+func TestManagerStop(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	p := &ports{ports: map[string]*emulator.Emulator{"/dev/ttyACM0": e}}
+	m := ble112.NewManagerWithPorts(p.paths, p.open)
+	m.PollInterval = 10 * time.Millisecond
+	m.Start()
+
+	// a scan for a dongle which is never attached ends with the manager
+	data := make(chan beacon.ScanData)
+	finished := make(chan bool)
+	go func() {
+		m.ScanDevice(beacon.MacAddress{1, 2, 3}).Scan(data, make(chan bool))
+		finished <- true
+	}()
+	m.Stop()
+	m.Stop() // stopping twice is harmless
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan did not end when the manager stopped")
+	}
+	if len(m.Devices()) != 0 {
+		t.Errorf("got %v devices after stopping; expected none", len(m.Devices()))
+	}
+}
+
+func TestManagerStopUnstarted(t *testing.T) {
+	m := ble112.NewManagerWithPorts((&ports{}).paths, (&ports{}).open)
+	stopped := make(chan bool)
+	go func() {
+		m.Stop()
+		m.Stop()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a manager which was never started")
+	}
+}
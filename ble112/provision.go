@@ -0,0 +1,191 @@
+package ble112
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon/advertiser"
+)
+
+// Persistent store keys holding a provisioned beacon. This package only
+// writes and reads them: advertising standalone, without a host, needs
+// custom BLE112 firmware, not part of this repository, which reads them
+// at boot and advertises as they say. The stock BGAPI firmware ignores
+// them.
+//
+//	PSKeyProvisioning   "BP" followed by the schema version, 1. It is
+//	                    written last, so a BLE112 whose provisioning was
+//	                    interrupted is not taken as provisioned.
+//	PSKeyAdvParameters  the gap_set_adv_parameters payload (minimum and
+//	                    maximum interval in 0.625ms units, uint16 little
+//	                    endian, then the channel map), a flags byte (bit 0
+//	                    non-connectable, bit 1 transmit power set), the
+//	                    hardware_set_txpower level and the transmit power
+//	                    in dBm as an int8.
+//	PSKeyAdvData        the AD structures advertised after the flags, up
+//	                    to MaxProvisionedData bytes, to be advertised as
+//	                    user data behind flags of 0x06.
+const (
+	PSKeyProvisioning  = 0x8000
+	PSKeyAdvParameters = 0x8001
+	PSKeyAdvData       = 0x8002
+)
+
+// ProvisioningVersion is the version of the schema written by Provision.
+const ProvisioningVersion = 1
+
+// MaxProvisionedData is the most advertising data that fits after the
+// flags in a 31-byte advertisement.
+const MaxProvisionedData = advertiser.MaxPayloadLength
+
+const (
+	provisionNonConnectable = 1 << iota
+	provisionTxPower
+)
+
+var provisioningMagic = []byte{'B', 'P', ProvisioningVersion}
+
+// ErrNotProvisioned is returned by LoadProvisioning when the BLE112 holds
+// no provisioned beacon.
+var ErrNotProvisioned = errors.New("ble112: not provisioned")
+
+// ErrProvisioningMismatch is returned by Provision when the keys read back
+// from the persistent store differ from those written.
+var ErrProvisioningMismatch = errors.New("ble112: provisioning read back differs")
+
+// A Provisioning is a beacon for a BLE112 running firmware which reads
+// the keys above to advertise standalone: the advertising data, as passed
+// to StartAdvertising, and how to advertise it.
+type Provisioning struct {
+	Data    []byte
+	Options advertiser.AdvertiseOptions
+}
+
+// MfgDataProvisioning returns a Provisioning advertising the manufacturer
+// data in ad, as generated by beacon.Parser.GenerateAd, as
+// AdvertiseMfgData does.
+func MfgDataProvisioning(id uint16, ad advertiser.Advertisement, opts advertiser.AdvertiseOptions) *Provisioning {
+	return &Provisioning{Data: mfgData(id, ad), Options: opts}
+}
+
+// ServiceDataProvisioning returns a Provisioning advertising the service
+// data in ad, as generated by beacon.Parser.GenerateAd, as
+// AdvertiseServiceData does.
+func ServiceDataProvisioning(id uint16, ad advertiser.Advertisement, opts advertiser.AdvertiseOptions) *Provisioning {
+	return &Provisioning{Data: serviceData(id, ad), Options: opts}
+}
+
+// Keys encodes p as the persistent store keys described above.
+func (p *Provisioning) Keys() (map[uint16][]byte, error) {
+	if err := p.Options.Validate(); err != nil {
+		return nil, fmt.Errorf("ble112: %v", err)
+	}
+	if len(p.Data) > MaxProvisionedData {
+		return nil, fmt.Errorf("ble112: %d bytes of advertising data exceeds %d", len(p.Data), MaxProvisionedData)
+	}
+	var flags, level byte
+	var dBm int8
+	if p.Options.NonConnectable {
+		flags |= provisionNonConnectable
+	}
+	if p.Options.TxPower != nil {
+		flags |= provisionTxPower
+		dBm = *p.Options.TxPower
+		level = txPowerLevel(dBm)
+	}
+	return map[uint16][]byte{
+		PSKeyProvisioning:  provisioningMagic,
+		PSKeyAdvParameters: append(advParameters(p.Options), flags, level, byte(dBm)),
+		PSKeyAdvData:       append([]byte(nil), p.Data...),
+	}, nil
+}
+
+// ParseProvisioning decodes the persistent store keys written by
+// Provision.
+func ParseProvisioning(keys map[uint16][]byte) (*Provisioning, error) {
+	magic, ok := keys[PSKeyProvisioning]
+	if !ok {
+		return nil, ErrNotProvisioned
+	}
+	if len(magic) != 3 || !bytes.Equal(magic[:2], provisioningMagic[:2]) {
+		return nil, fmt.Errorf("ble112: ps key %#04x does not hold a provisioning", PSKeyProvisioning)
+	}
+	if magic[2] != ProvisioningVersion {
+		return nil, fmt.Errorf("ble112: unsupported provisioning version %d", magic[2])
+	}
+	params := keys[PSKeyAdvParameters]
+	if len(params) != 8 {
+		return nil, fmt.Errorf("ble112: ps key %#04x holds %d bytes; expected 8", PSKeyAdvParameters, len(params))
+	}
+	unit := 625 * time.Microsecond
+	p := &Provisioning{
+		Data: append([]byte(nil), keys[PSKeyAdvData]...),
+		Options: advertiser.AdvertiseOptions{
+			MinInterval:    time.Duration(uint16(params[0])|uint16(params[1])<<8) * unit,
+			MaxInterval:    time.Duration(uint16(params[2])|uint16(params[3])<<8) * unit,
+			Channels:       advertiser.ChannelMap(params[4]),
+			NonConnectable: params[5]&provisionNonConnectable != 0,
+		},
+	}
+	if params[5]&provisionTxPower != 0 {
+		p.Options.TxPower = advertiser.TxPower(int8(params[7]))
+	}
+	return p, nil
+}
+
+// Provision writes p to the BLE112's persistent store, then reads it back
+// to verify it. The BLE112 keeps it across resets and power cycles.
+func (device *Device) Provision(p *Provisioning) error {
+	keys, err := p.Keys()
+	if err != nil {
+		return err
+	}
+	// the magic goes last, so that an interrupted provisioning is not
+	// mistaken for a complete one
+	if err := device.PSErase(PSKeyProvisioning); err != nil {
+		return err
+	}
+	for _, key := range []uint16{PSKeyAdvParameters, PSKeyAdvData, PSKeyProvisioning} {
+		if err := device.PSSave(key, keys[key]); err != nil {
+			return err
+		}
+	}
+	for key, value := range keys {
+		stored, err := device.PSLoad(key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(stored, value) {
+			return fmt.Errorf("%w: ps key %#04x holds %x; expected %x", ErrProvisioningMismatch, key, stored, value)
+		}
+	}
+	return nil
+}
+
+// LoadProvisioning reads the beacon provisioned on the BLE112.
+func (device *Device) LoadProvisioning() (*Provisioning, error) {
+	keys := make(map[uint16][]byte)
+	for _, key := range []uint16{PSKeyProvisioning, PSKeyAdvParameters, PSKeyAdvData} {
+		value, err := device.PSLoad(key)
+		var result Result
+		if key == PSKeyProvisioning && errors.As(err, &result) {
+			return nil, ErrNotProvisioned
+		} else if err != nil {
+			return nil, err
+		}
+		keys[key] = value
+	}
+	return ParseProvisioning(keys)
+}
+
+// Deprovision erases the provisioned beacon from the BLE112.
+func (device *Device) Deprovision() error {
+	for _, key := range []uint16{PSKeyProvisioning, PSKeyAdvParameters, PSKeyAdvData} {
+		if err := device.PSErase(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
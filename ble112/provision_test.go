@@ -0,0 +1,121 @@
+package ble112_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon/advertiser"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func TestDevicePS(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	for key, value := range map[uint16][]byte{0x8010: {1, 2, 3}, 0x8011: []byte("value")} {
+		if err := device.PSSave(key, value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if value, err := device.PSLoad(0x8010); err != nil || !bytes.Equal(value, []byte{1, 2, 3}) {
+		t.Errorf("got %v, %v; expected the saved value", value, err)
+	}
+	keys, err := device.PSDump()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 || string(keys[0x8011]) != "value" {
+		t.Errorf("got %v; expected both keys", keys)
+	}
+
+	if err := device.PSErase(0x8010); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := device.PSLoad(0x8010); !errors.Is(err, ble112.ErrInvalidParameter) {
+		t.Errorf("got %v; expected the key to be gone", err)
+	}
+	if err := device.PSSave(0x7fff, nil); err == nil {
+		t.Error("expected a key outside the application range to be refused")
+	}
+	if err := device.PSSave(0x8000, make([]byte, 33)); err == nil {
+		t.Error("expected a value over 32 bytes to be refused")
+	}
+}
+
+func TestDeviceProvision(t *testing.T) {
+	e := newEmulator(emulator.Config{Standalone: true})
+	device := newDevice(t, e)
+
+	if _, err := device.LoadProvisioning(); !errors.Is(err, ble112.ErrNotProvisioned) {
+		t.Fatalf("got %v; expected ErrNotProvisioned", err)
+	}
+	opts := advertiser.AdvertiseOptions{
+		MinInterval:    500 * time.Millisecond,
+		MaxInterval:    time.Second,
+		Channels:       advertiser.Channel37 | advertiser.Channel39,
+		NonConnectable: true,
+		TxPower:        advertiser.TxPower(-6),
+	}
+	p := ble112.MfgDataProvisioning(0x0118, altBeaconAd, opts)
+	if err := device.Provision(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ps := e.PS()
+	if !bytes.Equal(ps[ble112.PSKeyProvisioning], []byte{'B', 'P', 1}) {
+		t.Errorf("got %x; expected the provisioning magic", ps[ble112.PSKeyProvisioning])
+	}
+	if !bytes.Equal(ps[ble112.PSKeyAdvParameters], []byte{0x20, 0x03, 0x40, 0x06, 0x05, 0x03, 0x09, 0xfa}) {
+		t.Errorf("got %x; expected the schema's advertising parameters", ps[ble112.PSKeyAdvParameters])
+	}
+
+	loaded, err := device.LoadProvisioning()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, p) {
+		t.Errorf("got %+v; expected %+v", loaded, p)
+	}
+
+	// power cycle the standalone BLE112 with no host attached
+	e.Unplug()
+	if got, expected := e.AdvData(), emulator.MfgData(0x0118, altBeaconAd); !bytes.Equal(got, expected) {
+		t.Errorf("got adv data %x; expected the provisioned beacon", got)
+	}
+	if _, connectable := e.Mode(); connectable != ble112.BG_GAP_NON_CONNECTABLE {
+		t.Errorf("got connectable mode %v; expected non-connectable", connectable)
+	}
+
+	device = newDevice(t, e)
+	if err := device.Deprovision(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(e.PS()) != 0 {
+		t.Errorf("got %v; expected the provisioning erased", e.PS())
+	}
+}
+
+func TestDeviceProvisionErrors(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	e.Ignore("flash_ps_save", true)
+	device.Timeout = 50 * time.Millisecond
+	p := ble112.MfgDataProvisioning(0x0118, altBeaconAd, advertiser.AdvertiseOptions{})
+	if err := device.Provision(p); !errors.Is(err, ble112.ErrCommandTimeout) {
+		t.Errorf("got %v; expected the save to time out", err)
+	}
+	if _, err := device.LoadProvisioning(); !errors.Is(err, ble112.ErrNotProvisioned) {
+		t.Errorf("got %v; expected an interrupted provisioning to be ignored", err)
+	}
+
+	e.Ignore("flash_ps_save", false)
+	device.Timeout = 0
+	p.Data = make([]byte, ble112.MaxProvisionedData+1)
+	if err := device.Provision(p); err == nil {
+		t.Error("expected advertising data too long for the flags to be refused")
+	}
+}
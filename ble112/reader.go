@@ -0,0 +1,114 @@
+package ble112
+
+import (
+	"io"
+	"sync"
+)
+
+const headerLength = 4
+
+// FramingStats counts what a Reader had to do to keep the BGAPI stream
+// framed.
+type FramingStats struct {
+	// Frames is the number of valid frames returned.
+	Frames uint64
+	// InvalidHeaders is the number of candidate headers that did not
+	// describe a known message.
+	InvalidHeaders uint64
+	// InvalidPayloads is the number of frames whose payload did not agree
+	// with their header.
+	InvalidPayloads uint64
+	// DroppedBytes is the number of bytes discarded while resynchronising.
+	DroppedBytes uint64
+	// Resyncs is the number of times the stream lost and regained framing.
+	Resyncs uint64
+}
+
+// A Reader splits a BGAPI byte stream into frames. It accumulates partial
+// reads, validates every header against the known messages and, when the
+// stream is corrupted, discards bytes until it finds a valid frame again.
+type Reader struct {
+	r        io.Reader
+	buf      []byte
+	chunk    []byte
+	dropping bool
+
+	mu    sync.Mutex
+	stats FramingStats
+}
+
+// NewReader returns a Reader that frames the BGAPI stream read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, chunk: make([]byte, 256)}
+}
+
+// Stats returns the framing counters accumulated so far.
+func (fr *Reader) Stats() FramingStats {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.stats
+}
+
+// ReadResponse returns the next valid frame from the stream. Corrupted
+// data is skipped and counted in Stats; only errors from the underlying
+// reader are returned.
+func (fr *Reader) ReadResponse() (*Response, error) {
+	for {
+		if err := fr.fill(headerLength); err != nil {
+			return nil, err
+		}
+		if !validHeader(fr.buf) {
+			fr.drop(func(s *FramingStats) { s.InvalidHeaders++ })
+			continue
+		}
+
+		n := headerLength + payloadLength(fr.buf)
+		if err := fr.fill(n); err != nil {
+			return nil, err
+		}
+		if !validPayload(fr.buf[:headerLength], fr.buf[headerLength:n]) {
+			fr.drop(func(s *FramingStats) { s.InvalidPayloads++ })
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, fr.buf)
+		fr.buf = fr.buf[n:]
+		fr.dropping = false
+		fr.count(func(s *FramingStats) { s.Frames++ })
+		return &Response{data}, nil
+	}
+}
+
+// fill reads until at least n bytes are buffered.
+func (fr *Reader) fill(n int) error {
+	for len(fr.buf) < n {
+		count, err := fr.r.Read(fr.chunk)
+		fr.buf = append(fr.buf, fr.chunk[:count]...)
+		if err != nil && len(fr.buf) < n {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop discards the first buffered byte so the next one can be tried as
+// the start of a header.
+func (fr *Reader) drop(reason func(*FramingStats)) {
+	fr.buf = fr.buf[1:]
+	resync := !fr.dropping
+	fr.dropping = true
+	fr.count(func(s *FramingStats) {
+		reason(s)
+		s.DroppedBytes++
+		if resync {
+			s.Resyncs++
+		}
+	})
+}
+
+func (fr *Reader) count(update func(*FramingStats)) {
+	fr.mu.Lock()
+	update(&fr.stats)
+	fr.mu.Unlock()
+}
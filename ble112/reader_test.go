@@ -0,0 +1,90 @@
+package ble112
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+var (
+	// helloResponse is the response to system_hello
+	helloResponse = []byte{0x00, 0x00, 0x00, 0x01}
+	// addressResponse is the response to system_address_get
+	addressResponse = []byte{0x00, 0x06, 0x00, 0x02, 0xd5, 0x47, 0x14, 0x80, 0x07, 0x00}
+	// infoResponse is the response to system_get_info from firmware
+	// 1.3.2 build 122
+	infoResponse = []byte{0x00, 0x0c, 0x00, 0x08, 0x01, 0x00, 0x03, 0x00, 0x02, 0x00, 0x7a, 0x00, 0x06, 0x00, 0x01, 0x01}
+	// scanEvent is a gap_scan_response event carrying an altbeacon
+	scanEvent = []byte{
+		0x80, 0x2a, 0x06, 0x00, 0xc4, 0x00, 0xd5, 0x47, 0x14, 0x80, 0x07, 0x00, 0x00, 0xff, 0x1f,
+		0x02, 0x01, 0x06, 0x1b, 0xff, 0x18, 0x01, 0xbe, 0xac, 0xe8, 0x58, 0xfc, 0x8a, 0x37, 0x2b, 0x4b, 0xef,
+		0xa0, 0x53, 0x93, 0xf9, 0x8c, 0xd4, 0xe1, 0x77, 0x00, 0x01, 0x00, 0x01, 0x40, 0x20,
+	}
+)
+
+func readAll(t *testing.T, r io.Reader) ([]*Response, FramingStats) {
+	fr := NewReader(r)
+	var responses []*Response
+	for {
+		resp, err := fr.ReadResponse()
+		if err == io.EOF {
+			return responses, fr.Stats()
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		responses = append(responses, resp)
+	}
+}
+
+func stream(frames ...[]byte) io.Reader {
+	return bytes.NewReader(bytes.Join(frames, nil))
+}
+
+func TestReaderPartialReads(t *testing.T) {
+	responses, stats := readAll(t, iotest.OneByteReader(stream(helloResponse, scanEvent, addressResponse)))
+	if len(responses) != 3 {
+		t.Fatalf("got %v frames; expected 3", len(responses))
+	}
+	if !bytes.Equal(responses[1].Data, scanEvent) {
+		t.Errorf("got %v; expected %v", responses[1], scanEvent)
+	}
+	if stats.Frames != 3 || stats.DroppedBytes != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReaderHighLengthBits(t *testing.T) {
+	// a system_debug event with a 255 byte array has a 256 byte payload
+	debug := append([]byte{0x81, 0x00, 0x00, 0x01, 0xff}, make([]byte, 0xff)...)
+	responses, _ := readAll(t, stream(debug, helloResponse))
+	if len(responses) != 2 || len(responses[0].Data) != len(debug) {
+		t.Fatalf("got %v; expected a debug event and a hello response", responses)
+	}
+
+	// a length that does not fit the message is rejected
+	bogus := []byte{0x81, 0x05, 0x00, 0x01}
+	responses, stats := readAll(t, stream(bogus, helloResponse))
+	if len(responses) != 1 || !bytes.Equal(responses[0].Data, helloResponse) {
+		t.Errorf("got %v; expected only the hello response", responses)
+	}
+	if stats.InvalidHeaders == 0 {
+		t.Errorf("expected invalid headers to be counted, got %+v", stats)
+	}
+}
+
+func TestReaderResynchronises(t *testing.T) {
+	// drop the first byte of the scan event, as a lossy serial line would
+	corrupt := scanEvent[1:]
+	responses, stats := readAll(t, stream(helloResponse, corrupt, scanEvent, addressResponse))
+	if len(responses) != 3 {
+		t.Fatalf("got %v frames; expected 3: %v", len(responses), responses)
+	}
+	if !bytes.Equal(responses[1].Data, scanEvent) || !bytes.Equal(responses[2].Data, addressResponse) {
+		t.Errorf("frames after corruption were misparsed: %v", responses)
+	}
+	if stats.Resyncs != 1 || stats.DroppedBytes != uint64(len(corrupt)) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -11,36 +11,110 @@ type Response struct {
 	Data []byte
 }
 
+// Class returns the message class of the response.
+func (r *Response) Class() byte {
+	return r.Data[2]
+}
+
+// Command returns the command or event ID of the response.
+func (r *Response) Command() byte {
+	return r.Data[3]
+}
+
+// Payload returns the response data following the BGAPI header.
+func (r *Response) Payload() []byte {
+	return r.Data[headerLength:]
+}
+
 func (r *Response) IsEvent() bool {
-	return r.Data[0] == BG_EVENT
+	return r.Data[0]&BG_EVENT != 0
 }
 
 func (r *Response) IsGapScan() bool {
 	return r.Data[2] == BG_MSG_CLASS_GAP && r.Data[3] == byte(0)
 }
 
+// AD structure types carrying beacon advertisements.
+const (
+	adTypeServiceData = byte(0x16)
+	adTypeMfgData     = byte(0xff)
+)
+
+// Packet types reported by gap_scan_response events.
+const (
+	PacketConnectable    = uint8(0)
+	PacketNonConnectable = uint8(2)
+	PacketScanResponse   = uint8(4)
+	PacketDiscoverable   = uint8(6)
+)
+
+// AdvertisingData returns the AD structures carried by a scan response
+// event.
+func (r *Response) AdvertisingData() []byte {
+	if len(r.Data) < 15 {
+		return nil
+	}
+	return r.Data[15:]
+}
+
+// adStructure returns the contents of the first AD structure of the
+// given type, or nil if there is none.
+func (r *Response) adStructure(adType byte) []byte {
+	data := r.AdvertisingData()
+	for len(data) > 1 {
+		n := int(data[0])
+		if n == 0 || n >= len(data) {
+			return nil
+		}
+		if data[1] == adType {
+			return data[2 : n+1]
+		}
+		data = data[n+1:]
+	}
+	return nil
+}
+
 func (r *Response) IsMfgAd() bool {
-	return len(r.Data) > 20 && r.Data[19] == byte(0xff)
+	return len(r.adStructure(adTypeMfgData)) > 0
 }
 
 func (r *Response) IsServiceAd() bool {
-	return len(r.Data) > 24 && r.Data[19] == byte(0x03)
+	return len(r.adStructure(adTypeServiceData)) > 0
 }
 
 func (r *Response) IsAdvertisement() bool {
 	return len(r.Data) > 20 && r.IsEvent() && r.IsGapScan() && (r.IsMfgAd() || r.IsServiceAd())
 }
 
+// IsScanResponse returns true if the event reports a scan response packet
+// rather than an advertisement.
+func (r *Response) IsScanResponse() bool {
+	return r.IsEvent() && r.IsGapScan() && r.PacketType() == PacketScanResponse
+}
+
+// AdData returns the manufacturer data, including the company id, or the
+// service data, including the service uuid, of a scan response event.
 func (r *Response) AdData() []byte {
-	if r.IsMfgAd() {
-		return r.Data[20:]
-	} else if r.IsServiceAd() {
-		return r.Data[24:]
+	if ad := r.adStructure(adTypeMfgData); len(ad) > 0 {
+		return ad
+	} else if ad := r.adStructure(adTypeServiceData); len(ad) > 0 {
+		return ad
 	} else {
 		return []byte{}
 	}
 }
 
+// PacketType returns the packet type of a scan response event.
+func (r *Response) PacketType() uint8 {
+	return r.Data[5]
+}
+
+// AddressType returns the address type of the sender of a scan response
+// event: 0 for public, 1 for random.
+func (r *Response) AddressType() uint8 {
+	return r.Data[12]
+}
+
 func (r *Response) MacAddress() *beacon.MacAddress {
 	var a beacon.MacAddress
 	copy(a[:], r.Data[6:12])
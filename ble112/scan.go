@@ -0,0 +1,120 @@
+package ble112
+
+import (
+	"fmt"
+	"time"
+)
+
+// A DiscoverMode selects which advertisers gap_discover reports.
+type DiscoverMode byte
+
+const (
+	// DiscoverAll reports every advertiser, discoverable or not. It is
+	// what beacon scanning needs, and the default.
+	DiscoverAll DiscoverMode = iota
+	// DiscoverGeneric reports advertisers in limited or general
+	// discoverable mode.
+	DiscoverGeneric
+	// DiscoverLimited reports only advertisers in limited discoverable
+	// mode.
+	DiscoverLimited
+)
+
+var discoverModes = map[DiscoverMode]byte{
+	DiscoverAll:     BG_GAP_DISCOVER_ALL,
+	DiscoverGeneric: 1,
+	DiscoverLimited: 0,
+}
+
+// A ScanPolicy selects which advertisers a scan reports.
+type ScanPolicy byte
+
+const (
+	// ScanAll reports every advertiser. It is the default.
+	ScanAll ScanPolicy = iota
+	// ScanWhitelist reports only advertisers on the BLE112's whitelist.
+	ScanWhitelist
+)
+
+// Scan interval and window limits from the Bluetooth Core specification.
+const (
+	MinScanInterval = 2500 * time.Microsecond
+	MaxScanInterval = 10240 * time.Millisecond
+)
+
+// ScanOptions configures how a BLE112 scans. The zero value scans
+// passively for every advertiser with a 125ms interval and window.
+type ScanOptions struct {
+	// Interval is how often the BLE112 starts listening on the next
+	// advertising channel. Zero means 125ms.
+	Interval time.Duration
+	// Window is how long it listens each Interval. Zero means Interval.
+	Window time.Duration
+	// Active requests scan responses from advertisers, which are
+	// delivered as separate ScanData with AdvType PacketScanResponse.
+	Active bool
+	// Mode selects which advertisers are reported.
+	Mode DiscoverMode
+	// FilterDuplicates makes the BLE112 report each advertiser only once
+	// per scan.
+	FilterDuplicates bool
+	// Policy selects which advertisers are reported. SetWhitelist sets
+	// it.
+	Policy ScanPolicy
+}
+
+// Validate returns an error if the options are outside the limits of the
+// Bluetooth specification.
+func (o ScanOptions) Validate() error {
+	interval, window := o.timing()
+	if interval < MinScanInterval || interval > MaxScanInterval {
+		return fmt.Errorf("ble112: scan interval %v outside %v-%v", interval, MinScanInterval, MaxScanInterval)
+	}
+	if window < MinScanInterval || window > interval {
+		return fmt.Errorf("ble112: scan window %v outside %v-%v", window, MinScanInterval, interval)
+	}
+	if _, ok := discoverModes[o.Mode]; !ok {
+		return fmt.Errorf("ble112: invalid discover mode %d", o.Mode)
+	}
+	if o.Policy > ScanWhitelist {
+		return fmt.Errorf("ble112: invalid scan policy %d", o.Policy)
+	}
+	return nil
+}
+
+func (o ScanOptions) timing() (interval time.Duration, window time.Duration) {
+	interval, window = o.Interval, o.Window
+	if interval == 0 {
+		interval = 125 * time.Millisecond
+	}
+	if window == 0 {
+		window = interval
+	}
+	return
+}
+
+// scanParameters encodes the options for gap_set_scan_parameters.
+func (o ScanOptions) scanParameters() []byte {
+	interval, window := o.timing()
+	i, w := units(interval), units(window)
+	active := byte(0)
+	if o.Active {
+		active = 1
+	}
+	return []byte{byte(i), byte(i >> 8), byte(w), byte(w >> 8), active}
+}
+
+// filtering encodes the options for gap_set_filtering.
+func (o ScanOptions) filtering() []byte {
+	duplicates := byte(0)
+	if o.FilterDuplicates {
+		duplicates = 1
+	}
+	return []byte{byte(o.Policy), 0, duplicates}
+}
+
+// units converts a duration into the 0.625ms units BGAPI uses for scan
+// and advertising timing.
+func units(d time.Duration) uint16 {
+	return uint16(d / (625 * time.Microsecond))
+}
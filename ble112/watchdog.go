@@ -0,0 +1,264 @@
+package ble112
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBootTimeout is how long Reset waits for the BLE112 to boot.
+const DefaultBootTimeout = 5 * time.Second
+
+// Reset restarts the BLE112 with system_reset and waits for it to boot,
+// then restores the scan or advertisement it was running. A BLE112 on
+// USB may drop off the bus as it restarts; Reset reconnects to it, but
+// scans started with Scan end when the connection drops.
+func (device *Device) Reset() error {
+	return device.reset(DefaultBootTimeout)
+}
+
+func (device *Device) reset(timeout time.Duration) error {
+	err := device.reboot("system_reset", BG_MSG_CLASS_SYSTEM, BG_RESET, []byte{0}, BG_MSG_CLASS_SYSTEM, timeout)
+	if err != nil {
+		return err
+	}
+	return device.restore()
+}
+
+// reboot sends a command which restarts the BLE112, then waits up to
+// timeout for the boot event of bootClass: system_boot, or dfu_boot if it
+// restarts into DFU mode. A BLE112 which drops off the bus as it restarts
+// is reconnected to, which counts as booting.
+func (device *Device) reboot(name string, class byte, id byte, payload []byte, bootClass byte, timeout time.Duration) error {
+	c, err := device.currentConn()
+	if err != nil {
+		return &CommandError{name, err}
+	}
+	events := c.subscribe()
+	defer c.unsubscribe(events)
+
+	device.cmdMu.Lock()
+	err = c.write(class, id, payload)
+	device.cmdMu.Unlock()
+	if err != nil {
+		return &CommandError{name, err}
+	}
+
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r, more := <-events.events:
+			if !more {
+				// the bootloader does not answer system_hello
+				return device.reconnect(name, deadline, bootClass == BG_MSG_CLASS_SYSTEM)
+			} else if r.Class() == bootClass && r.Command() == 0 {
+				return nil
+			}
+		case <-timer.C:
+			return &CommandError{name, ErrCommandTimeout}
+		}
+	}
+}
+
+// reconnect reopens the connection to a BLE112 which dropped it while
+// restarting, retrying until deadline. If check is set, the BLE112 must
+// also answer system_hello.
+func (device *Device) reconnect(name string, deadline time.Time, check bool) error {
+	for {
+		device.mu.Lock()
+		closed := device.conn == nil
+		device.mu.Unlock()
+		if closed {
+			// Close was called while resetting
+			return &CommandError{name, ErrClosed}
+		}
+		err := device.Open()
+		if err == nil && check {
+			err = device.Health()
+		}
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &CommandError{name, err}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// restore restarts the scan or advertisement which was running before the
+// BLE112 was reset, with the random address and whitelist it had.
+func (device *Device) restore() error {
+	device.mu.Lock()
+	scanning, advertising, random := device.scanning, device.advertising, device.randomAddress
+	device.mu.Unlock()
+	// the whitelist cannot be changed while advertising or scanning
+	if err := device.restoreWhitelist(); err != nil {
+		return err
+	}
+	if random != nil {
+		if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_NONRESOLVABLE, random[:]); err != nil {
+			return err
+		}
+	}
+	if advertising != nil {
+		if err := device.sendAdvertisement(advertising); err != nil {
+			return err
+		}
+	}
+	if scanning {
+		return device.StartScan()
+	}
+	return nil
+}
+
+// DefaultWatchdogTimeout is how long a Watchdog waits for an event when
+// its Timeout is not set.
+const DefaultWatchdogTimeout = 30 * time.Second
+
+// An Incident describes a reset made by a Watchdog.
+type Incident struct {
+	Device *Device
+	// Idle is how long the BLE112 had gone without sending an event.
+	Idle time.Duration
+	// Err is nil if the BLE112 recovered, or why it did not.
+	Err error
+}
+
+// WatchdogStats count the resets made by a Watchdog.
+type WatchdogStats struct {
+	Resets    uint64 // resets after which the BLE112 recovered
+	Failures  uint64 // resets after which it did not
+	LastReset time.Time
+}
+
+// A Watchdog resets a BLE112 which has stopped sending events. BLE112s
+// occasionally stop reporting advertisements while still answering
+// commands; a scanning BLE112 which sends no events for Timeout is reset.
+// One which is not scanning is only reset if it also stops answering
+// system_hello.
+//
+// Scanning in a place with no advertisers also produces no events, so
+// Timeout should be well beyond the longest expected quiet spell.
+type Watchdog struct {
+	// Timeout is how long the BLE112 may go without sending an event.
+	// Zero means DefaultWatchdogTimeout.
+	Timeout time.Duration
+	// BootTimeout bounds each reset. Zero means DefaultBootTimeout.
+	BootTimeout time.Duration
+	// OnReset, if set, is called after each reset.
+	OnReset func(Incident)
+
+	device  *Device
+	mu      sync.Mutex // guards stats and started
+	stats   WatchdogStats
+	started bool
+	lastOK  time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once // closes stop
+}
+
+// NewWatchdog returns a Watchdog for the given BLE112. Call Start to start
+// watching it.
+func NewWatchdog(device *Device) *Watchdog {
+	return &Watchdog{
+		device:  device,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start starts watching the BLE112 in the background until Stop is
+// called. It must be called only once.
+func (w *Watchdog) Start() {
+	w.mu.Lock()
+	w.started = true
+	w.mu.Unlock()
+	w.lastOK = time.Now()
+	go w.run()
+}
+
+// Stop stops watching the BLE112. It may be called more than once, and
+// whether or not Start was.
+func (w *Watchdog) Stop() {
+	w.once.Do(func() { close(w.stop) })
+	w.mu.Lock()
+	started := w.started
+	w.mu.Unlock()
+	if started {
+		<-w.stopped
+	}
+}
+
+// Stats returns the Watchdog's counters.
+func (w *Watchdog) Stats() WatchdogStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *Watchdog) timeout() time.Duration {
+	if w.Timeout == 0 {
+		return DefaultWatchdogTimeout
+	}
+	return w.Timeout
+}
+
+func (w *Watchdog) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.timeout() / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check resets the BLE112 if it has been idle for too long.
+func (w *Watchdog) check() {
+	device := w.device
+	if !device.connected() {
+		// closed, or unplugged; there is nothing to reset
+		return
+	}
+	last := device.lastEvent()
+	if w.lastOK.After(last) {
+		last = w.lastOK
+	}
+	idle := time.Since(last)
+	if idle < w.timeout() {
+		return
+	}
+	device.mu.Lock()
+	scanning := device.scanning
+	device.mu.Unlock()
+	if !scanning && device.Health() == nil {
+		// no events are expected, and the BLE112 is answering
+		w.lastOK = time.Now()
+		return
+	}
+
+	bootTimeout := w.BootTimeout
+	if bootTimeout == 0 {
+		bootTimeout = DefaultBootTimeout
+	}
+	err := device.reset(bootTimeout)
+	w.lastOK = time.Now()
+	w.mu.Lock()
+	if err == nil {
+		w.stats.Resets++
+	} else {
+		w.stats.Failures++
+	}
+	w.stats.LastReset = w.lastOK
+	w.mu.Unlock()
+	if w.OnReset != nil {
+		w.OnReset(Incident{Device: device, Idle: idle, Err: err})
+	}
+}
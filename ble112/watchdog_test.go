@@ -0,0 +1,170 @@
+package ble112_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func nextIncident(t *testing.T, incidents chan ble112.Incident) ble112.Incident {
+	select {
+	case i := <-incidents:
+		return i
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reset")
+	}
+	return ble112.Incident{}
+}
+
+func countCommands(e *emulator.Emulator, name string) int {
+	n := 0
+	for _, c := range e.Commands() {
+		if c == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWatchdogResetsStalledScan(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	data := make(chan beacon.ScanData)
+	done := make(chan bool)
+	go device.Scan(data, done)
+	defer func() {
+		close(done)
+		for range data {
+		}
+	}()
+	nextScan(t, data)
+
+	incidents := make(chan ble112.Incident, 1)
+	w := ble112.NewWatchdog(device)
+	w.Timeout = 100 * time.Millisecond
+	w.OnReset = func(i ble112.Incident) { incidents <- i }
+	w.Start()
+	defer w.Stop()
+
+	e.Stall()
+	incident := nextIncident(t, incidents)
+	if incident.Err != nil {
+		t.Fatalf("unexpected error: %v", incident.Err)
+	}
+	if incident.Idle < w.Timeout {
+		t.Errorf("reset after %v idle; expected at least %v", incident.Idle, w.Timeout)
+	}
+	if stats := w.Stats(); stats.Resets != 1 || stats.Failures != 0 {
+		t.Errorf("got %+v; expected one reset", stats)
+	}
+	if countCommands(e, "system_reset") != 1 {
+		t.Errorf("got commands %v; expected one system_reset", e.Commands())
+	}
+
+	// the scan carries on after the reset
+	nextScan(t, data)
+	if !e.Discovering() {
+		t.Error("scan not restored after the reset")
+	}
+}
+
+func TestWatchdogIdle(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	// a BLE112 which is not scanning sends no events, but is not hung
+	w := ble112.NewWatchdog(device)
+	w.Timeout = 50 * time.Millisecond
+	w.Start()
+	time.Sleep(300 * time.Millisecond)
+	w.Stop()
+	if stats := w.Stats(); stats.Resets != 0 || stats.Failures != 0 {
+		t.Errorf("got %+v; expected no resets", stats)
+	}
+	if countCommands(e, "system_reset") != 0 {
+		t.Errorf("got commands %v; expected no system_reset", e.Commands())
+	}
+}
+
+func TestWatchdogStop(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	w := ble112.NewWatchdog(device)
+	w.Start()
+	stopped := make(chan bool)
+	go func() {
+		w.Stop()
+		w.Stop() // stopping twice is harmless
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked")
+	}
+}
+
+func TestWatchdogStopUnstarted(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	w := ble112.NewWatchdog(newDevice(t, e))
+	stopped := make(chan bool)
+	go func() {
+		w.Stop()
+		w.Stop()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a watchdog which was never started")
+	}
+}
+
+func TestWatchdogFailure(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+	device.Timeout = 50 * time.Millisecond
+
+	incidents := make(chan ble112.Incident, 1)
+	w := ble112.NewWatchdog(device)
+	w.Timeout = 100 * time.Millisecond
+	w.BootTimeout = 100 * time.Millisecond
+	w.OnReset = func(i ble112.Incident) { incidents <- i }
+	w.Start()
+	defer w.Stop()
+
+	// a BLE112 which answers nothing, not even system_reset
+	for _, command := range []string{"system_hello", "system_reset"} {
+		e.Ignore(command, true)
+	}
+	if incident := nextIncident(t, incidents); incident.Err == nil {
+		t.Error("expected the reset to fail")
+	}
+	if stats := w.Stats(); stats.Failures == 0 {
+		t.Errorf("got %+v; expected a failure", stats)
+	}
+}
+
+func TestDeviceResetReconnects(t *testing.T) {
+	e := newEmulator(emulator.Config{ResetDisconnects: true})
+	device := newDevice(t, e)
+
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.Health(); err != nil {
+		t.Errorf("unexpected error after reset: %v", err)
+	}
+	if got, expected := e.AdvData(), emulator.MfgData(0x0118, altBeaconAd); !bytes.Equal(got, expected) {
+		t.Errorf("got adv data %x; expected the advertisement restored", got)
+	}
+}
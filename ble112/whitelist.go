@@ -0,0 +1,238 @@
+package ble112
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+// A WhitelistEntry is an address in the BLE112's whitelist. AddressType
+// is 0 for public addresses and 1 for random ones; an advertiser only
+// matches an entry of its own address type.
+type WhitelistEntry struct {
+	Address     beacon.MacAddress
+	AddressType uint8
+}
+
+// Whitelist returns entries for the given addresses, listing each as
+// both a public and a random address, since beacons use either. Each
+// address takes up two places in the BLE112's whitelist.
+func Whitelist(addrs ...beacon.MacAddress) []WhitelistEntry {
+	entries := make([]WhitelistEntry, 0, 2*len(addrs))
+	for _, addr := range addrs {
+		entries = append(entries, WhitelistEntry{addr, 0}, WhitelistEntry{addr, 1})
+	}
+	return entries
+}
+
+func (e WhitelistEntry) payload() []byte {
+	return append(e.Address[:len(e.Address):len(e.Address)], e.AddressType)
+}
+
+// WhitelistAppend adds an entry to the BLE112's whitelist. The whitelist
+// cannot be changed while scanning or advertising.
+func (device *Device) WhitelistAppend(e WhitelistEntry) error {
+	if _, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_WHITELIST_APPEND, e.payload()); err != nil {
+		return err
+	}
+	device.mu.Lock()
+	device.whitelist = append(device.whitelist, e)
+	device.mu.Unlock()
+	return nil
+}
+
+// WhitelistRemove removes an entry from the BLE112's whitelist. The
+// whitelist cannot be changed while scanning or advertising.
+func (device *Device) WhitelistRemove(e WhitelistEntry) error {
+	if _, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_WHITELIST_REMOVE, e.payload()); err != nil {
+		return err
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	for i, entry := range device.whitelist {
+		if entry == e {
+			device.whitelist = append(device.whitelist[:i], device.whitelist[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// WhitelistClear empties the BLE112's whitelist. The whitelist cannot be
+// changed while scanning or advertising.
+func (device *Device) WhitelistClear() error {
+	if _, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_WHITELIST_CLEAR, NULL_DATA); err != nil {
+		return err
+	}
+	device.mu.Lock()
+	device.whitelist = nil
+	device.mu.Unlock()
+	return nil
+}
+
+// restoreWhitelist appends the whitelist again after a reset, which
+// clears the BLE112's copy.
+func (device *Device) restoreWhitelist() error {
+	device.mu.Lock()
+	entries := append([]WhitelistEntry(nil), device.whitelist...)
+	device.mu.Unlock()
+	for _, e := range entries {
+		if _, err := device.SendCommand(BG_MSG_CLASS_SYSTEM, BG_WHITELIST_APPEND, e.payload()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWhitelist replaces the BLE112's whitelist with the given entries and
+// makes scans report only advertisers on it, so that the rest are
+// filtered out by the BLE112 rather than sent over the serial link. The
+// BLE112 refuses whitelist changes while scanning or advertising, so a
+// scan or advertisement in progress is stopped while the whitelist is
+// changed and then restarted, whether or not the change succeeds. An
+// empty whitelist makes scans report every advertiser again.
+func (device *Device) SetWhitelist(entries []WhitelistEntry) (err error) {
+	device.mu.Lock()
+	scanning, advertising := device.scanning, device.advertising
+	device.mu.Unlock()
+	if scanning {
+		if err := device.StopScan(); err != nil {
+			return err
+		}
+	}
+	if advertising != nil {
+		if _, err := device.SendCommand(BG_MSG_CLASS_GAP, BG_SET_MODE, []byte{BG_GAP_NON_DISCOVERABLE, BG_GAP_NON_CONNECTABLE}); err != nil {
+			if scanning {
+				device.StartScan()
+			}
+			return err
+		}
+	}
+	defer func() {
+		// restart what was stopped, reporting the first error
+		if advertising != nil {
+			if restartErr := device.sendAdvertisement(advertising); err == nil {
+				err = restartErr
+			}
+		}
+		if scanning {
+			if restartErr := device.StartScan(); err == nil {
+				err = restartErr
+			}
+		}
+	}()
+
+	if err := device.WhitelistClear(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := device.WhitelistAppend(e); err != nil {
+			return err
+		}
+	}
+	device.mu.Lock()
+	if len(entries) > 0 {
+		device.ScanOptions.Policy = ScanWhitelist
+	} else {
+		device.ScanOptions.Policy = ScanAll
+	}
+	device.mu.Unlock()
+	return nil
+}
+
+// CounterPollInterval is how often the BLE112's packet counters are
+// polled once FilterStats has been called. The counters are 8 bits wide
+// and stop at 255; the radio receives at most a few thousand advertising
+// packets a second, so they cannot fill between polls.
+const CounterPollInterval = 50 * time.Millisecond
+
+// FilterStats compare the advertising packets the BLE112 received with
+// those it reported.
+type FilterStats struct {
+	Received uint64 // packets received by the radio
+	Reported uint64 // gap_scan_response events sent to the host
+}
+
+// Filtered returns how many of the packets received were not reported.
+func (s FilterStats) Filtered() uint64 {
+	if s.Reported > s.Received {
+		return 0
+	}
+	return s.Received - s.Reported
+}
+
+// FilterStats returns the packets received and reported since the last
+// call. The first call returns nothing, but starts polling the BLE112's
+// packet counters every CounterPollInterval, accumulating them until
+// StopFilterStats is called or the connection closes, so that they never
+// saturate however heavy the traffic. Each poll is a system_get_counters
+// command, which takes its turn with scan and advertising commands, so
+// polling should be stopped once the stats are no longer wanted. Counters
+// resets the same counters, so it should not be used while polling.
+func (device *Device) FilterStats() (FilterStats, error) {
+	c, err := device.currentConn()
+	if err != nil {
+		return FilterStats{}, err
+	}
+	c.mu.Lock()
+	polling := c.pollStop != nil
+	c.mu.Unlock()
+	if !polling {
+		if _, err := device.Counters(); err != nil {
+			return FilterStats{}, err
+		}
+		atomic.StoreUint64(&c.received, 0)
+		atomic.StoreUint64(&c.scanEvents, 0)
+		c.mu.Lock()
+		if c.pollStop == nil {
+			c.pollStop = make(chan struct{})
+			go device.pollCounters(c, c.pollStop)
+		}
+		c.mu.Unlock()
+		return FilterStats{}, nil
+	}
+	counters, err := device.Counters()
+	if err != nil {
+		return FilterStats{}, err
+	}
+	return FilterStats{
+		Received: atomic.SwapUint64(&c.received, 0) + uint64(counters.RxOK),
+		Reported: atomic.SwapUint64(&c.scanEvents, 0),
+	}, nil
+}
+
+// StopFilterStats stops polling the packet counters. The next call to
+// FilterStats starts again, returning nothing. Stopping when not polling
+// does nothing.
+func (device *Device) StopFilterStats() {
+	c, err := device.currentConn()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pollStop != nil {
+		close(c.pollStop)
+		c.pollStop = nil
+	}
+}
+
+// pollCounters accumulates the packets received on c until stop or c is
+// closed.
+func (device *Device) pollCounters(c *conn, stop chan struct{}) {
+	ticker := time.NewTicker(CounterPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if counters, err := device.Counters(); err == nil {
+				atomic.AddUint64(&c.received, uint64(counters.RxOK))
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package ble112_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func TestDeviceWhitelist(t *testing.T) {
+	known, unknown := beacon.MacAddress{0x01}, beacon.MacAddress{0x02}
+	e := newEmulator(emulator.Config{Beacons: []emulator.Beacon{
+		{Address: known, AddressType: 1, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: unknown, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: beacon.MacAddress{0x03}, Data: emulator.MfgData(0x0118, altBeaconAd)},
+	}})
+	device := newDevice(t, e)
+
+	if err := device.SetWhitelist(ble112.Whitelist(known)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := e.Whitelist(); len(got) != 2 {
+		t.Errorf("got whitelist %v; expected the address as public and random", got)
+	}
+	device.FilterStats() // reset the counters
+
+	for _, scan := range scan(t, device, 10) {
+		if scan.Device != known.String() {
+			t.Errorf("got scan from %v; expected only %v", scan.Device, known)
+		}
+	}
+	if got := e.Filtering(); !bytes.Equal(got, []byte{1, 0, 0}) {
+		t.Errorf("got filtering %v; expected the whitelist scan policy", got)
+	}
+	stats, err := device.FilterStats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Reported < 10 || stats.Filtered() < 2*stats.Reported-2 {
+		t.Errorf("got %+v; expected about two of every three packets filtered", stats)
+	}
+
+	if err := device.WhitelistRemove(ble112.WhitelistEntry{Address: known, AddressType: 1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := e.Whitelist(); len(got) != 1 || got[0].AddressType != 0 {
+		t.Errorf("got whitelist %v; expected only the public entry", got)
+	}
+
+	if err := device.SetWhitelist(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device.ScanOptions.Policy != ble112.ScanAll || len(e.Whitelist()) != 0 {
+		t.Errorf("expected an empty whitelist to report every advertiser")
+	}
+}
+
+func TestDeviceWhitelistWhileScanning(t *testing.T) {
+	known := beacon.MacAddress{0x01}
+	e := newEmulator(emulator.Config{Beacons: []emulator.Beacon{
+		{Address: known, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: beacon.MacAddress{0x02}, Data: emulator.MfgData(0x0118, altBeaconAd)},
+	}})
+	device := newDevice(t, e)
+
+	if err := device.StartScan(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := device.WhitelistAppend(ble112.WhitelistEntry{Address: known})
+	if !errors.Is(err, ble112.ErrDeviceInWrongState) {
+		t.Errorf("got %v; expected the whitelist to be locked while scanning", err)
+	}
+	if err := device.SetWhitelist(ble112.Whitelist(known)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !e.Discovering() {
+		t.Error("scan not restarted after changing the whitelist")
+	}
+}
+
+func TestDeviceWhitelistWhileAdvertising(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	if err := device.AdvertiseMfgData(0x0118, altBeaconAd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := device.WhitelistAppend(ble112.WhitelistEntry{Address: beacon.MacAddress{0x01}})
+	if !errors.Is(err, ble112.ErrDeviceInWrongState) {
+		t.Errorf("got %v; expected the whitelist to be locked while advertising", err)
+	}
+	if err := device.SetWhitelist(ble112.Whitelist(beacon.MacAddress{0x01})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := e.Whitelist(); len(got) != 2 {
+		t.Errorf("got whitelist %v; expected it set", got)
+	}
+	if mode, _ := e.Mode(); mode != ble112.BG_GAP_USER_DATA || !device.IsAdvertising() {
+		t.Error("advertising not restarted after changing the whitelist")
+	}
+}
+
+func TestDeviceWhitelistFailureRestartsScan(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	if err := device.StartScan(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.SetResult("system_whitelist_append", ble112.ErrOutOfMemory)
+	err := device.SetWhitelist(ble112.Whitelist(beacon.MacAddress{0x01}))
+	if !errors.Is(err, ble112.ErrOutOfMemory) {
+		t.Errorf("got %v; expected the append to fail", err)
+	}
+	if !e.Discovering() {
+		t.Error("scan not restarted after failing to change the whitelist")
+	}
+}
+
+func TestDeviceWhitelistReset(t *testing.T) {
+	known := beacon.MacAddress{0x01}
+	e := newEmulator(emulator.Config{Beacons: []emulator.Beacon{
+		{Address: known, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: beacon.MacAddress{0x02}, Data: emulator.MfgData(0x0118, altBeaconAd)},
+	}})
+	device := newDevice(t, e)
+
+	if err := device.SetWhitelist(ble112.Whitelist(known)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.StartScan(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := e.Whitelist(); len(got) != 2 {
+		t.Errorf("got whitelist %v; expected it restored after the reset", got)
+	}
+	if got := e.Filtering(); !bytes.Equal(got, []byte{1, 0, 0}) {
+		t.Errorf("got filtering %v; expected the whitelist scan policy", got)
+	}
+	if !e.Discovering() {
+		t.Error("scan not restored after the reset")
+	}
+}
+
+func TestDeviceFilterStatsSaturation(t *testing.T) {
+	known := beacon.MacAddress{0x01}
+	e := newEmulator(emulator.Config{ScanInterval: time.Millisecond, Beacons: []emulator.Beacon{
+		{Address: known, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: beacon.MacAddress{0x02}, Data: emulator.MfgData(0x0118, altBeaconAd)},
+		{Address: beacon.MacAddress{0x03}, Data: emulator.MfgData(0x0118, altBeaconAd)},
+	}})
+	device := newDevice(t, e)
+
+	if err := device.SetWhitelist(ble112.Whitelist(known)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device.FilterStats() // start polling the counters
+
+	// far more packets than the 8-bit counters hold
+	scan(t, device, 300)
+	stats, err := device.FilterStats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Reported < 300 || stats.Received < 2*stats.Reported || stats.Filtered() < stats.Reported {
+		t.Errorf("got %+v; expected about two of every three packets filtered", stats)
+	}
+}
+
+func TestDeviceStopFilterStats(t *testing.T) {
+	e := newEmulator(emulator.Config{})
+	device := newDevice(t, e)
+
+	device.FilterStats() // start polling the counters
+	time.Sleep(3 * ble112.CounterPollInterval)
+	if countCommands(e, "system_get_counters") < 3 {
+		t.Fatalf("got commands %v; expected the counters polled", e.Commands())
+	}
+	device.StopFilterStats()
+	time.Sleep(ble112.CounterPollInterval) // for a poll already under way
+	polls := countCommands(e, "system_get_counters")
+	time.Sleep(3 * ble112.CounterPollInterval)
+	if got := countCommands(e, "system_get_counters"); got != polls {
+		t.Errorf("got %v more polls after stopping; expected none", got-polls)
+	}
+	device.StopFilterStats() // stopping twice is harmless
+}
@@ -0,0 +1,21 @@
+package beacon
+
+import "fmt"
+
+// BeaconTypeEddystoneEID indicates a beacon of type Eddystone-EID.
+const BeaconTypeEddystoneEID = "eddystone_eid"
+
+// NewEddystoneEIDBeacon returns an Eddystone-EID beacon advertising the
+// given 8-byte ephemeral identifier, or an error if it is the wrong
+// length.
+func NewEddystoneEIDBeacon(eid []byte, pwr int8) (*Beacon, error) {
+	if len(eid) != 8 {
+		return nil, fmt.Errorf("beacon: eid is %d bytes; expected 8", len(eid))
+	}
+	beacon := NewBeacon(BeaconTypeEddystoneEID,
+		Fields{Field(eid)}, // ids
+		Fields{},           // data
+		FieldFromInt8(pwr), // measured power
+	)
+	return &beacon, nil
+}
@@ -0,0 +1,90 @@
+package beacon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BeaconTypeEddystoneTLM indicates a beacon of type Eddystone-TLM.
+const BeaconTypeEddystoneTLM = "eddystone_tlm"
+
+// tlmNoTemperature is the temperature of a beacon without a sensor.
+const tlmNoTemperature = 0x8000
+
+// TLM is the telemetry in an unencrypted Eddystone-TLM frame.
+type TLM struct {
+	// BatteryVoltage is in mV. Zero means the beacon is not battery
+	// powered.
+	BatteryVoltage uint16
+	// Temperature is in degrees Celsius, with a resolution of 1/256.
+	// Nil means the beacon has no temperature sensor.
+	Temperature *float64
+	// AdvCount is the number of advertising PDUs sent since the beacon
+	// started.
+	AdvCount uint32
+	// Uptime is the time since the beacon started, with a resolution of
+	// 0.1s.
+	Uptime time.Duration
+}
+
+// NewEddystoneTLMBeacon returns an Eddystone-TLM beacon with the given
+// telemetry.
+func NewEddystoneTLMBeacon(tlm TLM) *Beacon {
+	beacon := NewBeacon(BeaconTypeEddystoneTLM,
+		Fields{},                // ids
+		EddystoneTLMFields(tlm), // data
+		nil,                     // no measured power
+	)
+	return &beacon
+}
+
+// EddystoneTLMFields returns the data fields of an unencrypted
+// Eddystone-TLM frame: the version, battery voltage, temperature,
+// advertising PDU count and uptime.
+func EddystoneTLMFields(tlm TLM) Fields {
+	temperature := uint16(tlmNoTemperature)
+	if tlm.Temperature != nil {
+		// signed 8.8 fixed point, clamped so as not to wrap
+		t := math.Round(*tlm.Temperature * 256)
+		t = math.Max(math.Min(t, math.MaxInt16), math.MinInt16+1)
+		temperature = uint16(int16(t))
+	}
+	return Fields{
+		Field{0x00},
+		FieldFromUint16(tlm.BatteryVoltage),
+		FieldFromUint16(temperature),
+		fieldFromUint32(tlm.AdvCount),
+		fieldFromUint32(uint32(tlm.Uptime / (100 * time.Millisecond))),
+	}
+}
+
+// ParseEddystoneTLM returns the telemetry of an Eddystone-TLM beacon.
+func ParseEddystoneTLM(b *Beacon) (TLM, error) {
+	if b.Type != BeaconTypeEddystoneTLM {
+		return TLM{}, fmt.Errorf("beacon: %v is not %v", b.Type, BeaconTypeEddystoneTLM)
+	}
+	if len(b.Data) != 5 || len(b.Data[1]) != 2 || len(b.Data[2]) != 2 || len(b.Data[3]) != 4 || len(b.Data[4]) != 4 {
+		return TLM{}, fmt.Errorf("beacon: malformed %v", b.Type)
+	}
+	if b.Data[0].Uint8() != 0x00 {
+		return TLM{}, fmt.Errorf("beacon: unsupported %v version %#02x", b.Type, b.Data[0].Uint8())
+	}
+	tlm := TLM{
+		BatteryVoltage: b.Data[1].Uint16(),
+		AdvCount:       binary.BigEndian.Uint32(b.Data[3]),
+		Uptime:         time.Duration(binary.BigEndian.Uint32(b.Data[4])) * 100 * time.Millisecond,
+	}
+	if t := b.Data[2].Uint16(); t != tlmNoTemperature {
+		celsius := float64(int16(t)) / 256
+		tlm.Temperature = &celsius
+	}
+	return tlm, nil
+}
+
+func fieldFromUint32(n uint32) Field {
+	var field Field = make([]byte, 4)
+	binary.BigEndian.PutUint32(field, n)
+	return field
+}
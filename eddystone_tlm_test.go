@@ -0,0 +1,38 @@
+package beacon
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEddystoneTLM(t *testing.T) {
+	celsius := -1.5
+	tlm := TLM{BatteryVoltage: 3000, Temperature: &celsius, AdvCount: 70000, Uptime: 90 * time.Second}
+	b := NewEddystoneTLMBeacon(tlm)
+	parser := NewParser(BeaconTypeEddystoneTLM, DefaultLayouts[BeaconTypeEddystoneTLM])
+	ad := parser.GenerateAd(b)
+	expected := []byte{0xaa, 0xfe, 0x20, 0x00, 0x0b, 0xb8, 0xfe, 0x80, 0x00, 0x01, 0x11, 0x70, 0x00, 0x00, 0x03, 0x84}
+	if !bytes.Equal(ad, expected) {
+		t.Errorf("got %x; expected %x", ad, expected)
+	}
+
+	parsed, err := ParseEddystoneTLM(parser.Parse(ad))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.BatteryVoltage != 3000 || parsed.AdvCount != 70000 || parsed.Uptime != 90*time.Second {
+		t.Errorf("got %+v; expected %+v", parsed, tlm)
+	}
+	if parsed.Temperature == nil || *parsed.Temperature != celsius {
+		t.Errorf("got temperature %v; expected %v", parsed.Temperature, celsius)
+	}
+
+	parsed, _ = ParseEddystoneTLM(NewEddystoneTLMBeacon(TLM{}))
+	if parsed.Temperature != nil {
+		t.Errorf("got temperature %v; expected none", *parsed.Temperature)
+	}
+	if _, err := ParseEddystoneTLM(NewAltBeacon("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6", 1, 2, -59)); err == nil {
+		t.Error("expected an altbeacon to be refused")
+	}
+}
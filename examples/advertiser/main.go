@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,10 +13,13 @@ import (
 
 func main() {
 	urlBeacon, _ := beacon.NewEddystoneURLBeacon("https://www.radiusnetworks.com", -42)
-	eddystoneURLParser := beacon.NewParser("eddystone_url", beacon.DefaultLayouts["eddystone_url"])
-	advert := eddystoneURLParser.GenerateAd(urlBeacon)
-	adv, _ := advertiser.New()
-	adv.AdvertiseServiceData(0xfeaa, advert)
+	adv, err := advertiser.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := advertiser.AdvertiseBeacon(context.Background(), adv, urlBeacon); err != nil {
+		log.Fatal(err)
+	}
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT)
 	signal.Notify(sigChan, syscall.SIGTERM)
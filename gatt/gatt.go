@@ -0,0 +1,187 @@
+// Package gatt defines a GATT client interface shared by the BLE
+// backends, for reading and changing the settings of connectable
+// beacons.
+package gatt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+// A UUID is a 16 or 128-bit attribute type, stored in the little-endian
+// byte order used on the air.
+type UUID []byte
+
+// UUID16 returns the 16-bit UUID u.
+func UUID16(u uint16) UUID {
+	return UUID{byte(u), byte(u >> 8)}
+}
+
+// ParseUUID parses a 16-bit UUID such as "2a00" or a 128-bit UUID such as
+// "e858fc8a-372b-4bef-a053-93f98cd4e177".
+func ParseUUID(s string) (UUID, error) {
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || (len(b) != 2 && len(b) != 16) {
+		return nil, fmt.Errorf("gatt: invalid uuid %q", s)
+	}
+	return reverse(b), nil
+}
+
+// MustParseUUID is like ParseUUID but panics if s is not a UUID.
+func MustParseUUID(s string) UUID {
+	u, err := ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+// baseUUID is the Bluetooth base UUID, 00000000-0000-1000-8000-00805f9b34fb,
+// into which 16-bit UUIDs expand.
+var baseUUID = MustParseUUID("00000000-0000-1000-8000-00805f9b34fb")
+
+// expand returns the 128-bit form of u.
+func (u UUID) expand() UUID {
+	if len(u) != 2 {
+		return u
+	}
+	e := append(UUID(nil), baseUUID...)
+	e[12], e[13] = u[0], u[1]
+	return e
+}
+
+// Equal returns true if u and o are the same UUID, even if one is in its
+// 16-bit and the other in its 128-bit form.
+func (u UUID) Equal(o UUID) bool {
+	return bytes.Equal(u.expand(), o.expand())
+}
+
+func (u UUID) String() string {
+	s := hex.EncodeToString(reverse(u))
+	if len(s) != 32 {
+		return s
+	}
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// Attribute types used in discovery.
+var (
+	PrimaryServiceUUID = UUID16(0x2800)
+	CharacteristicUUID = UUID16(0x2803)
+	CCCDUUID           = UUID16(0x2902) // client characteristic configuration
+)
+
+// A Property is a characteristic property.
+type Property uint8
+
+// Characteristic properties.
+const (
+	PropBroadcast Property = 1 << iota
+	PropRead
+	PropWriteWithoutResponse
+	PropWrite
+	PropNotify
+	PropIndicate
+	PropSignedWrite
+	PropExtended
+)
+
+// A Service is a primary service found by DiscoverServices, spanning the
+// handles from Start to End.
+type Service struct {
+	UUID  UUID
+	Start uint16
+	End   uint16
+}
+
+// A Characteristic is a characteristic found by DiscoverCharacteristics.
+type Characteristic struct {
+	UUID        UUID
+	Properties  Property
+	Handle      uint16 // the characteristic declaration
+	ValueHandle uint16
+	// CCCDHandle is the handle of the client characteristic configuration
+	// descriptor, which Subscribe writes, or zero if there is none.
+	CCCDHandle uint16
+}
+
+// A NotificationHandler is called with the value of each notification or
+// indication of a subscribed characteristic.
+type NotificationHandler func(value []byte)
+
+// A Client talks to the GATT server of a connected peripheral. Its
+// methods may be called from several goroutines; procedures are carried
+// out one at a time.
+type Client interface {
+	// DiscoverServices returns the peripheral's primary services.
+	DiscoverServices() ([]*Service, error)
+	// DiscoverCharacteristics returns the characteristics of s.
+	DiscoverCharacteristics(s *Service) ([]*Characteristic, error)
+	// Read returns the value of c.
+	Read(c *Characteristic) ([]byte, error)
+	// Write writes the value of c and waits for the peripheral to
+	// acknowledge it.
+	Write(c *Characteristic, value []byte) error
+	// WriteWithoutResponse writes the value of c without waiting.
+	WriteWithoutResponse(c *Characteristic, value []byte) error
+	// Subscribe enables notifications, or indications if c does not
+	// support notifications, and calls h with each value received.
+	Subscribe(c *Characteristic, h NotificationHandler) error
+	// Unsubscribe disables notifications or indications of c.
+	Unsubscribe(c *Characteristic) error
+	// Disconnect closes the connection.
+	Disconnect() error
+	// Disconnected is closed when the connection closes.
+	Disconnected() <-chan struct{}
+}
+
+// A Connector connects to peripherals. AddressType is 0 for a public
+// address and 1 for a random one.
+type Connector interface {
+	Connect(addr beacon.MacAddress, addressType uint8) (Client, error)
+}
+
+// ErrDisconnected is returned by procedures interrupted by the connection
+// closing.
+var ErrDisconnected = errors.New("gatt: disconnected")
+
+// ErrNotFound is returned by FindCharacteristic when the service or
+// characteristic does not exist.
+var ErrNotFound = errors.New("gatt: not found")
+
+// FindCharacteristic discovers the characteristic with the given UUID in
+// the service with the given UUID.
+func FindCharacteristic(c Client, service UUID, characteristic UUID) (*Characteristic, error) {
+	services, err := c.DiscoverServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		if !s.UUID.Equal(service) {
+			continue
+		}
+		chars, err := c.DiscoverCharacteristics(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range chars {
+			if ch.UUID.Equal(characteristic) {
+				return ch, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,80 @@
+package gatt_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon/gatt"
+)
+
+func TestParseUUID(t *testing.T) {
+	u, err := gatt.ParseUUID("2f234454-cf6d-4a0f-adf2-f4911ba9ffa6")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u[0] != 0xa6 || u[15] != 0x2f {
+		t.Errorf("got %x; expected little-endian bytes", []byte(u))
+	}
+	if s := u.String(); s != "2f234454-cf6d-4a0f-adf2-f4911ba9ffa6" {
+		t.Errorf("got %v; expected the uuid back", s)
+	}
+	if u, _ := gatt.ParseUUID("2A19"); !bytes.Equal(u, gatt.UUID16(0x2a19)) || u.String() != "2a19" {
+		t.Errorf("got %v; expected 2a19", u)
+	}
+	for _, s := range []string{"", "2a", "not a uuid", "2f234454-cf6d-4a0f-adf2"} {
+		if _, err := gatt.ParseUUID(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestUUIDEqual(t *testing.T) {
+	short := gatt.UUID16(0x2902)
+	long := gatt.MustParseUUID("00002902-0000-1000-8000-00805f9b34fb")
+	if !short.Equal(long) || !long.Equal(short) {
+		t.Error("expected a 16-bit uuid to equal its 128-bit form")
+	}
+	if short.Equal(gatt.UUID16(0x2903)) || long.Equal(gatt.MustParseUUID("00002902-0000-1000-8000-00805f9b34fc")) {
+		t.Error("expected different uuids to differ")
+	}
+}
+
+// fakeClient is a gatt.Client with a fixed set of services, which fails
+// everything but discovery.
+type fakeClient struct {
+	gatt.Client
+	chars map[*gatt.Service][]*gatt.Characteristic
+}
+
+func (c *fakeClient) DiscoverServices() ([]*gatt.Service, error) {
+	var services []*gatt.Service
+	for s := range c.chars {
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+func (c *fakeClient) DiscoverCharacteristics(s *gatt.Service) ([]*gatt.Characteristic, error) {
+	return c.chars[s], nil
+}
+
+func TestFindCharacteristic(t *testing.T) {
+	level := &gatt.Characteristic{UUID: gatt.UUID16(0x2a19), ValueHandle: 3}
+	c := &fakeClient{chars: map[*gatt.Service][]*gatt.Characteristic{
+		{UUID: gatt.UUID16(0x1800)}: {{UUID: gatt.UUID16(0x2a00)}},
+		{UUID: gatt.UUID16(0x180f)}: {level},
+	}}
+
+	got, err := gatt.FindCharacteristic(c, gatt.MustParseUUID("0000180f-0000-1000-8000-00805f9b34fb"), gatt.UUID16(0x2a19))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != level {
+		t.Errorf("got %+v; expected the battery level", got)
+	}
+	// the characteristic exists, but in another service
+	if _, err := gatt.FindCharacteristic(c, gatt.UUID16(0x1800), gatt.UUID16(0x2a19)); !errors.Is(err, gatt.ErrNotFound) {
+		t.Errorf("got %v; expected ErrNotFound", err)
+	}
+}
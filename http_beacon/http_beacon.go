@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,10 @@ import (
 	"github.com/RadiusNetworks/go-beacon/advertiser"
 )
 
+// companyID is the manufacturer id the altbeacons are advertised under,
+// which receivers filter on.
+const companyID = 0xbeef
+
 // BeaconSpecification contains beacon type and configuration for advertising.
 // Struct and fields must be exported for the json Decoder to work.
 type BeaconSpecification struct {
@@ -38,10 +43,15 @@ func beaconHandler(response http.ResponseWriter, request *http.Request) {
 }
 
 func advertiseBeacon(advBeacon BeaconSpecification) {
-	altBeaconParser := beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"])
 	altBeacon := beacon.NewAltBeacon(advBeacon.Identifiers.UUID, advBeacon.Identifiers.Major, advBeacon.Identifiers.Minor, -42)
-	advert := altBeaconParser.GenerateAd(altBeacon)
-	adv, _ := advertiser.New()
-	adv.AdvertiseMfgData(0xbeef, advert)
+	adv, err := advertiser.New()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := advertiser.AdvertiseBeaconWithCompanyID(context.Background(), adv, altBeacon, companyID); err != nil {
+		log.Println(err)
+		return
+	}
 	log.Println(fmt.Sprintf("Advertising beacon: UUID: %s, Major %d, Minor %d", advBeacon.Identifiers.UUID, advBeacon.Identifiers.Major, advBeacon.Identifiers.Minor))
 }
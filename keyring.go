@@ -0,0 +1,115 @@
+package beacon
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxResolved is how many resolved addresses a Keyring remembers, so
+// that it does not repeat AES for every advertisement of a known device.
+const maxResolved = 1024
+
+// An Identity is a device's identity address, which its resolvable
+// private addresses stand in for.
+type Identity struct {
+	Address     MacAddress
+	AddressType uint8 // PublicAddressType or RandomAddressType
+}
+
+// A Keyring holds the identity resolving keys of devices, such as those
+// exchanged when pairing, to recognise their resolvable private
+// addresses. Its methods may be called from several goroutines.
+type Keyring struct {
+	mu       sync.Mutex
+	keys     []keyringEntry
+	resolved map[MacAddress]Identity // recently resolved addresses
+}
+
+type keyringEntry struct {
+	irk      [16]byte
+	identity Identity
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{resolved: make(map[MacAddress]Identity)}
+}
+
+// Add adds the 16-byte identity resolving key of the device with the
+// given identity, replacing any key it had.
+func (k *Keyring) Add(identity Identity, irk []byte) error {
+	if len(irk) != 16 {
+		return fmt.Errorf("beacon: irk is %d bytes; expected 16", len(irk))
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.remove(identity.Address)
+	entry := keyringEntry{identity: identity}
+	copy(entry.irk[:], irk)
+	k.keys = append(k.keys, entry)
+	return nil
+}
+
+// Remove removes the key of the device with the given identity address,
+// and returns false if there is none.
+func (k *Keyring) Remove(identity MacAddress) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.remove(identity)
+}
+
+func (k *Keyring) remove(identity MacAddress) bool {
+	for i, entry := range k.keys {
+		if entry.identity.Address == identity {
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			k.resolved = make(map[MacAddress]Identity)
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of keys in the keyring.
+func (k *Keyring) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.keys)
+}
+
+// Resolve returns the identity of the device whose key made addr, a
+// random address, and false if addr is not a resolvable private address
+// of any device in the keyring.
+func (k *Keyring) Resolve(addr MacAddress) (Identity, bool) {
+	if addr.RandomKind() != ResolvablePrivate {
+		return Identity{}, false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if identity, ok := k.resolved[addr]; ok {
+		return identity, true
+	}
+	for _, entry := range k.keys {
+		if addr.Resolves(entry.irk[:]) {
+			if len(k.resolved) >= maxResolved {
+				k.resolved = make(map[MacAddress]Identity)
+			}
+			k.resolved[addr] = entry.identity
+			return entry.identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+// ResolveScan returns scan with its Device and AddressType replaced by
+// the identity of the device, if it was sent from a resolvable private
+// address of a device in the keyring.
+func (k *Keyring) ResolveScan(scan ScanData) ScanData {
+	if scan.AddressType != RandomAddressType {
+		return scan
+	}
+	if identity, ok := k.Resolve(ParseMacAddress(scan.Device)); ok {
+		scan.Device = identity.Address.String()
+		scan.AddressType = identity.AddressType
+	}
+	return scan
+}
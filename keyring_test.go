@@ -0,0 +1,63 @@
+package beacon_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+// sampleIRK and sampleRPA are the sample data of the Bluetooth Core
+// specification: prand 0x708194 hashes to 0x0dfbaa.
+var (
+	sampleIRK, _ = hex.DecodeString("ec0234a357c8ad05341010a60a397d9b")
+	sampleRPA    = beacon.ParseMacAddress("70:81:94:0d:fb:aa")
+	identity     = beacon.Identity{Address: beacon.ParseMacAddress("00:07:80:12:34:56")}
+)
+
+func TestKeyring(t *testing.T) {
+	if !sampleRPA.Resolves(sampleIRK) {
+		t.Errorf("%v does not resolve with the sample irk", sampleRPA)
+	}
+	k := beacon.NewKeyring()
+	if err := k.Add(identity, sampleIRK); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.Add(identity, sampleIRK[:15]); err == nil {
+		t.Error("expected a short irk to be refused")
+	}
+	other, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	k.Add(beacon.Identity{Address: beacon.MacAddress{1}, AddressType: beacon.RandomAddressType}, other)
+
+	for i := 0; i < 2; i++ {
+		if got, ok := k.Resolve(sampleRPA); !ok || got != identity {
+			t.Errorf("got %v, %v; expected %v", got, ok, identity)
+		}
+	}
+	rpa, _ := beacon.NewResolvableAddress(other)
+	if got, ok := k.Resolve(rpa); !ok || got.Address != (beacon.MacAddress{1}) {
+		t.Errorf("got %v, %v; expected the other device", got, ok)
+	}
+	// a different hash, and an address which is not resolvable
+	for _, addr := range []string{"70:81:94:0d:fb:ab", "30:81:94:0d:fb:aa"} {
+		if got, ok := k.Resolve(beacon.ParseMacAddress(addr)); ok {
+			t.Errorf("%v resolved to %v", addr, got)
+		}
+	}
+
+	scan := k.ResolveScan(beacon.ScanData{Device: sampleRPA.String(), AddressType: beacon.RandomAddressType})
+	if scan.Device != identity.Address.String() || scan.AddressType != beacon.PublicAddressType {
+		t.Errorf("got %+v; expected the identity address", scan)
+	}
+	scan = k.ResolveScan(beacon.ScanData{Device: sampleRPA.String(), AddressType: beacon.PublicAddressType})
+	if scan.Device != sampleRPA.String() {
+		t.Errorf("got %v; expected a public address not to be resolved", scan.Device)
+	}
+
+	if !k.Remove(identity.Address) || k.Remove(identity.Address) || k.Len() != 1 {
+		t.Error("expected the identity to be removed once")
+	}
+	if _, ok := k.Resolve(sampleRPA); ok {
+		t.Error("resolved with a removed key")
+	}
+}
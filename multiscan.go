@@ -0,0 +1,83 @@
+package beacon
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A MultiScanDevice is a ScanDevice which scans with several ScanDevices
+// at once, such as the BLE112s of a gateway, merging their ScanData into
+// one stream. Each ScanData is tagged with the adapter which received it,
+// so a Scanner can report each beacon's RSSI per adapter.
+type MultiScanDevice struct {
+	devices []ScanDevice
+}
+
+// NewMultiScanDevice returns a ScanDevice which scans with all of the
+// given devices.
+func NewMultiScanDevice(devices ...ScanDevice) *MultiScanDevice {
+	return &MultiScanDevice{devices: devices}
+}
+
+// adapterName identifies a ScanDevice: by its address if it is an
+// Adapter, otherwise by its position.
+func adapterName(i int, device ScanDevice) string {
+	if a, ok := device.(interface{ DeviceAddress() string }); ok && a.DeviceAddress() != "" {
+		return a.DeviceAddress()
+	}
+	return fmt.Sprintf("adapter%d", i)
+}
+
+// Scan scans with every device until it receives something on done or
+// all of the devices stop. ScanData without an Adapter are tagged with
+// the address of the device which received them.
+func (m *MultiScanDevice) Scan(data chan ScanData, done chan bool) {
+	defer close(data)
+	stop := make(chan bool)
+	merged := make(chan ScanData)
+
+	var wg sync.WaitGroup
+	for i, device := range m.devices {
+		scans := make(chan ScanData)
+		name := adapterName(i, device)
+		wg.Add(1)
+		go device.Scan(scans, stop)
+		go func() {
+			defer wg.Done()
+			// forward until the device closes scans, which it does once
+			// stop is closed
+			for scan := range scans {
+				if scan.Adapter == "" {
+					scan.Adapter = name
+				}
+				select {
+				case merged <- scan:
+				case <-stop:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	for {
+		select {
+		case scan, more := <-merged:
+			if !more {
+				return
+			}
+			select {
+			case data <- scan:
+				continue
+			case <-done:
+			}
+		case <-done:
+		}
+		close(stop)
+		for range merged {
+		}
+		return
+	}
+}
@@ -3,6 +3,7 @@ package beacon
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -43,6 +44,7 @@ type Parser struct {
 	dataFields []fieldParams
 	powerField fieldParams
 	minLength  int
+	service    []byte // the service uuid matched, big endian; nil if none
 }
 
 // NewParser initializes a new beacon parser with the given name and layout.
@@ -78,6 +80,7 @@ func (p *Parser) parseLayout(layout string) {
 			p.matchers = append(p.matchers, params)
 		case "s":
 			// swap bytes for service UUID
+			p.service = params.expected
 			params.expected = []byte{params.expected[1], params.expected[0]}
 			p.matchers = append(p.matchers, params)
 		case "i":
@@ -90,6 +93,15 @@ func (p *Parser) parseLayout(layout string) {
 	}
 }
 
+// ServiceUUID returns the 16-bit service uuid which the layout matches,
+// and false if the layout is for manufacturer data.
+func (p *Parser) ServiceUUID() (uint16, bool) {
+	if len(p.service) != 2 {
+		return 0, false
+	}
+	return uint16(p.service[0])<<8 | uint16(p.service[1]), true
+}
+
 // Matches returns true if the advertisement data matches this layout.
 func (p *Parser) Matches(data []byte) bool {
 	if len(data) < p.minLength {
@@ -152,9 +164,66 @@ func (p *Parser) Parse(data []byte) *Beacon {
 	dataFields := p.ParseData(data)
 	measuredPower := p.ParsePower(data)
 	beacon := NewBeacon(p.Name, ids, dataFields, measuredPower)
+	beacon.ad = append([]byte(nil), data...)
+	beacon.parser = p
 	return &beacon
 }
 
+// Check returns an error if b's fields do not fit the layout, in which
+// case GenerateAd cannot generate its advertisement. Fixed length fields
+// must be filled; variable length fields, which end the advertisement,
+// may be any length.
+func (p *Parser) Check(b *Beacon) error {
+	if len(b.Ids) != len(p.idFields) {
+		return fmt.Errorf("beacon: %v has %d ids; layout %v has %d", b.Type, len(b.Ids), p.Name, len(p.idFields))
+	}
+	if len(b.Data) != len(p.dataFields) {
+		return fmt.Errorf("beacon: %v has %d data fields; layout %v has %d", b.Type, len(b.Data), p.Name, len(p.dataFields))
+	}
+	check := func(kind string, i int, params fieldParams, field Field) error {
+		if len(field) == params.length || params.varLength {
+			return nil
+		}
+		return fmt.Errorf("beacon: %v %s %d is %d bytes; layout %v has %d", b.Type, kind, i, len(field), p.Name, params.length)
+	}
+	for i, params := range p.idFields {
+		if err := check("id", i, params, b.Ids[i]); err != nil {
+			return err
+		}
+	}
+	for i, params := range p.dataFields {
+		if err := check("data field", i, params, b.Data[i]); err != nil {
+			return err
+		}
+	}
+	if p.powerField.length > 0 {
+		return check("power", 0, p.powerField, b.Power)
+	}
+	return nil
+}
+
+// ReplaceIds returns a copy of ad, advertisement data which the layout
+// matches, carrying ids in place of its own and otherwise unchanged. ids
+// must fit the layout as Check requires; a variable length id replaces
+// the rest of ad.
+func (p *Parser) ReplaceIds(ad []byte, ids Fields) ([]byte, error) {
+	if !p.Matches(ad) {
+		return nil, fmt.Errorf("beacon: advertisement does not match layout %v", p.Name)
+	}
+	if err := p.Check(&Beacon{Type: p.Name, Ids: ids, Data: p.ParseData(ad), Power: p.ParsePower(ad)}); err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), ad...)
+	for i, params := range p.idFields {
+		if params.varLength {
+			out = append(out[:params.start], ids[i]...)
+		} else {
+			copy(out[params.start:params.end+1], ids[i])
+		}
+	}
+	return out, nil
+}
+
 // GenerateAd generates the bytes of a beacon advertisement with the
 // given beacon.
 func (p *Parser) GenerateAd(b *Beacon) []byte {
@@ -1,6 +1,7 @@
 package beacon
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -33,3 +34,38 @@ func collapseCapacity(in []byte) []byte {
 	copy(out, in)
 	return out
 }
+
+func TestParserServiceUUID(t *testing.T) {
+	for name, layout := range DefaultLayouts {
+		uuid, ok := NewParser(name, layout).ServiceUUID()
+		if name == "altbeacon" {
+			if ok {
+				t.Errorf("%v: got service uuid %#04x; expected manufacturer data", name, uuid)
+			}
+		} else if !ok || uuid != 0xfeaa {
+			t.Errorf("%v: got %#04x, %v; expected 0xfeaa", name, uuid, ok)
+		}
+	}
+}
+
+func TestParserReplaceIds(t *testing.T) {
+	ad := []byte(FieldFromHex(properAltbeacon))
+	parser := NewParser("altbeacon", DefaultLayouts["altbeacon"])
+	beacon := parser.Parse(ad)
+	if got, p := beacon.Ad(); !bytes.Equal(got, ad) || p != parser {
+		t.Errorf("got %x from %v; expected the parsed advertisement", got, p)
+	}
+
+	ids := Fields{beacon.Ids[0], FieldFromUint16(7), FieldFromUint16(8)}
+	replaced, err := parser.ReplaceIds(ad, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := FieldFromHex("BEACBEACE858FC8A372B4BEFA05393F98CD4E177000700084020")
+	if !bytes.Equal(replaced, expected) {
+		t.Errorf("got %x; expected %x", replaced, expected)
+	}
+	if _, err := parser.ReplaceIds(ad, ids[:2]); err == nil {
+		t.Error("expected an error replacing too few ids")
+	}
+}
@@ -0,0 +1,118 @@
+package beacon
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"fmt"
+)
+
+// Address types, as ScanData.AddressType reports them.
+const (
+	PublicAddressType uint8 = 0
+	RandomAddressType uint8 = 1
+)
+
+// A RandomAddressKind is a kind of random Bluetooth address, told apart
+// by the two most significant bits of the address.
+type RandomAddressKind int
+
+// Random address kinds, as the Bluetooth Core specification defines them.
+const (
+	NonResolvablePrivate RandomAddressKind = iota // 00
+	ResolvablePrivate                             // 01
+	ReservedRandom                                // 10
+	StaticRandom                                  // 11
+)
+
+func (k RandomAddressKind) String() string {
+	switch k {
+	case NonResolvablePrivate:
+		return "non-resolvable private"
+	case ResolvablePrivate:
+		return "resolvable private"
+	case StaticRandom:
+		return "static random"
+	}
+	return "reserved"
+}
+
+// RandomKind returns the kind of addr, taken as a random address. The
+// address type advertised alongside it says whether it is random at all.
+func (addr MacAddress) RandomKind() RandomAddressKind {
+	return RandomAddressKind(addr[5] >> 6)
+}
+
+// randomBits fills b with random bits, except for the top two bits of
+// b[0], which are set to kind. The random bits are neither all zero nor
+// all one, as the Bluetooth Core specification requires.
+func randomBits(b []byte, kind RandomAddressKind) error {
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		b[0] = b[0]&0x3f | byte(kind)<<6
+		zeros, ones := b[0]&0x3f == 0, b[0]&0x3f == 0x3f
+		for _, x := range b[1:] {
+			zeros, ones = zeros && x == 0, ones && x == 0xff
+		}
+		if !zeros && !ones {
+			return nil
+		}
+	}
+}
+
+// randomAddress returns a random address of the given kind.
+func randomAddress(kind RandomAddressKind) (MacAddress, error) {
+	var b [6]byte
+	if err := randomBits(b[:], kind); err != nil {
+		return MacAddress{}, err
+	}
+	return MacAddress{b[5], b[4], b[3], b[2], b[1], b[0]}, nil
+}
+
+// NewStaticRandomAddress returns a new static random address, which a
+// device may keep until it power cycles.
+func NewStaticRandomAddress() (MacAddress, error) {
+	return randomAddress(StaticRandom)
+}
+
+// NewNonResolvableAddress returns a new non-resolvable private address,
+// which cannot be linked to any other address of the device.
+func NewNonResolvableAddress() (MacAddress, error) {
+	return randomAddress(NonResolvablePrivate)
+}
+
+// NewResolvableAddress returns a new resolvable private address for the
+// given 16-byte identity resolving key: a random prand and its hash,
+// which only holders of the key can link to the device.
+func NewResolvableAddress(irk []byte) (MacAddress, error) {
+	if len(irk) != 16 {
+		return MacAddress{}, fmt.Errorf("beacon: irk is %d bytes; expected 16", len(irk))
+	}
+	var prand [3]byte
+	if err := randomBits(prand[:], ResolvablePrivate); err != nil {
+		return MacAddress{}, err
+	}
+	hash := ah(irk, prand)
+	return MacAddress{hash[2], hash[1], hash[0], prand[2], prand[1], prand[0]}, nil
+}
+
+// Resolves returns true if addr is a resolvable private address made
+// with the given 16-byte identity resolving key.
+func (addr MacAddress) Resolves(irk []byte) bool {
+	if len(irk) != 16 || addr.RandomKind() != ResolvablePrivate {
+		return false
+	}
+	return ah(irk, [3]byte{addr[5], addr[4], addr[3]}) == [3]byte{addr[2], addr[1], addr[0]}
+}
+
+// ah is the Bluetooth Core specification's random address hash function:
+// the low 24 bits of AES-128 under irk of prand padded with zeros. irk,
+// prand and the hash are most significant byte first.
+func ah(irk []byte, prand [3]byte) [3]byte {
+	var block [16]byte
+	copy(block[13:], prand[:])
+	cipher, _ := aes.NewCipher(irk)
+	cipher.Encrypt(block[:], block[:])
+	return [3]byte{block[13], block[14], block[15]}
+}
@@ -0,0 +1,50 @@
+package beacon
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestAh(t *testing.T) {
+	// the sample data of the Bluetooth Core specification
+	irk, _ := hex.DecodeString("ec0234a357c8ad05341010a60a397d9b")
+	if hash := ah(irk, [3]byte{0x70, 0x81, 0x94}); hash != [3]byte{0x0d, 0xfb, 0xaa} {
+		t.Errorf("got %x; expected 0dfbaa", hash)
+	}
+}
+
+func TestRandomAddresses(t *testing.T) {
+	static, err := NewStaticRandomAddress()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if static.RandomKind() != StaticRandom {
+		t.Errorf("got %v for %v; expected a static random address", static.RandomKind(), static)
+	}
+	nrpa, _ := NewNonResolvableAddress()
+	if nrpa.RandomKind() != NonResolvablePrivate {
+		t.Errorf("got %v for %v; expected a non-resolvable address", nrpa.RandomKind(), nrpa)
+	}
+	if other, _ := NewNonResolvableAddress(); other == nrpa {
+		t.Errorf("got %v twice; expected new addresses", nrpa)
+	}
+
+	irk, _ := hex.DecodeString("ec0234a357c8ad05341010a60a397d9b")
+	rpa, err := NewResolvableAddress(irk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rpa.RandomKind() != ResolvablePrivate {
+		t.Errorf("got %v for %v; expected a resolvable address", rpa.RandomKind(), rpa)
+	}
+	if hash := ah(irk, [3]byte{rpa[5], rpa[4], rpa[3]}); hash != [3]byte{rpa[2], rpa[1], rpa[0]} {
+		t.Errorf("got hash %x in %v; expected %x", [3]byte{rpa[2], rpa[1], rpa[0]}, rpa, hash)
+	}
+	if _, err := NewResolvableAddress(irk[:8]); err == nil {
+		t.Error("expected a short irk to be refused")
+	}
+
+	if kind := ParseMacAddress("80:00:00:00:00:01").RandomKind(); kind != ReservedRandom || kind.String() != "reserved" {
+		t.Errorf("got %v; expected reserved", kind)
+	}
+}
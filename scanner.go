@@ -13,17 +13,28 @@ type Scanner struct {
 	beaconChannel chan Slice
 	done          chan bool
 	beacons       Slice
+	keyring       *Keyring
+	secureIDs     []*SecureID
 }
 
 // ScanData represents a possible beacon advertisement that can be parsed into a beacon
 type ScanData struct {
+	// Bytes is the packet's manufacturer data, including the company id,
+	// or failing that its service data, including the service uuid, as
+	// Parsers expect. It is empty if the packet has neither, as for a
+	// scan response carrying only a name, whatever AdvType is.
 	Bytes       []byte
 	Device      string
 	AddressType uint8
 	AdvType     uint8
 	Channel     uint8
 	RSSI        int8
-	Raw         *[]byte
+	// Raw is the packet as the adapter reported it, with all of its AD
+	// structures, such as a BLE112's gap_scan_response event.
+	Raw *[]byte
+	// Adapter identifies the adapter which received the advertisement,
+	// such as a BLE112's mac address.
+	Adapter string
 }
 
 // A ScanDevice will return ScanData on a channel.  Currently the only implementation is
@@ -41,6 +52,21 @@ func NewScanner(d ScanDevice, p []*Parser) *Scanner {
 	return &s
 }
 
+// SetKeyring makes the Scanner report beacons advertising from resolvable
+// private addresses of devices in k by their identity addresses, so that
+// a device is not reported anew each time its address changes. A nil k
+// stops resolving. It must be called before Scan.
+func (s *Scanner) SetKeyring(k *Keyring) {
+	s.keyring = k
+}
+
+// SetSecureIDs makes the Scanner verify beacons matching any of ids,
+// setting their Verification, so that spoofed beacons can be told apart.
+// It must be called before Scan.
+func (s *Scanner) SetSecureIDs(ids ...*SecureID) {
+	s.secureIDs = ids
+}
+
 // Scan will scan for beacons and return a list of beacons that it detects on the interval
 // given in cycleTime. It will stop scanning when it receives something on the done channel.
 func (s *Scanner) Scan(cycleTime time.Duration, output chan Slice, done chan bool) {
@@ -64,7 +90,7 @@ func (s *Scanner) Scan(cycleTime time.Duration, output chan Slice, done chan boo
 				doneOut <- true
 			case <-timer.C:
 				output <- s.beacons
-				s.beacons = s.beacons[:0] // clear beacons slice
+				s.beacons = nil // the receiver now owns the old slice
 				timer = time.NewTimer(cycleTime)
 			}
 		}
@@ -77,16 +103,26 @@ func (s *Scanner) Scan(cycleTime time.Duration, output chan Slice, done chan boo
 }
 
 func (s *Scanner) processScan(scan ScanData) {
+	if s.keyring != nil {
+		scan = s.keyring.ResolveScan(scan)
+	}
 	beacon := Parse(scan.Bytes, s.parsers)
 	if beacon == nil {
 		return
 	}
 	beacon.Device = scan.Device
+	if len(s.secureIDs) > 0 {
+		beacon.Verification = VerifySecureIDs(s.secureIDs, beacon, time.Now())
+	}
 	found := s.beacons.Find(beacon)
-	if found != nil {
-		found.AddRSSI(scan.RSSI)
-	} else {
-		beacon.AddRSSI(scan.RSSI)
+	if found == nil {
+		found = beacon
 		s.beacons = append(s.beacons, beacon)
 	}
+	found.Verification = beacon.Verification
+	if scan.Adapter != "" {
+		found.AddAdapterRSSI(scan.Adapter, scan.RSSI)
+	} else {
+		found.AddRSSI(scan.RSSI)
+	}
 }
@@ -0,0 +1,298 @@
+package beacon_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+	"github.com/RadiusNetworks/go-beacon/ble112"
+	"github.com/RadiusNetworks/go-beacon/ble112/emulator"
+)
+
+func TestScannerScan(t *testing.T) {
+	altBeacon := beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 1, 2, -59)
+	urlBeacon, _ := beacon.NewEddystoneURLBeacon("https://radiusnetworks.com", -41)
+	altBeaconAd := beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"]).GenerateAd(altBeacon)
+	urlBeaconAd := beacon.NewParser("eddystone_url", beacon.DefaultLayouts["eddystone_url"]).GenerateAd(urlBeacon)
+
+	e := emulator.New(emulator.Config{
+		Beacons: []emulator.Beacon{
+			{Address: beacon.MacAddress{1}, Data: emulator.MfgData(0x0118, altBeaconAd), RSSI: -60},
+			{Address: beacon.MacAddress{2}, Data: emulator.ServiceData(0xfeaa, urlBeaconAd), RSSI: -70},
+		},
+		RSSINoise: 2,
+	})
+	device, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Close()
+
+	scanner := beacon.NewScanner(device, beacon.DefaultParsers())
+	output := make(chan beacon.Slice)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		scanner.Scan(100*time.Millisecond, output, done)
+		finished <- true
+	}()
+
+	var beacons beacon.Slice
+	for len(beacons) == 0 {
+		select {
+		case beacons = <-output:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beacons")
+		}
+	}
+
+	if len(beacons) != 2 {
+		t.Fatalf("got %v; expected two beacons", beacons)
+	}
+	found := beacons.Find(&beacon.Beacon{Ids: altBeacon.Ids, Device: beacon.MacAddress{1}.String()})
+	if found == nil {
+		t.Fatalf("altbeacon not found in %v", beacons)
+	}
+	if rssi := found.RSSI(); rssi < -62 || rssi > -58 {
+		t.Errorf("got rssi %v; expected about -60", rssi)
+	}
+	if found := beacons.Find(&beacon.Beacon{Ids: urlBeacon.Ids, Device: beacon.MacAddress{2}.String()}); found == nil {
+		t.Errorf("eddystone url beacon not found in %v", beacons)
+	}
+
+	stop := done
+	for {
+		select {
+		case <-output:
+		case stop <- true:
+			stop = nil
+		case <-finished:
+			return
+		}
+	}
+}
+
+func TestScannerMultiScanDevice(t *testing.T) {
+	altBeacon := beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 1, 2, -59)
+	ad := emulator.MfgData(0x0118, beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"]).GenerateAd(altBeacon))
+
+	near, far := beacon.MacAddress{0x0a, 0, 0, 0x80, 0x07, 0}, beacon.MacAddress{0x0b, 0, 0, 0x80, 0x07, 0}
+	var devices []beacon.ScanDevice
+	for _, adapter := range []struct {
+		address beacon.MacAddress
+		rssi    int8
+	}{{near, -50}, {far, -80}} {
+		e := emulator.New(emulator.Config{
+			Address: adapter.address,
+			Beacons: []emulator.Beacon{{Address: beacon.MacAddress{1}, Data: ad, RSSI: adapter.rssi}},
+		})
+		device, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer device.Close()
+		devices = append(devices, device)
+	}
+
+	scanner := beacon.NewScanner(beacon.NewMultiScanDevice(devices...), beacon.DefaultParsers())
+	output := make(chan beacon.Slice)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		scanner.Scan(100*time.Millisecond, output, done)
+		finished <- true
+	}()
+
+	var beacons beacon.Slice
+	for len(beacons) == 0 {
+		select {
+		case beacons = <-output:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beacons")
+		}
+	}
+	if len(beacons) != 1 {
+		t.Fatalf("got %v; expected one beacon seen by both adapters", beacons)
+	}
+	b := beacons[0]
+	if adapters := b.Adapters(); len(adapters) != 2 {
+		t.Errorf("got adapters %v; expected both", adapters)
+	}
+	if rssi, ok := b.AdapterRSSI(near.String()); !ok || rssi != -50 {
+		t.Errorf("got rssi %v from the near adapter; expected -50", rssi)
+	}
+	if rssi, ok := b.AdapterRSSI(far.String()); !ok || rssi != -80 {
+		t.Errorf("got rssi %v from the far adapter; expected -80", rssi)
+	}
+	if rssi := b.RSSI(); rssi >= -50 || rssi <= -80 {
+		t.Errorf("got overall rssi %v; expected between the adapters'", rssi)
+	}
+
+	stop := done
+	for {
+		select {
+		case <-output:
+		case stop <- true:
+			stop = nil
+		case <-finished:
+			return
+		}
+	}
+}
+
+func TestScannerKeyring(t *testing.T) {
+	altBeacon := beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 1, 2, -59)
+	ad := emulator.MfgData(0x0118, beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"]).GenerateAd(altBeacon))
+
+	// the same device advertising before and after changing its address
+	rotated, _ := beacon.NewResolvableAddress(sampleIRK)
+	e := emulator.New(emulator.Config{
+		Beacons: []emulator.Beacon{
+			{Address: sampleRPA, AddressType: beacon.RandomAddressType, Data: ad, RSSI: -60},
+			{Address: rotated, AddressType: beacon.RandomAddressType, Data: ad, RSSI: -60},
+		},
+	})
+	device, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Close()
+
+	keyring := beacon.NewKeyring()
+	keyring.Add(identity, sampleIRK)
+	scanner := beacon.NewScanner(device, beacon.DefaultParsers())
+	scanner.SetKeyring(keyring)
+	output := make(chan beacon.Slice)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		scanner.Scan(100*time.Millisecond, output, done)
+		finished <- true
+	}()
+
+	var beacons beacon.Slice
+	for len(beacons) == 0 {
+		select {
+		case beacons = <-output:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beacons")
+		}
+	}
+	if len(beacons) != 1 || beacons[0].Device != identity.Address.String() {
+		t.Errorf("got %v; expected one beacon at the identity address", beacons)
+	}
+
+	stop := done
+	for {
+		select {
+		case <-output:
+		case stop <- true:
+			stop = nil
+		case <-finished:
+			return
+		}
+	}
+}
+
+func TestScannerSecureIDs(t *testing.T) {
+	id := newSecureID()
+	genuine, _ := id.Beacon(secureAltBeacon, time.Now())
+	spoofed, _ := id.Beacon(secureAltBeacon, time.Now().Add(-time.Hour))
+	parser := beacon.NewParser("altbeacon", beacon.DefaultLayouts["altbeacon"])
+	e := emulator.New(emulator.Config{
+		Beacons: []emulator.Beacon{
+			{Address: beacon.MacAddress{1}, Data: emulator.MfgData(0x0118, parser.GenerateAd(genuine)), RSSI: -60},
+			{Address: beacon.MacAddress{2}, Data: emulator.MfgData(0x0118, parser.GenerateAd(spoofed)), RSSI: -60},
+		},
+	})
+	device, err := ble112.NewDeviceWithOpener("emulated", e.Opener())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Close()
+
+	scanner := beacon.NewScanner(device, beacon.DefaultParsers())
+	scanner.SetSecureIDs(id)
+	output := make(chan beacon.Slice)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		scanner.Scan(100*time.Millisecond, output, done)
+		finished <- true
+	}()
+
+	var beacons beacon.Slice
+	for len(beacons) < 2 {
+		select {
+		case beacons = <-output:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beacons")
+		}
+	}
+	verifications := make(map[string]beacon.Verification)
+	for _, b := range beacons {
+		verifications[b.Device] = b.Verification
+	}
+	if v := verifications[beacon.MacAddress{1}.String()]; v != beacon.Verified {
+		t.Errorf("got %v; expected the genuine beacon verified", v)
+	}
+	if v := verifications[beacon.MacAddress{2}.String()]; v != beacon.Spoofed {
+		t.Errorf("got %v; expected the replayed beacon spoofed", v)
+	}
+
+	stop := done
+	for {
+		select {
+		case <-output:
+		case stop <- true:
+			stop = nil
+		case <-finished:
+			return
+		}
+	}
+}
+
+// stubScanDevice sends the same ScanData until done.
+type stubScanDevice struct {
+	scan beacon.ScanData
+}
+
+func (d stubScanDevice) Scan(data chan beacon.ScanData, done chan bool) {
+	defer close(data)
+	for {
+		select {
+		case data <- d.scan:
+		case <-done:
+			return
+		}
+	}
+}
+
+func TestMultiScanDeviceShutdown(t *testing.T) {
+	m := beacon.NewMultiScanDevice(
+		stubScanDevice{beacon.ScanData{Device: "a"}},
+		stubScanDevice{beacon.ScanData{Device: "b", Adapter: "tagged"}},
+	)
+	data := make(chan beacon.ScanData)
+	done := make(chan bool)
+	go m.Scan(data, done)
+
+	adapters := make(map[string]bool)
+	for len(adapters) < 2 {
+		scan := <-data
+		adapters[scan.Adapter] = true
+	}
+	if !adapters["adapter0"] || !adapters["tagged"] {
+		t.Errorf("got adapters %v; expected adapter0 and tagged", adapters)
+	}
+
+	done <- true
+	select {
+	case _, more := <-data:
+		for more {
+			_, more = <-data
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan did not stop")
+	}
+}
@@ -0,0 +1,200 @@
+package beacon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultSecurePeriod is how often a SecureID rotates when its Period is
+// not set.
+const DefaultSecurePeriod = 10 * time.Minute
+
+// A Verification says whether a beacon's rotating secure id checked out.
+type Verification int
+
+// Verifications.
+const (
+	// Unverified beacons are not covered by any SecureID.
+	Unverified Verification = iota
+	// Verified beacons carry the id their SecureID expects.
+	Verified
+	// Spoofed beacons look like those of a SecureID, but carry an id it
+	// does not expect: a copy replayed too late, or a forgery.
+	Spoofed
+)
+
+func (v Verification) String() string {
+	switch v {
+	case Verified:
+		return "verified"
+	case Spoofed:
+		return "spoofed"
+	}
+	return "unverified"
+}
+
+// A FieldRef picks out one of a beacon's fields: an id, or a data field
+// if Data is set.
+type FieldRef struct {
+	Data  bool
+	Index int
+}
+
+func (r FieldRef) get(b *Beacon) (Field, bool) {
+	fields := b.Ids
+	if r.Data {
+		fields = b.Data
+	}
+	if r.Index < 0 || r.Index >= len(fields) {
+		return nil, false
+	}
+	return fields[r.Index], true
+}
+
+// A SecureID is a scheme for tamper-evident beacons on standard layouts,
+// such as altbeacon: one field of the beacon, typically the minor, is
+// replaced by a truncated HMAC-SHA256, under a secret shared by the
+// beacon and its scanners, of the beacon's type and other ids and the
+// current time window. Only holders of the secret can predict the field,
+// and a recorded advertisement stops verifying once its window passes.
+type SecureID struct {
+	// Type and Ids are the beacon's type and ids. The rotated field, if
+	// it is an id, is ignored, and may be left empty.
+	Type string
+	Ids  Fields
+	// Rotated is the field which rotates.
+	Rotated FieldRef
+	// Secret is the shared secret, which should be at least 16 bytes.
+	Secret []byte
+	// Period is how often the field rotates, counted from Epoch. Zero
+	// means DefaultSecurePeriod, and a zero Epoch the Unix epoch.
+	Period time.Duration
+	Epoch  time.Time
+	// Skew is how many windows either side of the current one Verify
+	// accepts, to tolerate clocks which disagree.
+	Skew int
+}
+
+// ErrNoRotatedField is returned when a beacon lacks the field which a
+// SecureID rotates.
+var ErrNoRotatedField = errors.New("beacon: no field to rotate")
+
+func (s *SecureID) period() time.Duration {
+	if s.Period == 0 {
+		return DefaultSecurePeriod
+	}
+	return s.Period
+}
+
+func (s *SecureID) epoch() time.Time {
+	if s.Epoch.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return s.Epoch
+}
+
+// Window returns the number of the time window containing t.
+func (s *SecureID) Window(t time.Time) int64 {
+	d := t.Sub(s.epoch())
+	w := int64(d / s.period())
+	if d < 0 && d%s.period() != 0 {
+		w-- // round towards the past
+	}
+	return w
+}
+
+// NextRotation returns when the window after the one containing t starts.
+func (s *SecureID) NextRotation(t time.Time) time.Time {
+	return s.epoch().Add(time.Duration(s.Window(t)+1) * s.period())
+}
+
+// Field returns the rotated field for the given window, n bytes long.
+func (s *SecureID) Field(window int64, n int) Field {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(s.Type))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(window))
+	mac.Write(b[:])
+	for i, id := range s.Ids {
+		if !s.Rotated.Data && i == s.Rotated.Index {
+			continue
+		}
+		binary.BigEndian.PutUint16(b[:2], uint16(len(id)))
+		mac.Write(b[:2])
+		mac.Write(id)
+	}
+	sum := mac.Sum(nil)
+	if n > len(sum) {
+		n = len(sum)
+	}
+	return Field(sum[:n])
+}
+
+// Beacon returns a copy of template, which must have the rotated field,
+// carrying the field for the window containing t.
+func (s *SecureID) Beacon(template *Beacon, t time.Time) (*Beacon, error) {
+	field, ok := s.Rotated.get(template)
+	if !ok || len(field) == 0 {
+		return nil, fmt.Errorf("%w: %v has no %+v", ErrNoRotatedField, template.Type, s.Rotated)
+	}
+	b := NewBeacon(template.Type, append(Fields(nil), template.Ids...), append(Fields(nil), template.Data...), template.Power)
+	rotated := s.Field(s.Window(t), len(field))
+	if s.Rotated.Data {
+		b.Data[s.Rotated.Index] = rotated
+	} else {
+		b.Ids[s.Rotated.Index] = rotated
+	}
+	return &b, nil
+}
+
+// Matches returns true if b is of the SecureID's type and has its ids,
+// whatever its rotated field.
+func (s *SecureID) Matches(b *Beacon) bool {
+	if b.Type != s.Type || len(b.Ids) != len(s.Ids) {
+		return false
+	}
+	for i, id := range s.Ids {
+		if !s.Rotated.Data && i == s.Rotated.Index {
+			continue
+		}
+		if !id.Equal(b.Ids[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify returns Unverified if b does not match the SecureID, Verified if
+// it carries the field for a window within Skew of the one containing t,
+// and Spoofed otherwise.
+func (s *SecureID) Verify(b *Beacon, t time.Time) Verification {
+	if !s.Matches(b) {
+		return Unverified
+	}
+	field, ok := s.Rotated.get(b)
+	if !ok || len(field) == 0 {
+		return Spoofed
+	}
+	window := s.Window(t)
+	for w := window - int64(s.Skew); w <= window+int64(s.Skew); w++ {
+		if hmac.Equal(field, s.Field(w, len(field))) {
+			return Verified
+		}
+	}
+	return Spoofed
+}
+
+// VerifySecureIDs returns the verification of b by the first of ids which
+// it matches, and Unverified if none.
+func VerifySecureIDs(ids []*SecureID, b *Beacon, t time.Time) Verification {
+	for _, id := range ids {
+		if v := id.Verify(b, t); v != Unverified {
+			return v
+		}
+	}
+	return Unverified
+}
@@ -0,0 +1,80 @@
+package beacon_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RadiusNetworks/go-beacon"
+)
+
+var secureAltBeacon = beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 7, 0, -59)
+
+func newSecureID() *beacon.SecureID {
+	return &beacon.SecureID{
+		Type:    "altbeacon",
+		Ids:     secureAltBeacon.Ids,
+		Rotated: beacon.FieldRef{Index: 2}, // the minor
+		Secret:  []byte("0123456789abcdef"),
+		Period:  time.Minute,
+		Epoch:   time.Unix(0, 0),
+		Skew:    1,
+	}
+}
+
+func TestSecureID(t *testing.T) {
+	id := newSecureID()
+	now := time.Unix(600, 0)
+	b, err := id.Beacon(secureAltBeacon, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Ids[2].Equal(secureAltBeacon.Ids[2]) || !b.Ids[1].Equal(secureAltBeacon.Ids[1]) {
+		t.Errorf("got ids %v; expected only the minor to change", b.Ids)
+	}
+	if len(b.Ids[2]) != 2 || !secureAltBeacon.Ids[2].Equal(beacon.FieldFromUint16(0)) {
+		t.Errorf("got minor %v; expected 2 bytes and the template untouched", b.Ids[2])
+	}
+
+	for _, c := range []struct {
+		at       time.Duration
+		expected beacon.Verification
+	}{
+		{0, beacon.Verified},
+		{time.Minute, beacon.Verified},
+		{-time.Minute, beacon.Verified},
+		{2 * time.Minute, beacon.Spoofed},
+		{-2 * time.Minute, beacon.Spoofed},
+	} {
+		if v := id.Verify(b, now.Add(c.at)); v != c.expected {
+			t.Errorf("%v later: got %v; expected %v", c.at, v, c.expected)
+		}
+	}
+
+	forged := *b
+	forged.Ids = beacon.Fields{b.Ids[0], b.Ids[1], beacon.FieldFromUint16(b.Ids[2].Uint16() + 1)}
+	if v := id.Verify(&forged, now); v != beacon.Spoofed {
+		t.Errorf("got %v; expected a forged minor to be spoofed", v)
+	}
+	other := beacon.NewAltBeacon("e858fc8a-372b-4bef-a053-93f98cd4e177", 8, 0, -59)
+	if v := beacon.VerifySecureIDs([]*beacon.SecureID{id}, other, now); v != beacon.Unverified {
+		t.Errorf("got %v; expected another major to be unverified", v)
+	}
+
+	// the field depends on the other ids, so it cannot be moved between
+	// beacons
+	id2 := newSecureID()
+	id2.Ids = other.Ids
+	b2, _ := id2.Beacon(other, now)
+	if b2.Ids[2].Equal(b.Ids[2]) {
+		t.Error("got the same minor for different majors")
+	}
+
+	if next := id.NextRotation(time.Unix(-30, 0)); !next.Equal(time.Unix(0, 0)) {
+		t.Errorf("got next rotation %v; expected the epoch", next)
+	}
+	id.Rotated = beacon.FieldRef{Data: true, Index: 1}
+	if _, err := id.Beacon(secureAltBeacon, now); !errors.Is(err, beacon.ErrNoRotatedField) {
+		t.Errorf("got %v; expected ErrNoRotatedField", err)
+	}
+}